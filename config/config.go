@@ -3,8 +3,10 @@ package config
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	_ "modernc.org/sqlite"
 	"os"
+	"strconv"
 )
 
 type Config struct {
@@ -12,6 +14,12 @@ type Config struct {
 	Path            string `json:"path"`
 	ReferenceKey    string `json:"reference_key"`
 	ReferenceHeader string `json:"reference_header"`
+	// ResultCacheSize is the number of results of operations held in memory, see resultcache.New
+	ResultCacheSize int `json:"result_cache_size"`
+	// ResultCachePersist also keeps the results in the database so they survive a restart
+	ResultCachePersist bool `json:"result_cache_persist"`
+	// JobWorkers is the number of jobs run at once in the background
+	JobWorkers int `json:"job_workers"`
 }
 
 //NewConfig
@@ -75,15 +83,40 @@ It retrieves the following variables:
 - PATH: The path for the resource.
 - REFERENCE_KEY: A key used for referencing.
 - REFERENCE_HEADER: A header used for referencing.
+- RESULT_CACHE_SIZE: The number of results of operations held in memory.
+- RESULT_CACHE_PERSIST: 'true' to also keep the results in the database.
+- JOB_WORKERS: The number of jobs run at once in the background.
 
 Returns a pointer to a Config struct populated with these values,
 or an error if any required environment variable is missing.
 */
 func loadFromEnv() (*Config, error) {
-	return &Config{
+	cfg := &Config{
 		Address:         os.Getenv("ADDRESS"),
 		Path:            os.Getenv("PATH"),
 		ReferenceKey:    os.Getenv("REFERENCE_KEY"),
 		ReferenceHeader: os.Getenv("REFERENCE_HEADER"),
-	}, nil
+	}
+	if size := os.Getenv("RESULT_CACHE_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("RESULT_CACHE_SIZE must be a number: %w", err)
+		}
+		cfg.ResultCacheSize = n
+	}
+	if persist := os.Getenv("RESULT_CACHE_PERSIST"); persist != "" {
+		p, err := strconv.ParseBool(persist)
+		if err != nil {
+			return nil, fmt.Errorf("RESULT_CACHE_PERSIST must be true or false: %w", err)
+		}
+		cfg.ResultCachePersist = p
+	}
+	if workers := os.Getenv("JOB_WORKERS"); workers != "" {
+		n, err := strconv.Atoi(workers)
+		if err != nil {
+			return nil, fmt.Errorf("JOB_WORKERS must be a number: %w", err)
+		}
+		cfg.JobWorkers = n
+	}
+	return cfg, nil
 }
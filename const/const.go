@@ -22,5 +22,65 @@ const UserFileTable = `CREATE TABLE IF NOT EXISTS user_files (
     user_id INTEGER NOT NULL,
     name TEXT NOT NULL,
     upload_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+    size INTEGER NOT NULL DEFAULT 0,
+    mime_type TEXT NOT NULL DEFAULT '',
+    sha256 TEXT NOT NULL DEFAULT '',
+    delimiter TEXT NOT NULL DEFAULT ',',
+    header TEXT NOT NULL DEFAULT '[]',
+    row_count INTEGER NOT NULL DEFAULT 0,
+    column_count INTEGER NOT NULL DEFAULT 0,
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 )`
+
+// FileLineageTable records the files a derived file was produced from, see container.Lineage.
+const FileLineageTable = `CREATE TABLE IF NOT EXISTS file_lineage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    file_id INTEGER NOT NULL,
+    source_id INTEGER,
+    source_name TEXT NOT NULL,
+    source_sha256 TEXT NOT NULL DEFAULT '',
+    operation TEXT NOT NULL,
+    parameters TEXT NOT NULL DEFAULT '{}',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (file_id) REFERENCES user_files(id) ON DELETE CASCADE,
+    FOREIGN KEY (source_id) REFERENCES user_files(id) ON DELETE SET NULL
+)`
+
+// JobTable holds the operations run in the background and their results, see container.Job.
+const JobTable = `CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    file_id INTEGER NOT NULL,
+    operation TEXT NOT NULL,
+    query TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'queued',
+    progress REAL NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    result_status INTEGER NOT NULL DEFAULT 0,
+    result_header TEXT NOT NULL DEFAULT '{}',
+    result BLOB,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    started_at DATETIME,
+    finished_at DATETIME
+)`
+
+// ResultCacheTable persists the results of operations on files, see resultcache.Cache.
+const ResultCacheTable = `CREATE TABLE IF NOT EXISTS result_cache (
+    key TEXT PRIMARY KEY,
+    sha256 TEXT NOT NULL,
+    header TEXT NOT NULL,
+    body BLOB NOT NULL,
+    accessed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`
+
+// UserFileMetadataColumns are the column definitions added to user_files after it was first created,
+// they are added to existing databases that do not have them yet.
+var UserFileMetadataColumns = []string{
+	"size INTEGER NOT NULL DEFAULT 0",
+	"mime_type TEXT NOT NULL DEFAULT ''",
+	"sha256 TEXT NOT NULL DEFAULT ''",
+	"delimiter TEXT NOT NULL DEFAULT ','",
+	"header TEXT NOT NULL DEFAULT '[]'",
+	"row_count INTEGER NOT NULL DEFAULT 0",
+	"column_count INTEGER NOT NULL DEFAULT 0",
+}
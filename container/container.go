@@ -1,6 +1,9 @@
 package container
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID       uint32 `json:"id"`
@@ -10,8 +13,61 @@ type User struct {
 }
 
 type File struct {
-	ID         uint32    `json:"id"`
-	UserID     uint32    `json:"user_id"`
-	Name       string    `json:"name"`
-	UploadTime time.Time `json:"upload_time"`
+	ID          uint32    `json:"id"`
+	UserID      uint32    `json:"user_id"`
+	Name        string    `json:"name"`
+	UploadTime  time.Time `json:"upload_time"`
+	Size        int64     `json:"size"`
+	MimeType    string    `json:"mime_type"`
+	SHA256      string    `json:"sha256"`
+	Delimiter   string    `json:"delimiter"`
+	Header      []string  `json:"header"`
+	RowCount    int64     `json:"row_count"`
+	ColumnCount int       `json:"column_count"`
+}
+
+//Lineage
+/*
+Records a file that the file `FileID` was derived from by `Operation`, with the JSON encoded `Parameters` of the operation.
+The name and SHA-256 of the source are kept as they were, `SourceID` is null once the source file has been deleted.
+*/
+type Lineage struct {
+	ID           uint32          `json:"id"`
+	FileID       uint32          `json:"file_id"`
+	SourceID     *uint32         `json:"source_id"`
+	SourceName   string          `json:"source_name"`
+	SourceSHA256 string          `json:"source_sha256"`
+	Operation    string          `json:"operation"`
+	Parameters   json.RawMessage `json:"parameters"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+//Job
+/*
+An operation run against the stored data of the file `FileID` in the background, requested with the query `Query`
+as it would be on the file itself. `Progress` is the fraction of the file read so far, and `Attempts` the number
+of times the job was started, as jobs interrupted by a restart or failing unexpectedly are run again.
+*/
+type Job struct {
+	ID         uint32     `json:"id"`
+	FileID     uint32     `json:"file_id"`
+	Operation  string     `json:"operation"`
+	Query      string     `json:"query"`
+	Status     string     `json:"status"`
+	Progress   float64    `json:"progress"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+//JobResult
+/*
+The response written by the operation of a job: its status code, JSON encoded headers and body.
+*/
+type JobResult struct {
+	Status int
+	Header json.RawMessage
+	Body   []byte
 }
@@ -1,6 +1,7 @@
 package predicate
 
 import (
+	"api-3390/csvutil"
 	"bytes"
 	"encoding/csv"
 	"fmt"
@@ -25,8 +26,9 @@ var CSVContainsNumeric = Predicate[io.Reader]{
 			return false
 		}
 
-		// Create a new reader from the buffer
+		// Create a new reader from the buffer using the delimiter sniffed from the data
 		reader := csv.NewReader(&buf)
+		reader.Comma = csvutil.SniffDelimiter(buf.Bytes())
 
 		// Read all records from the new reader
 		records, err := reader.ReadAll()
@@ -0,0 +1,455 @@
+package csvjoin
+
+import (
+	"api-3390/csvutil"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of join through Options.How.
+const (
+	Inner = "inner"
+	Left  = "left"
+	Full  = "full"
+)
+
+// DefaultMemoryLimit is the number of bytes of the right file held in memory at once when no limit is given.
+const DefaultMemoryLimit = 64 << 20
+
+// maxPartitions bounds the number of partitions the files are split into when the right file does not fit in memory.
+const maxPartitions = 256
+
+// memoryOverhead estimates how many bytes of memory a byte of a record takes once it is held in a hash table.
+const memoryOverhead = 3
+
+// DefaultSuffix is appended to the columns of the right file named like a column of the left file.
+const DefaultSuffix = "_right"
+
+//Source
+/*
+A CSV file to join on the columns `Keys`, matched case-insensitively against its header.
+*/
+type Source struct {
+	Path      string
+	Delimiter rune
+	Keys      []string
+}
+
+//Options
+/*
+How two files are joined: `How` is 'inner' (the default), 'left' or 'full',
+columns of the right file named like a column of the left file are renamed with `Suffix`, DefaultSuffix when empty,
+and at most `MemoryLimit` bytes of the right file are held in memory, DefaultMemoryLimit when it is zero.
+Spilled partitions are written to `TempDir`, the default directory for temporary files when it is empty.
+*/
+type Options struct {
+	How         string
+	Suffix      string
+	MemoryLimit int64
+	TempDir     string
+	Delimiter   rune
+}
+
+//Result
+/*
+Describes a join: the columns of the joined file, the number of rows written,
+how many of them paired a row of each file and the number of partitions the files were split into,
+1 when the right file was held in memory.
+*/
+type Result struct {
+	Columns    []string `json:"columns"`
+	Rows       int64    `json:"rows"`
+	Matched    int64    `json:"matched"`
+	Partitions int      `json:"partitions"`
+}
+
+// Error is returned when a join is asked for with invalid options or columns.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//side
+/*
+One of the files of a join: its header and the index of each of its key columns.
+*/
+type side struct {
+	Source
+	header []string
+	keys   []int
+}
+
+//key
+/*
+Returns the key of `record` made of its trimmed key fields, or false when any of them is empty as such rows never match.
+*/
+func (s *side) key(record []string) (string, bool) {
+	fields := make([]string, len(s.keys))
+	for i, k := range s.keys {
+		if k >= len(record) {
+			return "", false
+		}
+		fields[i] = strings.TrimSpace(record[k])
+		if fields[i] == "" {
+			return "", false
+		}
+	}
+	return strings.Join(fields, "\x00"), true
+}
+
+func openSide(src Source) (*side, error) {
+	file, err := os.Open(src.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := csvutil.NewReader(file, src.Delimiter).Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, &Error{Message: fmt.Sprintf("'%s' is empty", filepath.Base(src.Path))}
+		}
+		return nil, err
+	}
+	s := &side{Source: src, header: append([]string(nil), header...)}
+	var missing []string
+	for _, name := range src.Keys {
+		index := -1
+		for i, h := range s.header {
+			if strings.EqualFold(h, name) {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			missing = append(missing, name)
+		}
+		s.keys = append(s.keys, index)
+	}
+	if len(missing) > 0 {
+		return nil, &Error{Message: fmt.Sprintf("column not found in '%s': %s", filepath.Base(src.Path), strings.Join(missing, ", "))}
+	}
+	return s, nil
+}
+
+//joiner
+/*
+Writes the joined rows: every column of the left file followed by the columns of the right file that are not keys.
+*/
+type joiner struct {
+	how         string
+	left, right *side
+	// rightColumns are the indexes of the columns of the right file that are written
+	rightColumns []int
+	writer       *csv.Writer
+	row          []string
+	result       *Result
+}
+
+func newJoiner(left, right *side, opts Options, w io.Writer) *joiner {
+	j := &joiner{how: opts.How, left: left, right: right, writer: csv.NewWriter(w), result: &Result{}}
+	if opts.Delimiter != 0 {
+		j.writer.Comma = opts.Delimiter
+	}
+	isKey := make(map[int]bool, len(right.keys))
+	for _, k := range right.keys {
+		isKey[k] = true
+	}
+	names := make(map[string]bool, len(left.header))
+	for _, h := range left.header {
+		names[strings.ToLower(h)] = true
+	}
+	j.result.Columns = append(j.result.Columns, left.header...)
+	for i, h := range right.header {
+		if isKey[i] {
+			continue
+		}
+		j.rightColumns = append(j.rightColumns, i)
+		for names[strings.ToLower(h)] {
+			h += opts.Suffix
+		}
+		names[strings.ToLower(h)] = true
+		j.result.Columns = append(j.result.Columns, h)
+	}
+	j.row = make([]string, len(j.result.Columns))
+	return j
+}
+
+//emit
+/*
+Writes the row joining `left` with `right`, either of which may be nil when the row of the other file has no match,
+the key columns of a row without a left side are taken from its right side.
+*/
+func (j *joiner) emit(left, right []string) error {
+	for i := range j.row {
+		j.row[i] = ""
+	}
+	copy(j.row, left)
+	if left == nil {
+		for i, k := range j.left.keys {
+			if j.right.keys[i] < len(right) {
+				j.row[k] = right[j.right.keys[i]]
+			}
+		}
+	}
+	if right != nil {
+		for i, c := range j.rightColumns {
+			if c < len(right) {
+				j.row[len(j.left.header)+i] = right[c]
+			}
+		}
+	}
+	j.result.Rows++
+	if left != nil && right != nil {
+		j.result.Matched++
+	}
+	return j.writer.Write(j.row)
+}
+
+type buildRow struct {
+	record  []string
+	matched bool
+}
+
+//hashJoin
+/*
+Joins the rows read from `probe`, of the left file, with the rows read from `build`, of the right file,
+holding the rows of `build` in a hash table by key.
+*/
+func (j *joiner) hashJoin(probe, build *csv.Reader) error {
+	table := make(map[string][]*buildRow)
+	var rows []*buildRow
+	err := eachRecord(build, func(record []string) error {
+		row := &buildRow{record: append([]string(nil), record...)}
+		if j.how == Full {
+			rows = append(rows, row)
+		}
+		if key, ok := j.right.key(record); ok {
+			table[key] = append(table[key], row)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = eachRecord(probe, func(record []string) error {
+		var matches []*buildRow
+		if key, ok := j.left.key(record); ok {
+			matches = table[key]
+		}
+		if len(matches) == 0 && j.how != Inner {
+			return j.emit(record, nil)
+		}
+		for _, m := range matches {
+			m.matched = true
+			if err := j.emit(record, m.record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if !row.matched {
+			if err := j.emit(nil, row.record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func eachRecord(reader *csv.Reader, yield func(record []string) error) error {
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := yield(record); err != nil {
+			return err
+		}
+	}
+}
+
+//Join
+/*
+Joins the CSV file `left` with the CSV file `right` on their key columns as described by `opts`, writing the joined rows
+to `w` as CSV with a header row. The key columns are compared as trimmed strings, rows with an empty key never match.
+
+When the right file fits in the memory limit it is held in a hash table and the left file is streamed past it,
+so the rows are written in the order of the left file followed by the unmatched rows of the right file in a full join.
+Otherwise both files are split by the hash of their keys into partitions spilled to temporary files,
+each pair of partitions is joined in turn and the rows are written in no particular order.
+*/
+func Join(left, right Source, opts Options, w io.Writer) (*Result, error) {
+	switch opts.How {
+	case "":
+		opts.How = Inner
+	case Inner, Left, Full:
+	default:
+		return nil, &Error{Message: fmt.Sprintf("how must be one of: %s, %s, %s", Inner, Left, Full)}
+	}
+	if opts.Suffix == "" {
+		opts.Suffix = DefaultSuffix
+	}
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = DefaultMemoryLimit
+	}
+	if len(left.Keys) == 0 || len(left.Keys) != len(right.Keys) {
+		return nil, &Error{Message: "both files must be joined on the same number of key columns"}
+	}
+	l, err := openSide(left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := openSide(right)
+	if err != nil {
+		return nil, err
+	}
+	j := newJoiner(l, r, opts, w)
+	if err := j.writer.Write(j.result.Columns); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(right.Path)
+	if err != nil {
+		return nil, err
+	}
+	partitions := int(min(info.Size()*memoryOverhead/opts.MemoryLimit+1, maxPartitions))
+	j.result.Partitions = partitions
+	if partitions == 1 {
+		err = withReaders(l, r, func(probe, build *csv.Reader) error {
+			return j.hashJoin(probe, build)
+		})
+	} else {
+		err = j.partitionedJoin(partitions, opts.TempDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.writer.Flush()
+	if err := j.writer.Error(); err != nil {
+		return nil, err
+	}
+	return j.result, nil
+}
+
+//withReaders
+/*
+Opens both files of a join past their header rows and calls `fn` with a reader over each.
+*/
+func withReaders(left, right *side, fn func(probe, build *csv.Reader) error) error {
+	lf, err := os.Open(left.Path)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	rf, err := os.Open(right.Path)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+	probe, build := csvutil.NewReader(lf, left.Delimiter), csvutil.NewReader(rf, right.Delimiter)
+	if _, err := probe.Read(); err != nil {
+		return err
+	}
+	if _, err := build.Read(); err != nil {
+		return err
+	}
+	return fn(probe, build)
+}
+
+//partitionedJoin
+/*
+Splits both files into `partitions` temporary files by the hash of their keys and joins each pair of partitions,
+rows with the same key always land in partitions with the same index.
+*/
+func (j *joiner) partitionedJoin(partitions int, tempDir string) error {
+	dir, err := os.MkdirTemp(tempDir, "join-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	err = withReaders(j.left, j.right, func(probe, build *csv.Reader) error {
+		if err := partition(probe, j.left, partitions, filepath.Join(dir, "left")); err != nil {
+			return err
+		}
+		return partition(build, j.right, partitions, filepath.Join(dir, "right"))
+	})
+	if err != nil {
+		return err
+	}
+	for p := 0; p < partitions; p++ {
+		err := func() error {
+			lf, err := os.Open(partitionPath(filepath.Join(dir, "left"), p))
+			if err != nil {
+				return err
+			}
+			defer lf.Close()
+			rf, err := os.Open(partitionPath(filepath.Join(dir, "right"), p))
+			if err != nil {
+				return err
+			}
+			defer rf.Close()
+			return j.hashJoin(csvutil.NewReader(lf, 0), csvutil.NewReader(rf, 0))
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func partitionPath(prefix string, p int) string {
+	return fmt.Sprintf("%s-%03d.csv", prefix, p)
+}
+
+//partition
+/*
+Writes every record read from `reader` to the partition picked by the hash of its key,
+rows with an empty key never match and are written to the first partition.
+*/
+func partition(reader *csv.Reader, s *side, partitions int, prefix string) error {
+	writers := make([]*csv.Writer, partitions)
+	for p := range writers {
+		file, err := os.Create(partitionPath(prefix, p))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writers[p] = csv.NewWriter(file)
+	}
+	err := eachRecord(reader, func(record []string) error {
+		p := 0
+		if key, ok := s.key(record); ok {
+			h := fnv.New32a()
+			h.Write([]byte(key))
+			p = int(h.Sum32() % uint32(partitions))
+		}
+		return writers[p].Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range writers {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
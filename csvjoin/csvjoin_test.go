@@ -0,0 +1,122 @@
+package csvjoin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// join runs a join and returns its result along with the header and the rows written, sorted.
+func join(t *testing.T, left, right Source, opts Options) (*Result, []string, []string) {
+	t.Helper()
+	var out bytes.Buffer
+	result, err := Join(left, right, opts, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := make([]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		rows = append(rows, strings.Join(record, "|"))
+	}
+	slices.Sort(rows)
+	return result, records[0], rows
+}
+
+func TestJoin(t *testing.T) {
+	left := writeFile(t, "prices.csv", "code,payer,price\n1,A,10\n2,B,20\n2,C,25\n,D,30\n4,E,40\n 5 ,F,50\n")
+	// the key columns of the right file are left out, its other columns named like one of the left file get a suffix
+	right := writeFile(t, "codes.csv", "code,price,name\n2,200,two\n1,100,one\n1,101,uno\n3,300,three\n,0,blank\n5,500,five\n")
+	for _, tc := range []struct {
+		how  string
+		want []string
+	}{
+		{Inner, []string{
+			" 5 |F|50|500|five", "1|A|10|100|one", "1|A|10|101|uno", "2|B|20|200|two", "2|C|25|200|two",
+		}},
+		{Left, []string{
+			"|D|30||", " 5 |F|50|500|five", "1|A|10|100|one", "1|A|10|101|uno", "2|B|20|200|two", "2|C|25|200|two",
+			"4|E|40||",
+		}},
+		// unmatched rows of the right file fill the key columns of the left file, rows with an empty key never match
+		{Full, []string{
+			"|||0|blank", "3|||300|three", "|D|30||", " 5 |F|50|500|five", "1|A|10|100|one", "1|A|10|101|uno",
+			"2|B|20|200|two", "2|C|25|200|two", "4|E|40||",
+		}},
+	} {
+		slices.Sort(tc.want)
+		l, r := Source{Path: left, Keys: []string{"code"}}, Source{Path: right, Keys: []string{"CODE"}}
+		inMemory, header, rows := join(t, l, r, Options{How: tc.how})
+		if inMemory.Partitions != 1 {
+			t.Errorf("%s: joined in %d partitions, want the right file held in memory", tc.how, inMemory.Partitions)
+		}
+		if want := []string{"code", "payer", "price", "price_right", "name"}; !slices.Equal(header, want) {
+			t.Errorf("%s: header %v, want %v", tc.how, header, want)
+		}
+		if !slices.Equal(rows, tc.want) {
+			t.Errorf("%s: rows\n%s\nwant\n%s", tc.how, strings.Join(rows, "\n"), strings.Join(tc.want, "\n"))
+		}
+
+		spilled, spilledHeader, spilledRows := join(t, l, r, Options{How: tc.how, MemoryLimit: 1, TempDir: t.TempDir()})
+		if spilled.Partitions < 2 {
+			t.Errorf("%s: joined in %d partitions with a memory limit of 1 byte, want the files spilled", tc.how, spilled.Partitions)
+		}
+		if !slices.Equal(spilledHeader, header) || !slices.Equal(spilledRows, rows) {
+			t.Errorf("%s: spilled join wrote\n%v\n%s\nwant\n%v\n%s", tc.how, spilledHeader, strings.Join(spilledRows, "\n"),
+				header, strings.Join(rows, "\n"))
+		}
+		if spilled.Rows != inMemory.Rows || spilled.Matched != inMemory.Matched || inMemory.Rows != int64(len(rows)) {
+			t.Errorf("%s: spilled join wrote %d rows, %d matched, in memory %d rows, %d matched",
+				tc.how, spilled.Rows, spilled.Matched, inMemory.Rows, inMemory.Matched)
+		}
+	}
+}
+
+func TestJoinLarge(t *testing.T) {
+	// many keys so that every partition of the spilled join holds rows
+	var l, r strings.Builder
+	l.WriteString("id,group,value\n")
+	r.WriteString("id,group,value\n")
+	for i := 0; i < 3000; i++ {
+		fmt.Fprintf(&l, "%d,%d,%d\n", i%1100, i%7, i)
+		if i%3 != 0 {
+			fmt.Fprintf(&r, "%d,%d,label%d\n", i%1300, i%7, i)
+		}
+	}
+	left := Source{Path: writeFile(t, "left.csv", l.String()), Keys: []string{"id", "group"}}
+	right := Source{Path: writeFile(t, "right.csv", r.String()), Keys: []string{"id", "group"}}
+	for _, how := range []string{Inner, Left, Full} {
+		inMemory, header, rows := join(t, left, right, Options{How: how, Suffix: "_r"})
+		spilled, spilledHeader, spilledRows := join(t, left, right, Options{How: how, Suffix: "_r", MemoryLimit: 1, TempDir: t.TempDir()})
+		if want := []string{"id", "group", "value", "value_r"}; !slices.Equal(header, want) {
+			t.Errorf("%s: header %v, want %v", how, header, want)
+		}
+		if spilled.Partitions != maxPartitions {
+			t.Errorf("%s: joined in %d partitions, want %d", how, spilled.Partitions, maxPartitions)
+		}
+		if !slices.Equal(spilledHeader, header) || !slices.Equal(spilledRows, rows) {
+			t.Errorf("%s: spilled join wrote %d rows, in memory %d rows", how, len(spilledRows), len(rows))
+		}
+		if spilled.Rows != inMemory.Rows || spilled.Matched != inMemory.Matched {
+			t.Errorf("%s: spilled join wrote %d rows, %d matched, in memory %d rows, %d matched",
+				how, spilled.Rows, spilled.Matched, inMemory.Rows, inMemory.Matched)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package csvutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DefaultDelimiter is used when no delimiter could be sniffed from a file.
+const DefaultDelimiter = ','
+
+// sniffSize is the number of bytes inspected when sniffing the MIME type and delimiter of a file.
+const sniffSize = 64 << 10
+
+var candidateDelimiters = []rune{',', ';', '\t', '|'}
+
+type Metadata struct {
+	Size        int64
+	MimeType    string
+	SHA256      string
+	Delimiter   rune
+	Header      []string
+	RowCount    int64
+	ColumnCount int
+}
+
+//NewReader
+/*
+Returns a `csv.Reader` over `r` using the delimiter `delimiter`,
+the reader is lenient about the number of fields in each record and reuses the record slice between reads.
+*/
+func NewReader(r io.Reader, delimiter rune) *csv.Reader {
+	reader := csv.NewReader(r)
+	if delimiter != 0 {
+		reader.Comma = delimiter
+	}
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return reader
+}
+
+//SniffDelimiter
+/*
+Guesses the delimiter used by the CSV data in `sample` by picking the candidate delimiter
+that splits the leading lines into the same, largest number of fields.
+Returns DefaultDelimiter if no candidate is found.
+*/
+func SniffDelimiter(sample []byte) rune {
+	lines := bytes.SplitN(sample, []byte("\n"), 11)
+	if len(lines) > 1 {
+		// the last line may have been truncated by the sample size
+		lines = lines[:len(lines)-1]
+	}
+	best, bestFields := rune(DefaultDelimiter), 1
+	for _, d := range candidateDelimiters {
+		fields, consistent := 0, true
+		for i, line := range lines {
+			n := bytes.Count(line, []byte(string(d))) + 1
+			if i == 0 {
+				fields = n
+			} else if n != fields && len(bytes.TrimSpace(line)) > 0 {
+				consistent = false
+				break
+			}
+		}
+		if consistent && fields > bestFields {
+			best, bestFields = d, fields
+		}
+	}
+	return best
+}
+
+//Inspect
+/*
+Reads the CSV data from `r` once and returns its `Metadata`:
+the size in bytes, the sniffed MIME type, the hex encoded SHA-256 of the content, the sniffed delimiter,
+the header row and the number of data rows and columns.
+*/
+func Inspect(r io.Reader) (*Metadata, error) {
+	hash := sha256.New()
+	counter := &countingWriter{}
+	buffered := bufio.NewReaderSize(io.TeeReader(r, io.MultiWriter(hash, counter)), sniffSize)
+	sample, err := buffered.Peek(sniffSize)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	m := &Metadata{
+		MimeType:  http.DetectContentType(sample),
+		Delimiter: SniffDelimiter(sample),
+	}
+	reader := NewReader(buffered, m.Delimiter)
+	header, err := reader.Read()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	m.Header = append([]string(nil), header...)
+	m.ColumnCount = len(m.Header)
+	for {
+		if _, err := reader.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		m.RowCount++
+	}
+	// drain anything the csv reader left behind so the hash covers the whole content
+	if _, err := io.Copy(io.Discard, buffered); err != nil {
+		return nil, err
+	}
+	m.Size = counter.n
+	m.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return m, nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,276 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// truthValue is the result of a condition under three-valued logic, conditions involving NULL are unknown.
+type truthValue int8
+
+const (
+	unknown truthValue = iota
+	falsehood
+	truth
+)
+
+func truthOf(b bool) truthValue {
+	if b {
+		return truth
+	}
+	return falsehood
+}
+
+type condition interface {
+	eval(record []string) truthValue
+}
+
+type valueKind int
+
+const (
+	kindField valueKind = iota
+	kindNumber
+	kindString
+	kindDate
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case kindNumber:
+		return "a number"
+	case kindString:
+		return "a string"
+	case kindDate:
+		return "a date"
+	}
+	return "a column"
+}
+
+//operand
+/*
+A column, referenced by its index in the header, or a literal.
+*/
+type operand struct {
+	kind   valueKind
+	column int
+	number float64
+	text   string
+	date   time.Time
+}
+
+// field returns the field of the column in `record`, or false when it is NULL.
+func (o operand) field(record []string) (string, bool) {
+	if o.column >= len(record) || strings.TrimSpace(record[o.column]) == "" {
+		return "", false
+	}
+	return record[o.column], true
+}
+
+func (o operand) asNumber(record []string) (float64, bool) {
+	if o.kind != kindField {
+		return o.number, o.kind == kindNumber
+	}
+	s, ok := o.field(record)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return v, err == nil
+}
+
+func (o operand) asString(record []string) (string, bool) {
+	if o.kind != kindField {
+		return o.text, true
+	}
+	return o.field(record)
+}
+
+func (o operand) asDate(record []string) (time.Time, bool) {
+	if o.kind != kindField {
+		return o.date, o.kind == kindDate
+	}
+	s, ok := o.field(record)
+	if !ok {
+		return time.Time{}, false
+	}
+	return ParseDate(s)
+}
+
+//compare
+/*
+Compares `a` with `b` read as `kind`, returning false when either is NULL or cannot be read as `kind`.
+Two columns are compared as numbers when both fields are numbers and as strings otherwise.
+*/
+func compare(kind valueKind, a, b operand, record []string) (int, bool) {
+	if kind == kindField {
+		kind = kindString
+		if _, ok := a.asNumber(record); ok {
+			if _, ok := b.asNumber(record); ok {
+				kind = kindNumber
+			}
+		}
+	}
+	switch kind {
+	case kindNumber:
+		x, ok := a.asNumber(record)
+		y, ok2 := b.asNumber(record)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case kindDate:
+		x, ok := a.asDate(record)
+		y, ok2 := b.asDate(record)
+		if !ok || !ok2 {
+			return 0, false
+		}
+		return x.Compare(y), true
+	}
+	x, ok := a.asString(record)
+	y, ok2 := b.asString(record)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+type and struct {
+	left, right condition
+}
+
+func (c *and) eval(record []string) truthValue {
+	l := c.left.eval(record)
+	if l == falsehood {
+		return falsehood
+	}
+	r := c.right.eval(record)
+	if r == falsehood {
+		return falsehood
+	}
+	if l == truth && r == truth {
+		return truth
+	}
+	return unknown
+}
+
+type or struct {
+	left, right condition
+}
+
+func (c *or) eval(record []string) truthValue {
+	l := c.left.eval(record)
+	if l == truth {
+		return truth
+	}
+	r := c.right.eval(record)
+	if r == truth {
+		return truth
+	}
+	if l == falsehood && r == falsehood {
+		return falsehood
+	}
+	return unknown
+}
+
+type not struct {
+	condition condition
+}
+
+func (c *not) eval(record []string) truthValue {
+	return negate(c.condition.eval(record), true)
+}
+
+// negate inverts `v` when `b` is set, unknown stays unknown.
+func negate(v truthValue, b bool) truthValue {
+	if !b || v == unknown {
+		return v
+	}
+	return truthOf(v == falsehood)
+}
+
+type comparison struct {
+	op          string
+	left, right operand
+}
+
+func (c *comparison) eval(record []string) truthValue {
+	kind := c.left.kind
+	if kind == kindField {
+		kind = c.right.kind
+	}
+	cmp, ok := compare(kind, c.left, c.right, record)
+	if !ok {
+		return unknown
+	}
+	switch c.op {
+	case "=":
+		return truthOf(cmp == 0)
+	case "!=", "<>":
+		return truthOf(cmp != 0)
+	case "<":
+		return truthOf(cmp < 0)
+	case "<=":
+		return truthOf(cmp <= 0)
+	case ">":
+		return truthOf(cmp > 0)
+	case ">=":
+		return truthOf(cmp >= 0)
+	}
+	return unknown
+}
+
+type in struct {
+	operand operand
+	values  []operand
+	negate  bool
+}
+
+func (c *in) eval(record []string) truthValue {
+	kind := c.values[0].kind
+	for _, v := range c.values {
+		cmp, ok := compare(kind, c.operand, v, record)
+		if !ok {
+			return unknown
+		}
+		if cmp == 0 {
+			return negate(truth, c.negate)
+		}
+	}
+	return negate(falsehood, c.negate)
+}
+
+type like struct {
+	operand operand
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+func (c *like) eval(record []string) truthValue {
+	s, ok := c.operand.asString(record)
+	if !ok {
+		return unknown
+	}
+	return negate(truthOf(c.pattern.MatchString(s)), c.negate)
+}
+
+type isNull struct {
+	operand operand
+	negate  bool
+}
+
+func (c *isNull) eval(record []string) truthValue {
+	null := false
+	if c.operand.kind == kindField {
+		_, ok := c.operand.field(record)
+		null = !ok
+	}
+	return negate(truthOf(null), c.negate)
+}
@@ -0,0 +1,99 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Filter
+/*
+A row filter compiled from an expression against the header of a CSV file, see Compile.
+
+The expression language supports:
+  - comparisons with =, !=, <>, <, <=, > and >= between columns and literals
+  - AND, OR, NOT and parentheses
+  - <operand> [NOT] IN (<literal>, ...)
+  - <operand> [NOT] LIKE '<pattern>', where % matches any run of characters, _ a single character
+    and a backslash escapes the character after it
+  - <operand> IS [NOT] NULL, a field is NULL when it is empty or missing from the record
+  - numeric literals such as 12, -0.5 or 1e3, string literals in single quotes with '' escaping a quote,
+    and date literals such as DATE '2024-01-31' or DATE '2024-01-31 12:00:00'
+
+Columns are referenced by name, compared case-insensitively, and must be enclosed in double quotes
+when the name is not a plain identifier e.g. "Gross Charge".
+A column compared with a literal is read as the type of the literal, comparisons are case-sensitive.
+A comparison involving a NULL or a field that cannot be read as the type it is compared as is unknown,
+as in SQL, and rows are only matched when the expression is true.
+*/
+type Filter struct {
+	source string
+	root   condition
+}
+
+//Error
+/*
+Returned when an expression cannot be parsed or does not type-check against the header,
+`Position` is the byte offset in the expression the error was found at.
+*/
+type Error struct {
+	Message  string
+	Position int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid where expression at position %d: %s", e.Position, e.Message)
+}
+
+//Compile
+/*
+Parses the expression `source` and checks it against the columns of `header`.
+*/
+func Compile(source string, header []string) (*Filter, error) {
+	p := &parser{source: source, header: header}
+	if err := p.scan(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorAt(t, "unexpected '%s'", t.text)
+	}
+	return &Filter{source: source, root: root}, nil
+}
+
+//Match
+/*
+Reports whether the expression is true for `record`, whose fields are in the order of the header it was compiled with.
+*/
+func (f *Filter) Match(record []string) bool {
+	return f.root.eval(record) == truth
+}
+
+func (f *Filter) String() string {
+	return f.source
+}
+
+// DateLayouts are the layouts accepted for date literals and for fields compared with a date.
+var DateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+//ParseDate
+/*
+Parses `s` with the first of DateLayouts that fits it, dates without a time zone are in UTC.
+*/
+func ParseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range DateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+)
+
+var header = []string{"payer", "price", "Gross Charge", "date", `say "hi"`}
+
+func TestMatch(t *testing.T) {
+	cigna := []string{"Cigna", "150", "1200.50", "2024-07-21", "x"}
+	uhc := []string{"UHC", "80", "90", "2024-01-31 12:00:00", ""}
+	nullPrice := []string{"Aetna", "", "300", "2024-03-01", "y"}
+	short := []string{"Aetna"}
+	notNumber := []string{"Aetna", "n/a", "300", "soon", "z"}
+
+	for _, tc := range []struct {
+		expr   string
+		record []string
+		want   bool
+	}{
+		// precedence: NOT binds tighter than AND, which binds tighter than OR
+		{"payer = 'Cigna' OR payer = 'UHC' AND price > 100", cigna, true},
+		{"payer = 'Cigna' OR payer = 'UHC' AND price > 100", uhc, false},
+		{"(payer = 'Cigna' OR payer = 'UHC') AND price > 100", uhc, false},
+		{"(payer = 'Cigna' OR payer = 'UHC') AND price > 100", cigna, true},
+		{"NOT payer = 'Cigna' AND price < 100", uhc, true},
+		{"NOT payer = 'Cigna' AND price < 100", cigna, false},
+		{"NOT (payer = 'Cigna' AND price < 100)", cigna, true},
+		{"NOT NOT payer = 'UHC'", uhc, true},
+		{"payer = 'UHC' OR payer = 'Cigna' AND NOT price > 100", cigna, false},
+
+		// comparisons
+		{"price = 150", cigna, true},
+		{"price <> 150", cigna, false},
+		{"price != 80", uhc, false},
+		{"price >= 150 AND price <= 150", cigna, true},
+		{"price > -5.5e1", uhc, true},
+		{"payer < 'D'", cigna, true},
+		{"payer = 'cigna'", cigna, false},
+		{"price < \"Gross Charge\"", cigna, true},
+		{"payer = price", cigna, false},
+		{"date >= DATE '2024-07-01'", cigna, true},
+		{"date < DATE '2024-01-31 12:00:01'", uhc, true},
+
+		// NULL is unknown, so is a field that is not of the type it is compared as
+		{"price > 100", nullPrice, false},
+		{"NOT price > 100", nullPrice, false},
+		{"price > 100 OR payer = 'Aetna'", nullPrice, true},
+		{"price > 100 AND payer = 'Aetna'", nullPrice, false},
+		{"NOT (price > 100 AND payer = 'UHC')", nullPrice, true},
+		{"NOT (price > 100 OR payer = 'UHC')", nullPrice, false},
+		{"price IS NULL", nullPrice, true},
+		{"price IS NOT NULL", nullPrice, false},
+		{"price IS NULL", short, true},
+		{"\"Gross Charge\" IS NULL", short, true},
+		{"price IN (1, 2)", nullPrice, false},
+		{"price NOT IN (1, 2)", nullPrice, false},
+		{"payer LIKE '%'", short, true},
+		{"price NOT LIKE '1%'", nullPrice, false},
+		{"price > 5", notNumber, false},
+		{"NOT price > 5", notNumber, false},
+		{"price = 'n/a'", notNumber, true},
+		{"date > DATE '2024-01-01'", notNumber, false},
+		{"NOT date > DATE '2024-01-01'", notNumber, false},
+
+		// IN and LIKE
+		{"payer IN ('Cigna', 'UHC')", uhc, true},
+		{"payer NOT IN ('Cigna', 'UHC')", uhc, false},
+		{"price IN (80, 90)", uhc, true},
+		{"payer LIKE 'Ci%'", cigna, true},
+		{"payer LIKE '_HC'", uhc, true},
+		{"payer LIKE 'U_'", uhc, false},
+		{"payer NOT LIKE 'C%'", uhc, true},
+		{"\"Gross Charge\" LIKE '1200\\.%'", cigna, true},
+		{"\"Gross Charge\" LIKE '1200\\%'", cigna, false},
+
+		// quoted identifiers and keywords are case-insensitive
+		{"\"Gross Charge\" > 1000", cigna, true},
+		{"\"gross charge\" > 1000", cigna, true},
+		{"PAYER = 'UHC' and price in (80) Or price is null", uhc, true},
+		{"\"say \"\"hi\"\"\" = 'x'", cigna, true},
+		{"\"say \"\"hi\"\"\" IS NULL", uhc, true},
+		{"payer = 'O''Brien'", []string{"O'Brien", "1"}, true},
+	} {
+		f, err := Compile(tc.expr, header)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := f.Match(tc.record); got != tc.want {
+			t.Errorf("%s on %q: got %v, want %v", tc.expr, tc.record, got, tc.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr     string
+		position int
+		message  string
+	}{
+		{"nope = 1", 0, "unknown column 'nope'"},
+		{"price = 1 AND \"Net Charge\" > 2", 14, "unknown column 'Net Charge'"},
+		{"price >", 7, "expected a column or a value but found 'end of expression'"},
+		{"price = 1 AND", 13, "expected a column or a value but found 'end of expression'"},
+		{"price = 1)", 9, "unexpected ')'"},
+		{"(price = 1", 10, "expected ')' but found 'end of expression'"},
+		{"payer = 'Cigna", 8, "unterminated quote"},
+		{"\"Gross Charge = 1", 0, "unterminated quote"},
+		{"price ! 1", 6, "unexpected '!'"},
+		{"price = 1 # 2", 10, "unexpected '#'"},
+		{"price = 1 AND price > -", 22, "invalid number '-'"},
+		{"price = NULL", 8, "NULL can only be tested with IS NULL"},
+		{"payer IS 'x'", 9, "expected NULL but found 'x'"},
+		{"payer NOT BETWEEN 1", 10, "expected IN or LIKE after NOT but found 'BETWEEN'"},
+		{"payer 'x'", 6, "expected a comparison, IN, LIKE or IS NULL after 'payer' but found 'x'"},
+		{"1 = 'a'", 2, "cannot compare a number with a string"},
+		{"price IN (1, 'a')", 13, "cannot mix a number and a string in IN"},
+		{"price IN (1, payer)", 13, "the values of IN must be literals"},
+		{"price IN 1", 9, "expected '(' after IN but found '1'"},
+		{"price IN (1 2)", 12, "expected ',' or ')' but found '2'"},
+		{"payer LIKE payer", 11, "the pattern of LIKE must be a string"},
+		{"1 LIKE '1'", 7, "LIKE cannot be applied to a number"},
+		{"date > DATE '2024-13-01'", 12, "invalid date '2024-13-01', expected YYYY-MM-DD"},
+		{"payer = 'a' AND OR", 16, "expected a column or a value but found 'OR'"},
+	} {
+		_, err := Compile(tc.expr, header)
+		var filterError *Error
+		if !errors.As(err, &filterError) {
+			t.Errorf("%s: got %v, want an *Error", tc.expr, err)
+			continue
+		}
+		if filterError.Position != tc.position || filterError.Message != tc.message {
+			t.Errorf("%s: got %q at %d, want %q at %d", tc.expr, filterError.Message, filterError.Position, tc.message, tc.position)
+		}
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	_, err := Compile("price >", header)
+	if want := "invalid where expression at position 7: expected a column or a value but found 'end of expression'"; err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	for _, s := range []string{"2024-01-31", "2024-01-31 12:00:00", "2024-01-31T12:00:00", "2024-01-31T12:00:00+02:00", " 2024-01-31 "} {
+		if _, ok := ParseDate(s); !ok {
+			t.Errorf("%q is not read as a date", s)
+		}
+	}
+	for _, s := range []string{"", "31/01/2024", "2024-02-30", "soon"} {
+		if d, ok := ParseDate(s); ok {
+			t.Errorf("%q is read as the date %s", s, d)
+		}
+	}
+}
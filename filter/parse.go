@@ -0,0 +1,379 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+	tokenLeftParen
+	tokenRightParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// keyword reports whether the token is the unquoted keyword `word`, keywords are case-insensitive.
+func (t token) keyword(word string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, word)
+}
+
+var reserved = []string{"AND", "OR", "NOT", "IN", "LIKE", "IS", "NULL"}
+
+//parser
+/*
+A recursive descent parser over the tokens of an expression, column references are resolved
+against `header` and operands are type-checked as the expression is parsed.
+*/
+type parser struct {
+	source string
+	header []string
+	tokens []token
+	next   int
+}
+
+func (p *parser) errorAt(t token, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Position: t.pos}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+//scan
+/*
+Splits the source into tokens, the last token is always tokenEOF.
+*/
+func (p *parser) scan() error {
+	s := p.source
+	for i := 0; i < len(s); {
+		c, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '(':
+			p.tokens = append(p.tokens, token{kind: tokenLeftParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{kind: tokenRightParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			p.tokens = append(p.tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			op := string(c)
+			if i+1 < len(s) {
+				if two := s[i : i+2]; two == "!=" || two == "<>" || two == "<=" || two == ">=" {
+					op = two
+				}
+			}
+			if op == "!" {
+				return &Error{Message: "unexpected '!'", Position: i}
+			}
+			p.tokens = append(p.tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		case c == '\'' || c == '"':
+			text, end, ok := quoted(s, i)
+			if !ok {
+				return &Error{Message: "unterminated quote", Position: i}
+			}
+			kind := tokenString
+			if c == '"' {
+				kind = tokenQuotedIdent
+			}
+			p.tokens = append(p.tokens, token{kind: kind, text: text, pos: i})
+			i = end
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(s) && (strings.IndexByte("0123456789.eE", s[end]) >= 0 ||
+				(strings.IndexByte("+-", s[end]) >= 0 && strings.IndexByte("eE", s[end-1]) >= 0)) {
+				end++
+			}
+			if _, err := strconv.ParseFloat(s[i:end], 64); err != nil {
+				return &Error{Message: fmt.Sprintf("invalid number '%s'", s[i:end]), Position: i}
+			}
+			p.tokens = append(p.tokens, token{kind: tokenNumber, text: s[i:end], pos: i})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + size
+			for end < len(s) {
+				r, n := utf8.DecodeRuneInString(s[end:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				end += n
+			}
+			p.tokens = append(p.tokens, token{kind: tokenIdent, text: s[i:end], pos: i})
+			i = end
+		default:
+			return &Error{Message: fmt.Sprintf("unexpected '%c'", c), Position: i}
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokenEOF, text: "end of expression", pos: len(s)})
+	return nil
+}
+
+//quoted
+/*
+Reads the text quoted by the quote at `start`, a doubled quote stands for the quote itself.
+Returns the text and the offset after the closing quote.
+*/
+func quoted(s string, start int) (string, int, bool) {
+	quote := s[start]
+	var b strings.Builder
+	for i := start + 1; i < len(s); i++ {
+		if s[i] != quote {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, true
+	}
+	return "", 0, false
+}
+
+// parseCondition parses: and-condition { OR and-condition }
+func (p *parser) parseCondition() (condition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("OR") {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &or{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: not-condition { AND not-condition }
+func (p *parser) parseAnd() (condition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().keyword("AND") {
+		p.advance()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &and{left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses: NOT not-condition | ( condition ) | predicate
+func (p *parser) parseNot() (condition, error) {
+	if p.peek().keyword("NOT") {
+		p.advance()
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{condition: c}, nil
+	}
+	if p.peek().kind == tokenLeftParen {
+		p.advance()
+		c, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.advance(); t.kind != tokenRightParen {
+			return nil, p.errorAt(t, "expected ')' but found '%s'", t.text)
+		}
+		return c, nil
+	}
+	return p.parsePredicate()
+}
+
+// parsePredicate parses a comparison, IN, LIKE or IS NULL test of an operand.
+func (p *parser) parsePredicate() (condition, error) {
+	start := p.peek()
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.advance()
+	switch {
+	case t.kind == tokenOperator:
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.kind != kindField && right.kind != kindField && left.kind != right.kind {
+			return nil, p.errorAt(t, "cannot compare %s with %s", left.kind, right.kind)
+		}
+		return &comparison{op: t.text, left: left, right: right}, nil
+	case t.keyword("IS"):
+		negate := false
+		if p.peek().keyword("NOT") {
+			p.advance()
+			negate = true
+		}
+		if n := p.advance(); !n.keyword("NULL") {
+			return nil, p.errorAt(n, "expected NULL but found '%s'", n.text)
+		}
+		return &isNull{operand: left, negate: negate}, nil
+	case t.keyword("NOT"):
+		n := p.advance()
+		switch {
+		case n.keyword("IN"):
+			return p.parseIn(left, true)
+		case n.keyword("LIKE"):
+			return p.parseLike(left, true)
+		}
+		return nil, p.errorAt(n, "expected IN or LIKE after NOT but found '%s'", n.text)
+	case t.keyword("IN"):
+		return p.parseIn(left, false)
+	case t.keyword("LIKE"):
+		return p.parseLike(left, false)
+	}
+	return nil, p.errorAt(t, "expected a comparison, IN, LIKE or IS NULL after '%s' but found '%s'",
+		strings.TrimSpace(p.source[start.pos:t.pos]), t.text)
+}
+
+// parseIn parses the list of an IN test: ( literal { , literal } ), the literals must all be of the same type.
+func (p *parser) parseIn(left operand, negate bool) (condition, error) {
+	if t := p.advance(); t.kind != tokenLeftParen {
+		return nil, p.errorAt(t, "expected '(' after IN but found '%s'", t.text)
+	}
+	c := &in{operand: left, negate: negate}
+	for {
+		t := p.peek()
+		v, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if v.kind == kindField {
+			return nil, p.errorAt(t, "the values of IN must be literals")
+		}
+		if want := left.kind; len(c.values) > 0 {
+			want = c.values[0].kind
+			if v.kind != want {
+				return nil, p.errorAt(t, "cannot mix %s and %s in IN", want, v.kind)
+			}
+		} else if want != kindField && v.kind != want {
+			return nil, p.errorAt(t, "cannot compare %s with %s", want, v.kind)
+		}
+		c.values = append(c.values, v)
+		switch t := p.advance(); t.kind {
+		case tokenComma:
+			continue
+		case tokenRightParen:
+			return c, nil
+		default:
+			return nil, p.errorAt(t, "expected ',' or ')' but found '%s'", t.text)
+		}
+	}
+}
+
+// parseLike parses the pattern of a LIKE test, which must be a string literal.
+func (p *parser) parseLike(left operand, negate bool) (condition, error) {
+	t := p.advance()
+	if t.kind != tokenString {
+		return nil, p.errorAt(t, "the pattern of LIKE must be a string")
+	}
+	if left.kind != kindField && left.kind != kindString {
+		return nil, p.errorAt(t, "LIKE cannot be applied to %s", left.kind)
+	}
+	return &like{operand: left, pattern: likePattern(t.text), negate: negate}, nil
+}
+
+//likePattern
+/*
+Translates a LIKE pattern to an anchored regular expression.
+*/
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(")$")
+	return regexp.MustCompile(b.String())
+}
+
+// parseOperand parses a column reference or a literal.
+func (p *parser) parseOperand() (operand, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenNumber:
+		v, _ := strconv.ParseFloat(t.text, 64)
+		return operand{kind: kindNumber, number: v, text: t.text}, nil
+	case tokenString:
+		return operand{kind: kindString, text: t.text}, nil
+	case tokenQuotedIdent:
+		return p.column(t)
+	case tokenIdent:
+		if t.keyword("DATE") && p.peek().kind == tokenString {
+			s := p.advance()
+			d, ok := ParseDate(s.text)
+			if !ok {
+				return operand{}, p.errorAt(s, "invalid date '%s', expected YYYY-MM-DD", s.text)
+			}
+			return operand{kind: kindDate, date: d, text: s.text}, nil
+		}
+		for _, word := range reserved {
+			if t.keyword(word) {
+				if word == "NULL" {
+					return operand{}, p.errorAt(t, "NULL can only be tested with IS NULL")
+				}
+				return operand{}, p.errorAt(t, "expected a column or a value but found '%s'", t.text)
+			}
+		}
+		return p.column(t)
+	}
+	return operand{}, p.errorAt(t, "expected a column or a value but found '%s'", t.text)
+}
+
+// column resolves the column named by `t` against the header.
+func (p *parser) column(t token) (operand, error) {
+	for i, name := range p.header {
+		if strings.EqualFold(name, t.text) {
+			return operand{kind: kindField, column: i, text: name}, nil
+		}
+	}
+	return operand{}, p.errorAt(t, "unknown column '%s'", t.text)
+}
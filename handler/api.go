@@ -1,20 +1,29 @@
 package handler
 
-import "api-3390/service"
+import (
+	"api-3390/resultcache"
+	"api-3390/service"
+)
 
 type API struct {
 	Services *Services
+	// Cache holds the results of operations on files, nil when results are not cached
+	Cache *resultcache.Cache
+	// Jobs runs the operations queued to run in the background
+	Jobs *JobRunner
 }
 type Services struct {
 	AuthService *service.AuthService
 	FileService *service.FileService
 	UserService *service.UserService
+	JobService  *service.JobService
 }
 
-func NewServices(as *service.AuthService, fs *service.FileService, us *service.UserService) *Services {
+func NewServices(as *service.AuthService, fs *service.FileService, us *service.UserService, js *service.JobService) *Services {
 	return &Services{
 		AuthService: as,
 		FileService: fs,
 		UserService: us,
+		JobService:  js,
 	}
 }
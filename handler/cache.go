@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"api-3390/container"
+	"api-3390/resultcache"
+	"bytes"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// cacheHeader reports whether the result of an operation was read from the cache, 'HIT', or calculated, 'MISS'.
+const cacheHeader = "X-Cache"
+
+//resultRecorder
+/*
+Passes a response through to the client while keeping a copy of its body, up to resultcache.MaxEntrySize.
+*/
+type resultRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *resultRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *resultRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > resultcache.MaxEntrySize {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+//cached
+/*
+Returns `handler` answering from the result cache of the API when the same operation was already run
+with the same parameters on the same content, and caching the responses it writes successfully otherwise.
+The content of the files related to a query, see QueryParams.Related, is part of the key along with that of the file.
+Every response reports whether it was a hit in the X-Cache header.
+
+Requests sent with 'Cache-Control: no-cache' always recalculate the result and replace the cached one.
+Files uploaded before checksums were recorded are never cached as their content cannot be told apart.
+*/
+func (a *API) cached(handler QueryHandler) QueryHandler {
+	return func(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+		if a.Cache == nil || params.File.SHA256 == "" {
+			handler(w, r, params, filePath)
+			return
+		}
+		query := r.URL.Query()
+		for _, f := range params.Related {
+			if f.SHA256 == "" {
+				handler(w, r, params, filePath)
+				return
+			}
+			query.Add("related_sha256", f.SHA256)
+		}
+		key := resultcache.Key(params.File.SHA256, params.Operation, query)
+		if !strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") {
+			if e, ok := a.Cache.Get(key); ok {
+				for name, values := range e.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set(cacheHeader, "HIT")
+				w.WriteHeader(http.StatusOK)
+				w.Write(e.Body)
+				return
+			}
+		}
+		before := w.Header().Clone()
+		w.Header().Set(cacheHeader, "MISS")
+		rec := &resultRecorder{ResponseWriter: w}
+		handler(rec, r, params, filePath)
+		if rec.status != http.StatusOK || rec.overflow {
+			return
+		}
+		// only the headers of the result are kept, not those set by middleware for this request
+		header := http.Header{}
+		for name, values := range w.Header() {
+			if name != cacheHeader && !slices.Equal(before[name], values) {
+				header[name] = values
+			}
+		}
+		a.Cache.Add(key, &resultcache.Entry{Header: header, Body: rec.body.Bytes()})
+	}
+}
+
+//invalidateResults
+/*
+Drops the cached results of the content of `files`, called whenever a file is replaced, deleted, renamed or moved.
+*/
+func (a *API) invalidateResults(files ...*container.File) {
+	if a.Cache == nil {
+		return
+	}
+	for _, f := range files {
+		if f != nil {
+			a.Cache.Invalidate(f.SHA256)
+		}
+	}
+}
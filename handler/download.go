@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"api-3390/container"
+	"api-3390/csvutil"
+	"api-3390/handler/stats"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const archiveManifestName = "manifest.json"
+
+type ArchiveManifest struct {
+	UserID  uint32            `json:"user_id"`
+	Files   []*container.File `json:"files"`
+	Missing []*container.File `json:"missing,omitempty"`
+}
+
+//HandleGetUserFilesArchive
+/*
+Streams a '.zip' archive of the files a user `container.User` has, based off the user_id `uint32` provided in the URI/L.
+
+'<path>?ids=<file-ids>
+
+ids must be delimited by a comma and must belong to the user, when left out every file of the user is included
+and files whose data can no longer be found are listed as missing in the manifest instead of failing the request.
+The archive is built while it is sent and ends with '<archiveManifestName>', a JSON `ArchiveManifest`
+holding the metadata of each file along with the size and SHA-256 of the data that was written to the archive.
+*/
+func (a *API) HandleGetUserFilesArchive(w http.ResponseWriter, r *http.Request) {
+	userid, err := getStringId("user_id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files, err := a.Services.FileService.GetUserFiles(userid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idsParam := r.URL.Query().Get("ids")
+	if idsParam != "" {
+		byId := make(map[uint32]*container.File, len(files))
+		for _, f := range files {
+			byId[f.ID] = f
+		}
+		files = files[:0:0]
+		seen := make(map[uint32]bool)
+		for _, s := range strings.Split(idsParam, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid file id: %s", s), http.StatusBadRequest)
+				return
+			}
+			f, ok := byId[uint32(id)]
+			if !ok {
+				http.Error(w, fmt.Sprintf("file not found: %d", id), http.StatusNotFound)
+				return
+			}
+			if !seen[f.ID] {
+				seen[f.ID] = true
+				files = append(files, f)
+			}
+		}
+	}
+	manifest := ArchiveManifest{UserID: userid, Files: make([]*container.File, 0, len(files))}
+	available := files[:0:0]
+	for _, f := range files {
+		if _, err := os.Stat(userFilePath(f.UserID, f.Name)); err != nil {
+			if idsParam != "" {
+				http.Error(w, fmt.Sprintf("file not found: %s", f.Name), http.StatusNotFound)
+				return
+			}
+			manifest.Missing = append(manifest.Missing, f)
+			continue
+		}
+		available = append(available, f)
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%d-files.zip\"", userid))
+	w.WriteHeader(http.StatusOK)
+	archive := zip.NewWriter(w)
+	for _, f := range available {
+		entry := *f
+		if err := writeArchiveEntry(archive, &entry); err != nil {
+			// the status has already been sent, abandon the archive so the client sees it is incomplete
+			return
+		}
+		manifest.Files = append(manifest.Files, &entry)
+	}
+	mw, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     archiveManifestName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(mw)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return
+	}
+	archive.Close()
+}
+
+//writeArchiveEntry
+/*
+Copies the stored data of `f` into a new entry of `archive`,
+the size and SHA-256 of `f` are set to those of the data that was copied.
+*/
+func writeArchiveEntry(archive *zip.Writer, f *container.File) error {
+	in, err := os.Open(userFilePath(f.UserID, f.Name))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     f.Name,
+		Method:   zip.Deflate,
+		Modified: f.UploadTime,
+	})
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		return err
+	}
+	f.Size = n
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+//serveFile
+/*
+Sends the stored data of the file in `params` as an attachment named after the file.
+
+Byte ranges are supported through the 'Range' header, including multiple ranges which are sent as a multipart response.
+The 'ETag' is the SHA-256 of the content and 'Last-Modified' is the upload time of the file,
+so 'If-None-Match', 'If-Modified-Since' and 'If-Range' are honoured. Files uploaded before checksums were recorded
+have their checksum computed and stored the first time they are sent.
+
+When a filter is requested only the header and the matching rows are sent, see serveFilteredFile.
+*/
+func (a *API) serveFile(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if params.Where != "" {
+		serveFilteredFile(w, params, filePath)
+		return
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "unable to open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	file := params.File
+	if file.SHA256 == "" {
+		hash := sha256.New()
+		n, err := io.Copy(hash, f)
+		if err != nil {
+			http.Error(w, "unable to open file", http.StatusInternalServerError)
+			return
+		}
+		file.Size = n
+		file.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		if err := a.Services.FileService.UpdateFileChecksum(file); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, "unable to open file", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", file.SHA256))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	http.ServeContent(w, r, file.Name, file.UploadTime, f)
+}
+
+//serveFilteredFile
+/*
+Streams the header and the rows of the file matching the filter of `params` as CSV, in the delimiter of the file.
+The content is generated as it is sent so ranges and conditional requests are not supported.
+*/
+func serveFilteredFile(w http.ResponseWriter, params QueryParams, filePath string) {
+	opts := statsOptions(params)
+	rr, err := stats.OpenRecords(filePath, opts)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	defer rr.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": params.File.Name}))
+	writer := csv.NewWriter(w)
+	writer.Comma = csvutil.DefaultDelimiter
+	if opts.Delimiter != 0 {
+		writer.Comma = opts.Delimiter
+	}
+	if err := writer.Write(rr.Header()); err != nil {
+		return
+	}
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				// the status has already been sent, the response is cut short instead
+				log.Printf("unable to send '%s': %v", params.File.Name, err)
+			}
+			break
+		}
+		if err := writer.Write(record); err != nil {
+			return
+		}
+	}
+	writer.Flush()
+}
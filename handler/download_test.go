@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// readArchive returns the content of every entry of the archive in `body` by name.
+func readArchive(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(b)
+	}
+	return entries
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestGetUserFilesArchive(t *testing.T) {
+	files := map[string]string{"a.csv": "a\n1\n", "b.csv": "b\n2\n", "c.csv": "c\n3\n"}
+	tests := []struct {
+		name    string
+		query   string
+		status  int
+		files   []string
+		missing []string
+	}{
+		{name: "all", status: http.StatusOK, files: []string{"a.csv", "b.csv"}, missing: []string{"c.csv"}},
+		{name: "selected", query: "?ids=2,1,2", status: http.StatusOK, files: []string{"a.csv", "b.csv"}},
+		{name: "missing data", query: "?ids=3", status: http.StatusNotFound},
+		{name: "other user", query: "?ids=4", status: http.StatusNotFound},
+		{name: "invalid id", query: "?ids=1,x", status: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api, db := newTestAPI(t)
+			for _, name := range []string{"a.csv", "b.csv", "c.csv"} {
+				addFile(t, db, 1, name, files[name])
+			}
+			addFile(t, db, 2, "d.csv", "d\n4\n")
+			if err := os.Remove(userFilePath(1, "c.csv")); err != nil {
+				t.Fatal(err)
+			}
+
+			w := serve(api.HandleGetUserFilesArchive, http.MethodGet, "/users/1/archive"+tc.query, "", map[string]string{"user_id": "1"})
+			if w.Code != tc.status {
+				t.Fatalf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/zip" {
+				t.Errorf("content type %q", got)
+			}
+			entries := readArchive(t, w.Body.Bytes())
+			if len(entries) != len(tc.files)+1 {
+				t.Errorf("%d entries, want %d", len(entries), len(tc.files)+1)
+			}
+			for _, name := range tc.files {
+				if entries[name] != files[name] {
+					t.Errorf("entry %s holds %q, want %q", name, entries[name], files[name])
+				}
+			}
+
+			var manifest ArchiveManifest
+			if err := json.Unmarshal([]byte(entries[archiveManifestName]), &manifest); err != nil {
+				t.Fatalf("manifest %q: %v", entries[archiveManifestName], err)
+			}
+			if manifest.UserID != 1 {
+				t.Errorf("manifest of user %d", manifest.UserID)
+			}
+			var listed, missing []string
+			for _, f := range manifest.Files {
+				listed = append(listed, f.Name)
+				if f.Size != int64(len(files[f.Name])) || f.SHA256 != sha256Hex(files[f.Name]) {
+					t.Errorf("%s listed with size %d and SHA-256 %s", f.Name, f.Size, f.SHA256)
+				}
+			}
+			for _, f := range manifest.Missing {
+				missing = append(missing, f.Name)
+			}
+			sort.Strings(listed)
+			if strings.Join(listed, ",") != strings.Join(tc.files, ",") || strings.Join(missing, ",") != strings.Join(tc.missing, ",") {
+				t.Errorf("manifest lists %v and %v as missing, want %v and %v", listed, missing, tc.files, tc.missing)
+			}
+		})
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	const content = "a,b\n1,2\n3,4\n"
+	etag := `"` + sha256Hex(content) + `"`
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+		// the expected 'Content-Range' or, with multiple ranges, the prefix of the 'Content-Type'
+		contentRange string
+		contentType  string
+	}{
+		{name: "whole", status: http.StatusOK, body: content},
+		{name: "range", headers: map[string]string{"Range": "bytes=4-6"}, status: http.StatusPartialContent, body: "1,2", contentRange: "bytes 4-6/12"},
+		{name: "suffix range", headers: map[string]string{"Range": "bytes=-4"}, status: http.StatusPartialContent, body: "3,4\n", contentRange: "bytes 8-11/12"},
+		{name: "ranges", headers: map[string]string{"Range": "bytes=0-2,8-10"}, status: http.StatusPartialContent, contentType: "multipart/byteranges"},
+		{name: "unsatisfiable range", headers: map[string]string{"Range": "bytes=20-30"}, status: http.StatusRequestedRangeNotSatisfiable},
+		{name: "if-none-match", headers: map[string]string{"If-None-Match": etag}, status: http.StatusNotModified},
+		{name: "if-none-match any", headers: map[string]string{"If-None-Match": "*"}, status: http.StatusNotModified},
+		{name: "if-none-match changed", headers: map[string]string{"If-None-Match": `"other"`}, status: http.StatusOK, body: content},
+		{name: "if-range", headers: map[string]string{"Range": "bytes=4-6", "If-Range": etag}, status: http.StatusPartialContent, body: "1,2", contentRange: "bytes 4-6/12"},
+		{name: "if-range changed", headers: map[string]string{"Range": "bytes=4-6", "If-Range": `"other"`}, status: http.StatusOK, body: content},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api, db := newTestAPI(t)
+			id := addFile(t, db, 1, "a.csv", content)
+			r := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			api.HandleGetFileById(w, r.WithContext(context.WithValue(r.Context(), "file_id", "1")))
+			if w.Code != tc.status {
+				t.Fatalf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			}
+			if tc.status == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag %s, want %s", got, etag)
+			}
+			if tc.status == http.StatusNotModified {
+				if w.Body.Len() != 0 {
+					t.Errorf("body %q sent with 304", w.Body.String())
+				}
+				return
+			}
+			if got := w.Header().Get("Content-Disposition"); got != "attachment; filename=a.csv" {
+				t.Errorf("Content-Disposition %q", got)
+			}
+			if tc.contentType != "" {
+				if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tc.contentType) {
+					t.Errorf("Content-Type %q, want %s", got, tc.contentType)
+				}
+				return
+			}
+			if w.Body.String() != tc.body {
+				t.Errorf("body %q, want %q", w.Body.String(), tc.body)
+			}
+			if got := w.Header().Get("Content-Range"); got != tc.contentRange {
+				t.Errorf("Content-Range %q, want %q", got, tc.contentRange)
+			}
+			// the checksum of a file uploaded without one is recorded the first time it is sent
+			f, err := api.Services.FileService.GetFileById(id)
+			if err != nil || f.SHA256 != sha256Hex(content) || f.Size != int64(len(content)) {
+				t.Errorf("entry %+v recorded, %v", f, err)
+			}
+		})
+	}
+}
@@ -3,13 +3,13 @@ package handler
 import (
 	"api-3390/container"
 	"api-3390/container/predicate"
+	"api-3390/filter"
 	"api-3390/handler/stats"
-	"bytes"
+	"api-3390/service"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/bcrypt"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -88,9 +88,16 @@ func (a *API) HandleDeleteUserById(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	files, err := a.Services.FileService.GetUserFiles(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if err := a.Services.UserService.DeleteUserById(id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	a.invalidateResults(files...)
 }
 
 //HandleGetUserById
@@ -168,130 +175,13 @@ func (a *API) HandleGetAllFiles(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-const userIdFormKey = "userid"
-const fileFormKey = "file"
-const maxMemory = 10 << 20
-
-//HandleCreateFile
-/*
-This function creates a file entry in the database assigned to the 'userid' passed in the form value.
-
-The function uses a multipart form to upload a file and requires:
-
-a form value for the '<userIdFormKey>' must be provided as a string. e.g. <userIdFormKey>="2"
-a form value for the '<fileFormKey>' must be provided as content-disposition,
-a sample powershell script is provided under resources/file-script.txt
-
-The limit for uploading a file is specified in bytes under <maxMemory>.
-
-The 'userid' retrieved from the form is tested using the predicates provided in 'idPredicates',
-
-The file passed into the form has it's file extension tested against keys in the map,
-so e.g. if only '.csv' is present in the map, only '.csv' files can be uploaded.
-predicates under that file extension are used to test the file provided. e.g. '.csv: { somePredicate },
-where somePredicate is used to test the file provided.
-*/
-func (a *API) HandleCreateFile(fileTypeMap map[string][]predicate.Predicate[io.Reader], idPredicates []predicate.Predicate[string]) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userid := r.FormValue(userIdFormKey)
-		for _, p := range idPredicates {
-			if !p.Test(userid) {
-				http.Error(w, p.ErrorMessage(userid), http.StatusBadRequest)
-				return
-			}
-		}
-		parsedId, err := strconv.ParseUint(userid, 10, 32)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		err = r.ParseMultipartForm(maxMemory)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		file, fileHeader, err := r.FormFile(fileFormKey)
-		if err != nil {
-			http.Error(w, "unable to create file", http.StatusBadRequest)
-			return
-		}
-		var f = &container.File{
-			UserID: uint32(parsedId),
-			Name:   fileHeader.Filename,
-		}
-		if b, err := a.Services.FileService.UserHasFileEntry(f); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		} else if !b {
-			if err := a.Services.FileService.CreateFileEntry(f); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		} else {
-			if err := a.Services.FileService.UpdateFileEntry(f); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-		}
-		data, err := io.ReadAll(file)
-		if err != nil {
-			http.Error(w, "unable to create file", http.StatusBadRequest)
-			return
-		}
-		filePath := filepath.Join("./uploads", userid)
-		path := filepath.Join(filePath, fileHeader.Filename)
-
-		ext := filepath.Ext(path)
-		if _, exists := fileTypeMap[ext]; !exists {
-			http.Error(w, "file type not supported", http.StatusBadRequest)
-			return
-		}
-
-		var predicates = fileTypeMap[ext]
-		for _, p := range predicates {
-			d := io.NopCloser(bytes.NewReader(data))
-			if !p.Test(d) {
-				http.Error(w, p.ErrorMessage(d), http.StatusBadRequest)
-				return
-			}
-		}
-		if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		outFile, err := os.Create(path)
-		if err != nil {
-			http.Error(w, "Unable to create file", http.StatusInternalServerError)
-			return
-		}
-		defer outFile.Close()
-
-		d := io.NopCloser(bytes.NewReader(data))
-
-		_, err = io.Copy(outFile, d)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		_, err = fmt.Fprintf(w, "File uploaded successfully: %s", fileHeader.Filename)
-
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-	}
-}
-
 //HandleGetFileById
 /*
 Retrieves a file `container.File` by using the id `uint32` of the file.
 
 '<path>?operation=<your-operation>&columns<columns>
 
-columns must be delimited by a comma
+the operations available are listed by fileQueries, the file itself is sent when no operation is requested.
 */
 func (a *API) HandleGetFileById(w http.ResponseWriter, r *http.Request) {
 	id, err := getStringId("file_id", r)
@@ -304,34 +194,7 @@ func (a *API) HandleGetFileById(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
-	filePath := filepath.Join("./uploads", strconv.Itoa(int(file.UserID)), file.Name)
-	columnsParam := r.URL.Query().Get("columns")
-	columns := strings.Split(columnsParam, ",")
-	p := QueryParams{
-		Operation: r.URL.Query().Get("operation"),
-		Column:    columns,
-	}
-	qb := NewQueryBuilder().
-		AddQuery("stats", a.calculateStats).
-		AddQuery("statsn", a.calculateStatsN).
-		SetDefaultCase(func(w http.ResponseWriter, _ []string, filePath string) {
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filePath))
-			w.Header().Set("Content-Type", "application/octet-stream")
-			if _, err := os.Stat(filePath); os.IsNotExist(err) {
-				http.Error(w, "file not found", http.StatusNotFound)
-				return
-			}
-			f, err := os.Open(filePath)
-			if err != nil {
-				http.Error(w, "unable to open file", http.StatusInternalServerError)
-				return
-			}
-			defer f.Close()
-			if _, err := io.Copy(w, f); err != nil {
-				http.Error(w, "unable to send file", http.StatusInternalServerError)
-			}
-		})
-	qb.Build(w, p, filePath)
+	a.queryFile(w, r, file)
 }
 
 //HandleDeleteUserFileByName
@@ -361,6 +224,7 @@ func (a *API) HandleDeleteUserFileByName(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "unable to delete file", http.StatusInternalServerError)
 		return
 	}
+	a.invalidateResults(file)
 }
 
 //HandleGetUserFileByName
@@ -369,7 +233,7 @@ Retrieves a user file a specified by the user ID `uint32` and the file name `str
 
 '<path>?operation=<your-operation>&columns<columns>
 
-columns must be delimited by a comma
+the operations available are listed by fileQueries, the file itself is sent when no operation is requested.
 */
 func (a *API) HandleGetUserFileByName(w http.ResponseWriter, r *http.Request) {
 	userid, err := getStringId("user_id", r)
@@ -379,43 +243,102 @@ func (a *API) HandleGetUserFileByName(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
-	filePath := filepath.Join("./uploads", strconv.Itoa(int(userid)), file.Name)
+	a.queryFile(w, r, file)
+}
+
+//queryFile
+/*
+Runs the operation requested in the query of `r` against the stored data of `file`,
+when no operation is requested the file itself is sent, see serveFile.
+*/
+func (a *API) queryFile(w http.ResponseWriter, r *http.Request, file *container.File) {
+	filePath := userFilePath(file.UserID, file.Name)
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
-	columnsParam := r.URL.Query().Get("columns")
-	columns := strings.Split(columnsParam, ",")
+	a.runQuery(w, r, file, nil)
+}
+
+//runQuery
+/*
+Runs the operation requested in the query of `r` against the stored data of `file` as queryFile does,
+with `monitor` observing the reading of the file unless it is nil.
+*/
+func (a *API) runQuery(w http.ResponseWriter, r *http.Request, file *container.File, monitor stats.Monitor) {
 	p := QueryParams{
 		Operation: r.URL.Query().Get("operation"),
-		Column:    columns,
-	}
-	qb := NewQueryBuilder().
-		AddQuery("stats", a.calculateStats).
-		AddQuery("statsn", a.calculateStatsN).
-		SetDefaultCase(func(w http.ResponseWriter, _ []string, filePath string) {
-			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filePath))
-			w.Header().Set("Content-Type", "application/octet-stream")
-			if _, err := os.Stat(filePath); os.IsNotExist(err) {
-				http.Error(w, "file not found", http.StatusNotFound)
-				return
-			}
-			f, err := os.Open(filePath)
-			if err != nil {
-				http.Error(w, "unable to open file", http.StatusInternalServerError)
-				return
-			}
-			defer f.Close()
-			if _, err := io.Copy(w, f); err != nil {
-				http.Error(w, "unable to send file", http.StatusInternalServerError)
-			}
-		})
-	qb.Build(w, p, filePath)
+		Column:    splitList(r.URL.Query().Get("columns")),
+		Where:     r.URL.Query().Get("where"),
+		File:      file,
+		Monitor:   monitor,
+	}
+	a.fileQueries().Build(w, r, p, userFilePath(file.UserID, file.Name))
+}
+
+//fileQueries
+/*
+Returns the operations that can be run against the stored data of a file,
+requested with '<path>?operation=<your-operation>&columns<columns>' by HandleGetFileById and HandleGetUserFileByName.
+
+columns must be delimited by a comma, operations on columns are applied to every column in a single pass over the file.
+
+the 'stats' and 'statsn' operations accept '&metrics=<metrics>&percentiles=<percentiles>' to choose what is calculated,
+see stats.Statistics for the metrics available, and '&accuracy=approx' to estimate quantiles with a t-digest.
+the 'stats' operation parses the file in parallel with '&workers=<workers>' goroutines, one per CPU by default,
+while 'statsn' reads it on a single goroutine.
+
+the 'groupby' operation aggregates the rows of each group of values instead, see groupBy,
+the 'pivot' and 'crosstab' operations spread the groups into a wide table, see pivot and crosstab,
+the 'histogram' operation calculates the distribution of the columns, see histogram,
+the 'correlation' operation how the columns relate to each other, see correlation,
+the 'regression' operation fits a linear model, see regression,
+the 'ttest', 'mannwhitney' and 'chisquare' operations compare two columns, groups or files, see hypothesisTest,
+the 'resample' and 'rolling' operations summarise the rows over time, see resample and rolling,
+the 'outliers' operation flags values far from the rest of their column, see outliers,
+the 'profile' operation infers the type of every column and summarises it, see profile,
+and the 'preview' operation pages through the rows of the file, see preview.
+
+the results of every operation other than 'preview' are cached by the content of the file and the query,
+the 'X-Cache' header of the response is 'HIT' when the result was read from the cache, see cached.
+
+every operation, and the file itself, can be restricted to the rows matching '&where=<expression>',
+e.g. 'where=setting = 'inpatient' AND price > 0', see filter.Filter for the expressions supported.
+*/
+func (a *API) fileQueries() *QueryBuilder {
+	return NewQueryBuilder().
+		AddQuery("stats", a.cached(a.calculateStats)).
+		AddQuery("statsn", a.cached(a.calculateStatsN)).
+		AddQuery("groupby", a.cached(a.groupBy)).
+		AddQuery("pivot", a.cached(a.pivot)).
+		AddQuery("crosstab", a.cached(a.crosstab)).
+		AddQuery("histogram", a.cached(a.histogram)).
+		AddQuery("correlation", a.cached(a.correlation)).
+		AddQuery("regression", a.cached(a.regression)).
+		AddQuery("ttest", a.hypothesisTest(stats.TestWelch)).
+		AddQuery("mannwhitney", a.hypothesisTest(stats.TestMannWhitney)).
+		AddQuery("chisquare", a.hypothesisTest(stats.TestChiSquare)).
+		AddQuery("resample", a.cached(a.resample)).
+		AddQuery("rolling", a.cached(a.rolling)).
+		AddQuery("outliers", a.cached(a.outliers)).
+		AddQuery("profile", a.cached(a.profile)).
+		AddQuery("preview", a.preview).
+		SetDefaultCase(a.serveFile)
 }
-func (a *API) calculateStatsN(w http.ResponseWriter, columns []string, filePath string) {
-	var s, t, err = stats.CalculateStatisticsN(columns, filePath)
+
+func (a *API) calculateStatsN(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if len(params.Column) == 0 {
+		http.Error(w, "columns must be provided", http.StatusBadRequest)
+		return
+	}
+	opts, err := metricOptions(r, statsOptions(params))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, t, err := stats.CalculateStatisticsN(params.Column, filePath, opts)
+	if err != nil {
+		statsError(w, err)
 		return
 	}
 
@@ -428,10 +351,23 @@ func (a *API) calculateStatsN(w http.ResponseWriter, columns []string, filePath
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
-func (a *API) calculateStats(w http.ResponseWriter, columns []string, filePath string) {
-	var s, t, err = stats.CalculateStatistics(columns, filePath)
+func (a *API) calculateStats(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if len(params.Column) == 0 {
+		http.Error(w, "columns must be provided", http.StatusBadRequest)
+		return
+	}
+	opts, err := metricOptions(r, statsOptions(params))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Workers, err = intParam(r, "workers"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s, t, err := stats.CalculateStatistics(params.Column, filePath, opts)
+	if err != nil {
+		statsError(w, err)
 		return
 	}
 
@@ -444,31 +380,603 @@ func (a *API) calculateStats(w http.ResponseWriter, columns []string, filePath s
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
-func (a *API) HandleUpdateFileById(w http.ResponseWriter, r *http.Request) {
-	id, err := getStringId("file_id", r)
-	fmt.Println(id)
+
+//groupBy
+/*
+Groups the rows of the file by the columns in 'by' and calculates the aggregates in 'agg' for each group,
+e.g. '?operation=groupby&by=payer,code&agg=mean(price),count(*)&sort=-mean(price)&limit=10&format=csv'.
+See stats.GroupBy for the aggregates available.
+*/
+func (a *API) groupBy(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if err := checkFormat(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	grouping := stats.GroupOptions{
+		By:         splitList(query.Get("by")),
+		Aggregates: splitList(query.Get("agg")),
+		Sort:       splitList(query.Get("sort")),
+	}
+	var err error
+	if grouping.Limit, err = intParam(r, "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table, t, err := stats.GroupBy(filePath, statsOptions(params), grouping)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		statsError(w, err)
+		return
+	}
+	writeTable(w, r, table, t)
+}
+
+//pivot
+/*
+Aggregates the rows into a wide table, e.g. '?operation=pivot&rows=payer&cols=setting&value=price&agg=mean',
+with a row for each value of the 'rows' columns and a column for each value of the 'cols' columns.
+'agg' is one of the aggregate functions of groupby, 'count' of the rows when it and 'value' are left out,
+and '&totals=rows,columns' adds the totals of each row, of each column or of both. See stats.PivotOptions.
+*/
+func (a *API) pivot(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if err := checkFormat(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	pivot := stats.PivotOptions{
+		Rows:      splitList(query.Get("rows")),
+		Columns:   splitList(query.Get("cols")),
+		Value:     query.Get("value"),
+		Aggregate: query.Get("agg"),
+	}
+	if err := pivotTotals(r, &pivot); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	u, err := a.Services.FileService.GetFileById(id)
+	table, t, err := stats.Pivot(filePath, statsOptions(params), pivot)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		statsError(w, err)
 		return
 	}
-	if u == nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+	writeTable(w, r, table, t)
+}
+
+//crosstab
+/*
+Counts the rows of each pair of values of two categorical columns as a contingency table,
+e.g. '?operation=crosstab&columns=payer,setting&totals=rows,columns', the first column giving the rows of the table
+and the second its columns.
+*/
+func (a *API) crosstab(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if err := checkFormat(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(params.Column) != 2 {
+		http.Error(w, "exactly two columns must be provided", http.StatusBadRequest)
+		return
+	}
+	pivot := stats.PivotOptions{
+		Rows:      params.Column[:1],
+		Columns:   params.Column[1:],
+		Aggregate: stats.AggregateCount,
+	}
+	if err := pivotTotals(r, &pivot); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table, t, err := stats.Pivot(filePath, statsOptions(params), pivot)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeTable(w, r, table, t)
+}
+
+//pivotTotals
+/*
+Sets the totals of `pivot` requested with 'totals', a comma delimited list of 'rows' and 'columns'.
+*/
+func pivotTotals(r *http.Request, pivot *stats.PivotOptions) error {
+	for _, total := range splitList(r.URL.Query().Get("totals")) {
+		switch total {
+		case "rows":
+			pivot.RowTotals = true
+		case "columns":
+			pivot.ColumnTotals = true
+		default:
+			return fmt.Errorf("totals must be 'rows', 'columns' or both, got '%s'", total)
+		}
+	}
+	return nil
+}
+
+//histogram
+/*
+Calculates the distribution of every column in 'columns', e.g. '?operation=histogram&columns=price&method=fd'.
+'method' is one of 'width' (the default) or 'count' with '&bins=<bins>', 'fd', 'edges' with '&edges=0,100,1000',
+or 'values' to count the distinct values, which is always done for categorical columns along with '&limit=<limit>'.
+See stats.HistogramOptions.
+*/
+func (a *API) histogram(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if len(params.Column) == 0 {
+		http.Error(w, "columns must be provided", http.StatusBadRequest)
+		return
+	}
+	hist := stats.HistogramOptions{Method: r.URL.Query().Get("method")}
+	var err error
+	if hist.Bins, err = intParam(r, "bins"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hist.Limit, err = intParam(r, "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if edges := r.URL.Query().Get("edges"); edges != "" {
+		for _, e := range strings.Split(edges, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(e), 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid edge '%s'", e), http.StatusBadRequest)
+				return
+			}
+			hist.Edges = append(hist.Edges, v)
+		}
+	}
+	h, t, err := stats.CalculateHistograms(params.Column, filePath, statsOptions(params), hist)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"histograms": h,
+		"time":       time.Since(*t).Milliseconds(),
+	})
+}
+
+//correlation
+/*
+Calculates the correlation and covariance matrices of the columns in 'columns',
+e.g. '?operation=correlation&columns=price,units&method=pearson,spearman&missing=listwise'.
+See stats.CalculateCorrelation.
+*/
+func (a *API) correlation(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	corr := stats.CorrelationOptions{
+		Methods: splitList(r.URL.Query().Get("method")),
+		Missing: r.URL.Query().Get("missing"),
+	}
+	c, t, err := stats.CalculateCorrelation(params.Column, filePath, statsOptions(params), corr)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"correlation": c,
+		"time":        time.Since(*t).Milliseconds(),
+	})
+}
+
+//regression
+/*
+Fits a linear regression of the column 'y' on the numeric columns in 'x' and the categorical columns in 'categorical',
+e.g. '?operation=regression&y=price&x=units&categorical=payer', '&intercept=false' leaves the intercept out.
+See stats.Regress.
+*/
+func (a *API) regression(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	query := r.URL.Query()
+	reg := stats.RegressionOptions{
+		Response:    query.Get("y"),
+		Predictors:  splitList(query.Get("x")),
+		Categorical: splitList(query.Get("categorical")),
+	}
+	switch intercept := query.Get("intercept"); intercept {
+	case "", "true":
+	case "false":
+		reg.NoIntercept = true
+	default:
+		http.Error(w, "intercept must be 'true' or 'false'", http.StatusBadRequest)
 		return
 	}
-	var updatedFile container.File
-	err = json.NewDecoder(r.Body).Decode(&updatedFile)
+	fit, t, err := stats.Regress(filePath, statsOptions(params), reg)
 	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"regression": fit,
+		"time":       time.Since(*t).Milliseconds(),
+	})
+}
+
+//resampleOptions
+/*
+Reads the date column 'date', its Go layout 'layout', the period 'every' and the aggregates 'agg' of a resampling.
+*/
+func resampleOptions(r *http.Request) stats.ResampleOptions {
+	query := r.URL.Query()
+	return stats.ResampleOptions{
+		Date:       query.Get("date"),
+		Layout:     query.Get("layout"),
+		Every:      query.Get("every"),
+		Aggregates: splitList(query.Get("agg")),
+	}
+}
+
+//resample
+/*
+Aggregates the rows of each day, week or month of a date column,
+e.g. '?operation=resample&date=date&every=week&agg=sum(price),count(*)&format=csv'. See stats.Resample.
+*/
+func (a *API) resample(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if err := checkFormat(r); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table, t, err := stats.Resample(filePath, statsOptions(params), resampleOptions(r))
+	if err != nil {
+		statsError(w, err)
+		return
 	}
-	updatedFile.ID = id
-	if err := a.Services.FileService.UpdateFileEntry(&updatedFile); err != nil {
+	writeTable(w, r, table, t)
+}
+
+//rolling
+/*
+Resamples the file as the 'resample' operation does and applies 'function', 'mean' or 'sum' over the last 'window'
+periods or 'cumsum', to each aggregate e.g. '?operation=rolling&date=date&agg=sum(price)&function=mean&window=7'.
+See stats.Rolling.
+*/
+func (a *API) rolling(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	if err := checkFormat(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ro := stats.RollingOptions{
+		ResampleOptions: resampleOptions(r),
+		Function:        r.URL.Query().Get("function"),
+	}
+	var err error
+	if ro.Window, err = intParam(r, "window"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	table, t, err := stats.Rolling(filePath, statsOptions(params), ro)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeTable(w, r, table, t)
+}
+
+//hypothesisTest
+/*
+Returns the handler of the operation running the hypothesis `test`, see stats.CompareSamples,
+e.g. '?operation=ttest&columns=price&group1=payer = 'A'&group2=payer = 'B'' to compare the prices of two payers.
+
+The two samples are the values of 'columns' in the rows matching 'group1' and 'group2' respectively,
+a second column in 'columns' is read for the second sample and '&other=<file name>' reads the second sample
+from another file of the user, so samples are compared between two columns, two groups or two files.
+With two columns and neither groups nor another file, 'chisquare' tests whether the columns are independent
+instead, see stats.TestIndependence.
+*/
+func (a *API) hypothesisTest(test string) QueryHandler {
+	run := a.cached(func(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+		query := r.URL.Query()
+		groups := []string{query.Get("group1"), query.Get("group2")}
+		if test == stats.TestChiSquare && len(params.Related) == 0 && groups[0] == "" && groups[1] == "" {
+			result, t, err := stats.TestIndependence(params.Column, filePath, statsOptions(params))
+			if err != nil {
+				statsError(w, err)
+				return
+			}
+			writeJson(w, map[string]interface{}{
+				"test": result,
+				"time": time.Since(*t).Milliseconds(),
+			})
+			return
+		}
+
+		samples := [2]stats.Sample{
+			{Column: params.Column[0], Where: groups[0]},
+			{Column: params.Column[len(params.Column)-1], Where: groups[1]},
+		}
+		for i := range samples {
+			samples[i].Name, samples[i].FilePath, samples[i].Options = samples[i].Column, filePath, statsOptions(params)
+		}
+		if len(params.Related) > 0 {
+			other := params
+			other.File = params.Related[0]
+			samples[1].Name = other.File.Name + ": " + samples[1].Column
+			samples[1].FilePath, samples[1].Options = userFilePath(other.File.UserID, other.File.Name), statsOptions(other)
+		}
+		for i := range samples {
+			if samples[i].Where != "" {
+				samples[i].Name += " where " + samples[i].Where
+			}
+		}
+		if samples[0].Name == samples[1].Name {
+			http.Error(w, "the samples must differ by column, group or file", http.StatusBadRequest)
+			return
+		}
+		result, t, err := stats.CompareSamples(test, samples)
+		if err != nil {
+			statsError(w, err)
+			return
+		}
+		writeJson(w, map[string]interface{}{
+			"test": result,
+			"time": time.Since(*t).Milliseconds(),
+		})
+	})
+	return func(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+		if len(params.Column) == 0 || len(params.Column) > 2 {
+			http.Error(w, "one or two columns must be provided", http.StatusBadRequest)
+			return
+		}
+		if name := r.URL.Query().Get("other"); name != "" {
+			other, err := a.Services.FileService.GetUserFileByName(params.File.UserID, name)
+			if other == nil || err != nil {
+				http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+				return
+			}
+			if _, err := os.Stat(userFilePath(other.UserID, other.Name)); os.IsNotExist(err) {
+				http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+				return
+			}
+			params.Related = append(params.Related, other)
+		}
+		run(w, r, params, filePath)
+	}
+}
+
+//outliers
+/*
+Flags the values of the columns in 'columns' scored beyond 'threshold' by 'method', 'zscore', 'mad' or 'iqr',
+e.g. '?operation=outliers&columns=price&method=iqr&threshold=3&limit=50'.
+'&recompute=true' also calculates the statistics of each column without its outliers,
+with the metrics and percentiles of the 'stats' operation. See stats.DetectOutliers.
+*/
+func (a *API) outliers(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	query := r.URL.Query()
+	od := stats.OutlierOptions{Method: query.Get("method")}
+	if threshold := query.Get("threshold"); threshold != "" {
+		v, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			http.Error(w, "threshold must be a number", http.StatusBadRequest)
+			return
+		}
+		od.Threshold = &v
+	}
+	var err error
+	if od.Limit, err = intParam(r, "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch recompute := query.Get("recompute"); recompute {
+	case "", "false":
+	case "true":
+		od.Recompute = true
+	default:
+		http.Error(w, "recompute must be 'true' or 'false'", http.StatusBadRequest)
+		return
+	}
+	opts, err := metricOptions(r, statsOptions(params))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	o, t, err := stats.DetectOutliers(params.Column, filePath, opts, od)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"outliers": o,
+		"time":     time.Since(*t).Milliseconds(),
+	})
+}
+
+//profile
+/*
+Infers the type of every column, or of the columns in 'columns', and summarises their values,
+e.g. '?operation=profile&top=5' reports the 5 most frequent values of each column. See stats.ProfileFile.
+*/
+func (a *API) profile(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	top, err := intParam(r, "top")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, t, err := stats.ProfileFile(params.Column, filePath, statsOptions(params), top)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"profile": p,
+		"time":    time.Since(*t).Milliseconds(),
+	})
+}
+
+//preview
+/*
+Returns a page of the rows of the file keyed by column name, restricted to the columns in 'columns' when it is set,
+e.g. '?operation=preview&offset=200&limit=100', or '?operation=preview&cursor=<next_cursor>' to continue
+from the end of the previous page without reading the rows before it. See stats.PreviewFile.
+*/
+func (a *API) preview(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
+	query := r.URL.Query()
+	pv := stats.PreviewOptions{
+		Columns: params.Column,
+		Cursor:  query.Get("cursor"),
+		Version: params.File.SHA256,
+		Total:   params.File.RowCount,
+	}
+	var err error
+	if pv.Limit, err = intParam(r, "limit"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if offset := query.Get("offset"); offset != "" {
+		if pv.Offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+			http.Error(w, "offset must be a number", http.StatusBadRequest)
+			return
+		}
+	}
+	p, t, err := stats.PreviewFile(filePath, statsOptions(params), pv)
+	if err != nil {
+		statsError(w, err)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"preview": p,
+		"time":    time.Since(*t).Milliseconds(),
+	})
+}
+
+//HandleUpdateFileById
+/*
+Renames and/or transfers the file `container.File` referenced by the file_id `uint32` provided in the URI/L.
+
+The method expects a JSON object with an optional 'name' and an optional 'user_id',
+fields that are left out keep their current value. The stored data is moved along with the database entry,
+see moveFile.
+*/
+func (a *API) HandleUpdateFileById(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.fileFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dst := *f
+	if req.Name != nil {
+		dst.Name = *req.Name
+	}
+	if req.UserID != nil {
+		dst.UserID = *req.UserID
+	}
+	a.moveFile(w, f, &dst)
+}
+
+//HandleRenameFileById
+/*
+Renames the file `container.File` referenced by the file_id `uint32` provided in the URI/L,
+the method expects a JSON object containing the new 'name' of the file.
+*/
+func (a *API) HandleRenameFileById(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.fileFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == nil {
+		http.Error(w, "name must be provided", http.StatusBadRequest)
+		return
+	}
+	dst := *f
+	dst.Name = *req.Name
+	a.moveFile(w, f, &dst)
+}
+
+//HandleTransferFileById
+/*
+Transfers the file `container.File` referenced by the file_id `uint32` provided in the URI/L to another user,
+the method expects a JSON object containing the 'user_id' of the new owner as a non-negative number.
+The body is checked here rather than with middleware.InterceptJson, which only validates string fields.
+*/
+func (a *API) HandleTransferFileById(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.fileFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req UpdateFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == nil {
+		http.Error(w, "user_id must be provided", http.StatusBadRequest)
+		return
+	}
+	dst := *f
+	dst.UserID = *req.UserID
+	a.moveFile(w, f, &dst)
+}
+
+type UpdateFileRequest struct {
+	UserID *uint32 `json:"user_id"`
+	Name   *string `json:"name"`
+}
+
+//moveFile
+/*
+Moves the file `f` to the owner and name in `dst`, both on disk and in the database.
+
+The file extension cannot be changed and the new owner must exist. The row is updated and the stored data renamed
+in a single transaction, so a name conflict or a failure at any step leaves both the row and the data untouched.
+Writes the updated `container.File` as JSON on success.
+*/
+func (a *API) moveFile(w http.ResponseWriter, f *container.File, dst *container.File) {
+	if dst.UserID == f.UserID && dst.Name == f.Name {
+		writeJson(w, f)
+		return
+	}
+	if !predicate.AllowedCharacters.Test(dst.Name) {
+		http.Error(w, predicate.AllowedCharacters.ErrorMessage(dst.Name), http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(filepath.Ext(dst.Name), filepath.Ext(f.Name)) {
+		http.Error(w, "file extension cannot be changed", http.StatusBadRequest)
+		return
+	}
+	if dst.UserID != f.UserID {
+		exists, err := a.Services.UserService.UserExists(&container.User{ID: dst.UserID})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+	src := userFilePath(f.UserID, f.Name)
+	target := userFilePath(dst.UserID, dst.Name)
+	move := func() error {
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if _, err := os.Stat(target); err == nil {
+			return service.ErrFileExists
+		}
+		return os.Rename(src, target)
+	}
+	undo := func() error {
+		return os.Rename(target, src)
+	}
+	err := a.Services.FileService.MoveFileEntry(f, dst, move, undo)
+	switch {
+	case errors.Is(err, service.ErrFileExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	a.invalidateResults(f)
+	writeJson(w, dst)
 }
 
 func (a *API) HandleGetUserFiles(w http.ResponseWriter, r *http.Request) {
@@ -490,6 +998,177 @@ func (a *API) HandleGetUserFiles(w http.ResponseWriter, r *http.Request) {
 }
 
 // Helper Functions
+const uploadDir = "./uploads"
+
+//userFilePath
+/*
+Returns the path on disk of the file `name` owned by the user `userId`.
+*/
+func userFilePath(userId uint32, name string) string {
+	return filepath.Join(uploadDir, strconv.Itoa(int(userId)), name)
+}
+
+//fileFromPath
+/*
+Looks up the `container.File` referenced by the file_id in the URI/L,
+writes an error response and returns false if it cannot be found.
+*/
+func (a *API) fileFromPath(w http.ResponseWriter, r *http.Request) (*container.File, bool) {
+	id, err := getStringId("file_id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	f, err := a.Services.FileService.GetFileById(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if f == nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return nil, false
+	}
+	return f, true
+}
+
+//statsOptions
+/*
+Returns the `stats.Options` used to read the stored data of the file of `params`, keeping only the rows matching its filter.
+*/
+func statsOptions(params QueryParams) stats.Options {
+	opts := stats.Options{Where: params.Where, Monitor: params.Monitor}
+	for _, d := range params.File.Delimiter {
+		opts.Delimiter = d
+		break
+	}
+	return opts
+}
+
+//metricOptions
+/*
+Adds the metrics requested with 'metrics' and the percentiles requested with 'percentiles' to `opts`,
+both are delimited by a comma e.g. '?metrics=mean,iqr,percentiles&percentiles=5,95'.
+'accuracy' may be 'exact' (the default) or 'approx' to estimate quantiles in a single pass with bounded memory.
+*/
+func metricOptions(r *http.Request, opts stats.Options) (stats.Options, error) {
+	switch accuracy := r.URL.Query().Get("accuracy"); accuracy {
+	case "", "exact":
+	case "approx":
+		opts.Approximate = true
+	default:
+		return opts, fmt.Errorf("accuracy must be 'exact' or 'approx', got '%s'", accuracy)
+	}
+	opts.Metrics = splitList(r.URL.Query().Get("metrics"))
+	for _, p := range splitList(r.URL.Query().Get("percentiles")) {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid percentile: %s", p)
+		}
+		opts.Percentiles = append(opts.Percentiles, v)
+	}
+	return opts, nil
+}
+
+//statsError
+/*
+Writes the error returned by the stats package, errors caused by the request are reported as a bad request.
+*/
+func statsError(w http.ResponseWriter, err error) {
+	var columnErr *stats.ColumnNotFoundError
+	var paramErr *stats.ParameterError
+	var filterErr *filter.Error
+	if errors.As(err, &columnErr) || errors.As(err, &paramErr) || errors.As(err, &filterErr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+//intParam
+/*
+Returns the integer query parameter `name` of `r`, or zero when it is not set.
+*/
+func intParam(r *http.Request, name string) (int, error) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+	return n, nil
+}
+
+//splitList
+/*
+Splits a comma delimited query parameter, dropping empty and repeated entries.
+*/
+func splitList(param string) []string {
+	var list []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(param, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		list = append(list, s)
+	}
+	return list
+}
+
+//checkFormat
+/*
+Returns an error if the 'format' requested is not one writeTable can write,
+so that it is rejected before the table is computed.
+*/
+func checkFormat(r *http.Request) error {
+	switch r.URL.Query().Get("format") {
+	case "", "json", "csv", "xlsx":
+		return nil
+	}
+	return errors.New("format must be 'json', 'csv' or 'xlsx'")
+}
+
+//writeTable
+/*
+Writes the result of an operation as JSON, along with the time it took since `t`,
+or as CSV when '?format=csv' is requested and as an Excel workbook when '?format=xlsx' is,
+in which case the number of rows before any limit is sent in 'X-Total-Count'.
+*/
+func writeTable(w http.ResponseWriter, r *http.Request, table *stats.Table, t *time.Time) {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJson(w, map[string]interface{}{
+			"table": table,
+			"time":  time.Since(*t).Milliseconds(),
+		})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("X-Total-Count", strconv.Itoa(table.Total))
+		if err := table.WriteCSV(w, 0); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("X-Total-Count", strconv.Itoa(table.Total))
+		if err := table.WriteXLSX(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, checkFormat(r).Error(), http.StatusBadRequest)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func getStringId(key string, r *http.Request) (uint32, error) {
 	val, ok := r.Context().Value(key).(string)
 	if !ok {
@@ -0,0 +1,230 @@
+package handler
+
+import (
+	constants "api-3390/const"
+	"api-3390/service"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestAPI returns an API over an in-memory database holding the users 1 and 2. The test runs from a temporary
+// directory so that the stored data of its files is kept under an uploads directory of its own.
+func newTestAPI(t *testing.T) (*API, *sql.DB) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to ':memory:' opens a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	for _, table := range []string{"PRAGMA foreign_keys = ON", constants.UserTable, constants.UserFileTable} {
+		if _, err := db.Exec(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := db.Exec("INSERT INTO users (name, email, password) VALUES (?, ?, '')", name, name+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := &API{Services: &Services{
+		FileService: service.NewFileService(db),
+		UserService: service.NewUserService(db),
+	}}
+	return api, db
+}
+
+// addFile stores `content` as the file `name` of the user `userId` and returns the ID of its entry.
+func addFile(t *testing.T, db *sql.DB, userId uint32, name, content string) uint32 {
+	t.Helper()
+	path := userFilePath(userId, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.Exec("INSERT INTO user_files (user_id, name) VALUES (?, ?)", userId, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uint32(id)
+}
+
+// serve calls `handler` with a request carrying `params` in its context, as middleware.URLParam leaves them.
+func serve(handler http.HandlerFunc, method, target, body string, params map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	ctx := r.Context()
+	for key, value := range params {
+		ctx = context.WithValue(ctx, key, value)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r.WithContext(ctx))
+	return w
+}
+
+// storedFile returns the content of the file `name` of the user `userId`, or false if it is not stored.
+func storedFile(t *testing.T, userId uint32, name string) (string, bool) {
+	t.Helper()
+	b, err := os.ReadFile(userFilePath(userId, name))
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), true
+}
+
+func TestMoveFile(t *testing.T) {
+	const content = "a,b\n1,2\n"
+	tests := []struct {
+		name   string
+		serve  func(api *API) http.HandlerFunc
+		body   string
+		setup  func(t *testing.T, db *sql.DB)
+		status int
+		// the owner and name the file is expected to end up with
+		userId   uint32
+		fileName string
+	}{
+		{name: "rename", serve: renameHandler, body: `{"name":"c.csv"}`, status: http.StatusOK, userId: 1, fileName: "c.csv"},
+		{name: "transfer", serve: transferHandler, body: `{"user_id":2}`, status: http.StatusOK, userId: 2, fileName: "a.csv"},
+		{name: "update", serve: updateHandler, body: `{"user_id":2,"name":"d.csv"}`, status: http.StatusOK, userId: 2, fileName: "d.csv"},
+		{name: "unchanged", serve: updateHandler, body: `{}`, status: http.StatusOK, userId: 1, fileName: "a.csv"},
+		{name: "name taken", serve: renameHandler, body: `{"name":"b.csv"}`, status: http.StatusConflict, userId: 1, fileName: "a.csv"},
+		{
+			name: "data in the way", serve: transferHandler, body: `{"user_id":2}`, status: http.StatusConflict, userId: 1, fileName: "a.csv",
+			setup: func(t *testing.T, db *sql.DB) {
+				os.MkdirAll(filepath.Dir(userFilePath(2, "a.csv")), os.ModePerm)
+				if err := os.WriteFile(userFilePath(2, "a.csv"), []byte("other"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "data missing", serve: renameHandler, body: `{"name":"c.csv"}`, status: http.StatusNotFound, userId: 1, fileName: "a.csv",
+			setup: func(t *testing.T, db *sql.DB) {
+				if err := os.Remove(userFilePath(1, "a.csv")); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{name: "extension", serve: renameHandler, body: `{"name":"a.txt"}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+		{name: "characters", serve: renameHandler, body: `{"name":"../a.csv"}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+		{name: "no name", serve: renameHandler, body: `{}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+		{name: "unknown user", serve: transferHandler, body: `{"user_id":9}`, status: http.StatusNotFound, userId: 1, fileName: "a.csv"},
+		{name: "no user", serve: transferHandler, body: `{}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+		{name: "negative user", serve: transferHandler, body: `{"user_id":-1}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+		{name: "user as text", serve: transferHandler, body: `{"user_id":"2"}`, status: http.StatusBadRequest, userId: 1, fileName: "a.csv"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			api, db := newTestAPI(t)
+			id := addFile(t, db, 1, "a.csv", content)
+			addFile(t, db, 1, "b.csv", "b\n1\n")
+			if tc.setup != nil {
+				tc.setup(t, db)
+			}
+			w := serve(tc.serve(api), http.MethodPost, "/files/1", tc.body, map[string]string{"file_id": "1"})
+			if w.Code != tc.status {
+				t.Fatalf("status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			}
+			f, err := api.Services.FileService.GetFileById(id)
+			if err != nil || f == nil {
+				t.Fatalf("file %d: %v", id, err)
+			}
+			if f.UserID != tc.userId || f.Name != tc.fileName {
+				t.Errorf("entry owned by %d named %q, want %d and %q", f.UserID, f.Name, tc.userId, tc.fileName)
+			}
+			if tc.status == http.StatusOK {
+				var got struct {
+					UserID uint32 `json:"user_id"`
+					Name   string `json:"name"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.UserID != tc.userId || got.Name != tc.fileName {
+					t.Errorf("response %s", w.Body.String())
+				}
+			}
+			if tc.name == "data missing" {
+				return
+			}
+			if got, ok := storedFile(t, tc.userId, tc.fileName); !ok || got != content {
+				t.Errorf("stored data of %d/%s is %q, want %q", tc.userId, tc.fileName, got, content)
+			}
+			if tc.userId != 1 || tc.fileName != "a.csv" {
+				if _, ok := storedFile(t, 1, "a.csv"); ok {
+					t.Error("the stored data was left at its old path")
+				}
+			}
+			if got, ok := storedFile(t, 1, "b.csv"); !ok || got != "b\n1\n" {
+				t.Errorf("the other file was changed to %q", got)
+			}
+		})
+	}
+}
+
+func renameHandler(api *API) http.HandlerFunc   { return api.HandleRenameFileById }
+func transferHandler(api *API) http.HandlerFunc { return api.HandleTransferFileById }
+func updateHandler(api *API) http.HandlerFunc   { return api.HandleUpdateFileById }
+
+func TestTableFormats(t *testing.T) {
+	api, db := newTestAPI(t)
+	id := addFile(t, db, 1, "a.csv", "payer,setting\nA,in\nA,out\nB,in\n")
+	params := map[string]string{"file_id": fmt.Sprint(id)}
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"operation=crosstab&columns=payer,setting&format=csv", http.StatusOK, "text/csv; charset=utf-8", "payer,in,out\nA,1,1\nB,1,0\n"},
+		{"operation=crosstab&columns=payer,setting&format=xlsx", http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", ""},
+		{"operation=crosstab&columns=payer,setting", http.StatusOK, "application/json", ""},
+		// the format is rejected before the missing column is looked for
+		{"operation=groupby&by=plan&format=xml", http.StatusBadRequest, "", "format must be 'json', 'csv' or 'xlsx'\n"},
+		{"operation=pivot&rows=plan&cols=setting&format=xls", http.StatusBadRequest, "", "format must be 'json', 'csv' or 'xlsx'\n"},
+		{"operation=resample&date=day&format=tsv", http.StatusBadRequest, "", "format must be 'json', 'csv' or 'xlsx'\n"},
+		{"operation=groupby&by=plan&format=csv", http.StatusBadRequest, "", ""},
+	}
+	for _, tc := range tests {
+		w := serve(api.HandleGetFileById, http.MethodGet, "/files/1?"+tc.query, "", params)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d (%s), want %d", tc.query, w.Code, strings.TrimSpace(w.Body.String()), tc.status)
+			continue
+		}
+		if tc.contentType != "" && w.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("%s: content type %s, want %s", tc.query, w.Header().Get("Content-Type"), tc.contentType)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: body %q, want %q", tc.query, w.Body.String(), tc.body)
+		}
+	}
+}
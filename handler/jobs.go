@@ -0,0 +1,400 @@
+package handler
+
+import (
+	"api-3390/container"
+	"api-3390/service"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultJobWorkers is the number of jobs run at once when no number of workers is given.
+const DefaultJobWorkers = 2
+
+// maxJobAttempts is the number of times a job failing unexpectedly, or interrupted by a restart, is started.
+const maxJobAttempts = 3
+
+// maxJobResultSize bounds the size in bytes of the result of a job.
+const maxJobResultSize = 64 << 20
+
+// jobPollInterval is how often idle workers look for queued jobs, they are woken up as soon as a job is created.
+const jobPollInterval = 5 * time.Second
+
+// jobProgressInterval is how often the progress of a running job is recorded.
+const jobProgressInterval = time.Second
+
+// jobRetention is how long finished jobs and their results are kept.
+const jobRetention = 24 * time.Hour
+
+//JobRunner
+/*
+Runs the queued jobs of the API in the background with a fixed number of workers, see HandleCreateFileJob.
+Jobs are claimed from the jobs table so they survive a restart: jobs left running by a previous run are queued again,
+see service.JobService.RecoverJobs.
+*/
+type JobRunner struct {
+	api     *API
+	workers int
+	wake    chan struct{}
+	mu      sync.Mutex
+	cancels map[uint32]context.CancelFunc
+}
+
+func NewJobRunner(api *API, workers int) *JobRunner {
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	return &JobRunner{
+		api:     api,
+		workers: workers,
+		wake:    make(chan struct{}, workers),
+		cancels: make(map[uint32]context.CancelFunc),
+	}
+}
+
+//Start
+/*
+Queues the jobs interrupted by a previous run again and starts the workers.
+*/
+func (jr *JobRunner) Start() error {
+	if err := jr.api.Services.JobService.RecoverJobs(maxJobAttempts); err != nil {
+		return err
+	}
+	for i := 0; i < jr.workers; i++ {
+		go jr.work()
+	}
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := jr.api.Services.JobService.DeleteFinishedJobs(time.Now().Add(-jobRetention)); err != nil {
+				log.Printf("jobs: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// notify wakes up an idle worker to pick up a job that was just queued.
+func (jr *JobRunner) notify() {
+	select {
+	case jr.wake <- struct{}{}:
+	default:
+	}
+}
+
+//cancel
+/*
+Stops the job `id` if one of the workers is running it, its status is set by the caller.
+*/
+func (jr *JobRunner) cancel(id uint32) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if cancel, ok := jr.cancels[id]; ok {
+		cancel()
+	}
+}
+
+func (jr *JobRunner) work() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := jr.api.Services.JobService.ClaimJob()
+		if err != nil {
+			log.Printf("jobs: %v", err)
+		}
+		if job == nil || err != nil {
+			select {
+			case <-jr.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+		jr.run(job)
+	}
+}
+
+//jobMonitor
+/*
+Follows how much of the file of a job has been read, and stops the read once the job is cancelled.
+*/
+type jobMonitor struct {
+	ctx  context.Context
+	read atomic.Int64
+}
+
+func (m *jobMonitor) Read(n int) error {
+	m.read.Add(int64(n))
+	return m.ctx.Err()
+}
+
+//jobRecorder
+/*
+Records the response written by the operation of a job, up to <maxJobResultSize> bytes of it.
+*/
+type jobRecorder struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (rec *jobRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *jobRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *jobRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.body.Len()+len(b) > maxJobResultSize {
+		rec.overflow = true
+		return 0, fmt.Errorf("the result is larger than %d bytes", maxJobResultSize)
+	}
+	return rec.body.Write(b)
+}
+
+//run
+/*
+Runs the operation of `job` and records its outcome. Operations rejecting their parameters fail the job,
+unexpected errors queue it again until it has been started <maxJobAttempts> times.
+*/
+func (jr *JobRunner) run(job *container.Job) {
+	js := jr.api.Services.JobService
+	ctx, cancel := context.WithCancel(context.Background())
+	jr.mu.Lock()
+	jr.cancels[job.ID] = cancel
+	jr.mu.Unlock()
+	defer func() {
+		jr.mu.Lock()
+		delete(jr.cancels, job.ID)
+		jr.mu.Unlock()
+		cancel()
+	}()
+	// a job cancelled once it was claimed but before its cancel function was registered is not run,
+	// cancelling it from now on stops it through ctx
+	if current, err := js.GetJobById(job.ID); err == nil && (current == nil || current.Status != service.JobRunning) {
+		return
+	}
+
+	fail := func(message string, retry bool) {
+		var err error
+		if retry && job.Attempts < maxJobAttempts {
+			err = js.RetryJob(job.ID, message)
+			jr.notify()
+		} else {
+			err = js.FinishJob(job.ID, service.JobFailed, message, nil)
+		}
+		if err != nil {
+			log.Printf("jobs: %v", err)
+		}
+	}
+	file, err := jr.api.Services.FileService.GetFileById(job.FileID)
+	if err != nil {
+		fail(err.Error(), true)
+		return
+	}
+	if file == nil {
+		fail("file not found", false)
+		return
+	}
+	info, err := os.Stat(userFilePath(file.UserID, file.Name))
+	if err != nil {
+		fail("file not found", false)
+		return
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/?"+job.Query, nil)
+	if err != nil {
+		fail(err.Error(), false)
+		return
+	}
+
+	monitor := &jobMonitor{ctx: ctx}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// operations may read the file more than once, the progress only reaches 1 once the job is done
+				progress := min(float64(monitor.read.Load())/float64(max(info.Size(), 1)), 0.99)
+				if err := js.UpdateJobProgress(job.ID, progress); err != nil {
+					log.Printf("jobs: %v", err)
+				}
+			}
+		}
+	}()
+	rec := &jobRecorder{header: http.Header{}}
+	panicked := func() (panicked any) {
+		defer func() {
+			panicked = recover()
+		}()
+		jr.api.runQuery(rec, r, file, monitor)
+		return nil
+	}()
+	close(done)
+
+	switch {
+	case ctx.Err() != nil:
+		// cancelled, the status was already set
+	case panicked != nil:
+		log.Printf("jobs: job %d panicked: %v", job.ID, panicked)
+		fail(fmt.Sprintf("the operation failed unexpectedly: %v", panicked), true)
+	case rec.overflow:
+		fail(fmt.Sprintf("the result is larger than %d bytes", maxJobResultSize), false)
+	case rec.status >= http.StatusInternalServerError:
+		fail(strings.TrimSpace(rec.body.String()), true)
+	case rec.status >= http.StatusBadRequest:
+		fail(strings.TrimSpace(rec.body.String()), false)
+	default:
+		header, err := json.Marshal(rec.header)
+		if err != nil {
+			fail(err.Error(), false)
+			return
+		}
+		result := &container.JobResult{Status: rec.status, Header: header, Body: rec.body.Bytes()}
+		if err := js.FinishJob(job.ID, service.JobSucceeded, "", result); err != nil {
+			log.Printf("jobs: %v", err)
+		}
+	}
+}
+
+//HandleCreateFileJob
+/*
+Queues an operation on the file with the file_id `uint32` provided in the URI/L to run in the background,
+requested with the same query as on the file itself e.g. 'POST /files/9/jobs?operation=stats&columns=price'.
+See HandleGetFileById for the operations available, serving the file itself cannot be queued.
+
+Responds with '202 Accepted' and the queued `container.Job`, its status is polled with HandleGetJobById
+and its result read with HandleGetJobResult once it has succeeded.
+*/
+func (a *API) HandleCreateFileJob(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.fileFromPath(w, r)
+	if !ok {
+		return
+	}
+	operation := r.URL.Query().Get("operation")
+	if !a.fileQueries().HasQuery(operation) {
+		http.Error(w, "invalid operation", http.StatusBadRequest)
+		return
+	}
+	job := &container.Job{FileID: f.ID, Operation: operation, Query: r.URL.RawQuery}
+	if err := a.Services.JobService.CreateJob(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.Jobs.notify()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+strconv.Itoa(int(job.ID)))
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+//jobFromPath
+/*
+Looks up the `container.Job` referenced by the job_id in the URI/L,
+writes an error response and returns false if it cannot be found.
+*/
+func (a *API) jobFromPath(w http.ResponseWriter, r *http.Request) (*container.Job, bool) {
+	id, err := getStringId("job_id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	job, err := a.Services.JobService.GetJobById(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if job == nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+//HandleGetJobById
+/*
+Writes the `container.Job` with the job_id `uint32` provided in the URI/L as JSON, along with its status and progress.
+*/
+func (a *API) HandleGetJobById(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJson(w, job)
+}
+
+//HandleGetJobResult
+/*
+Writes the result of the job with the job_id `uint32` provided in the URI/L as the operation wrote it,
+or '409 Conflict' if the job has not succeeded (yet).
+*/
+func (a *API) HandleGetJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	if job.Status != service.JobSucceeded {
+		http.Error(w, fmt.Sprintf("job is %s", job.Status), http.StatusConflict)
+		return
+	}
+	result, err := a.Services.JobService.GetJobResult(job.ID)
+	if err != nil || result == nil {
+		http.Error(w, "unable to read job result", http.StatusInternalServerError)
+		return
+	}
+	var header http.Header
+	if err := json.Unmarshal(result.Header, &header); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(result.Status)
+	w.Write(result.Body)
+}
+
+//HandleCancelJob
+/*
+Cancels the job with the job_id `uint32` provided in the URI/L, stopping it if it is running,
+and writes the cancelled `container.Job`, or '409 Conflict' if it has already finished.
+*/
+func (a *API) HandleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := a.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	cancelled, err := a.Services.JobService.CancelJob(job.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, fmt.Sprintf("job is %s", job.Status), http.StatusConflict)
+		return
+	}
+	a.Jobs.cancel(job.ID)
+	if job, ok = a.jobFromPath(w, r); ok {
+		writeJson(w, job)
+	}
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"api-3390/container"
+	"api-3390/container/predicate"
+	"api-3390/csvjoin"
+	"api-3390/csvutil"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// joinOperation is the operation recorded in the lineage of joined files.
+const joinOperation = "join"
+
+//JoinRequest
+/*
+A join of the files `Left` and `Right` of a user on the key columns `On`, saved as the file `Name`.
+`RightOn` names the key columns of the right file when they are named differently, in the same order as `On`.
+`How` is 'inner' (the default), 'left' or 'full' and `Suffix` renames columns of the right file
+named like a column of the left file, see csvjoin.Options.
+*/
+type JoinRequest struct {
+	Left    string   `json:"left"`
+	Right   string   `json:"right"`
+	On      []string `json:"on"`
+	RightOn []string `json:"right_on"`
+	How     string   `json:"how"`
+	Suffix  string   `json:"suffix"`
+	Name    string   `json:"name"`
+}
+
+//joinParameters
+/*
+The parameters of a join recorded in the lineage of the joined file, `Side` is the side the source was joined on.
+*/
+type joinParameters struct {
+	Side   string   `json:"side"`
+	How    string   `json:"how"`
+	Keys   []string `json:"keys"`
+	Suffix string   `json:"suffix"`
+}
+
+//HandleJoinUserFiles
+/*
+Joins two files the user `container.User` has, based off the user_id `uint32` provided in the URI/L,
+and saves the result as a new file of the user, replacing any file of the same name other than the joined files.
+
+The method expects a JSON `JoinRequest` e.g.
+'{"left": "prices.csv", "right": "codes.csv", "on": ["code"], "how": "left", "name": "priced.csv"}',
+and writes the saved `container.File` along with the columns and row counts of the join.
+Both files are recorded in the lineage of the new file, see HandleGetFileLineage.
+Right files too large to hold in memory are spilled to disk in partitions, see csvjoin.Join.
+*/
+func (a *API) HandleJoinUserFiles(w http.ResponseWriter, r *http.Request) {
+	userid, err := getStringId("user_id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var req JoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Left == "" || req.Right == "" {
+		http.Error(w, "left and right must be provided", http.StatusBadRequest)
+		return
+	}
+	if len(req.On) == 0 {
+		http.Error(w, "on must be provided", http.StatusBadRequest)
+		return
+	}
+	if len(req.RightOn) == 0 {
+		req.RightOn = req.On
+	}
+	if req.How == "" {
+		req.How = csvjoin.Inner
+	}
+	if req.Suffix == "" {
+		req.Suffix = csvjoin.DefaultSuffix
+	}
+	if !predicate.AllowedCharacters.Test(req.Name) {
+		http.Error(w, predicate.AllowedCharacters.ErrorMessage(req.Name), http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(filepath.Ext(req.Name), ".csv") {
+		http.Error(w, "name must have a '.csv' extension", http.StatusBadRequest)
+		return
+	}
+	if req.Name == req.Left || req.Name == req.Right {
+		http.Error(w, "a joined file cannot replace the files it is joined from", http.StatusBadRequest)
+		return
+	}
+
+	files := make([]*container.File, 2)
+	sources := make([]csvjoin.Source, 2)
+	for i, name := range []string{req.Left, req.Right} {
+		file, err := a.Services.FileService.GetUserFileByName(userid, name)
+		if file == nil || err != nil {
+			http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		files[i] = file
+		sources[i] = csvjoin.Source{Path: userFilePath(file.UserID, file.Name)}
+		if _, err := os.Stat(sources[i].Path); os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		for _, d := range file.Delimiter {
+			sources[i].Delimiter = d
+			break
+		}
+	}
+	sources[0].Keys, sources[1].Keys = req.On, req.RightOn
+
+	startTime := time.Now()
+	dst := userFilePath(userid, req.Name)
+	temp, err := os.CreateTemp(filepath.Dir(dst), ".join-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s := &stagedFile{temp: temp.Name(), dst: dst}
+	defer func() {
+		if !s.stored {
+			os.Remove(s.temp)
+		}
+	}()
+	// temporary files are private by default, match the permissions of files created with os.Create
+	temp.Chmod(0644)
+	opts := csvjoin.Options{
+		How:       req.How,
+		Suffix:    req.Suffix,
+		TempDir:   filepath.Dir(dst),
+		Delimiter: sources[0].Delimiter,
+	}
+	result, err := csvjoin.Join(sources[0], sources[1], opts, temp)
+	if err == nil {
+		_, err = temp.Seek(0, io.SeekStart)
+	}
+	var meta *csvutil.Metadata
+	if err == nil {
+		meta, err = csvutil.Inspect(temp)
+	}
+	temp.Close()
+	if err != nil {
+		var joinError *csvjoin.Error
+		if errors.As(err, &joinError) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.file = &container.File{UserID: userid, Name: req.Name}
+	setFileMetadata(s.file, meta)
+	lineage := make([]*container.Lineage, 2)
+	for i, side := range []string{"left", "right"} {
+		parameters, err := json.Marshal(joinParameters{Side: side, How: req.How, Keys: sources[i].Keys, Suffix: req.Suffix})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lineage[i] = &container.Lineage{
+			SourceID:     &files[i].ID,
+			SourceName:   files[i].Name,
+			SourceSHA256: files[i].SHA256,
+			Operation:    joinOperation,
+			Parameters:   parameters,
+		}
+	}
+	replaced, _ := a.Services.FileService.GetUserFileByName(userid, req.Name)
+	store, undo := stagedMoves([]*stagedFile{s})
+	if err := a.Services.FileService.SaveDerivedFileEntry(s.file, lineage, store, undo); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	removeBackups([]*stagedFile{s})
+	a.invalidateResults(replaced)
+	file := s.file
+	if f, err := a.Services.FileService.GetFileById(s.file.ID); err == nil && f != nil {
+		file = f
+	}
+	writeJson(w, map[string]interface{}{
+		"file": file,
+		"join": result,
+		"time": time.Since(startTime).Milliseconds(),
+	})
+}
+
+//HandleGetFileLineage
+/*
+Writes the files the file with the file_id `uint32` provided in the URI/L was derived from as JSON `container.Lineage`,
+an empty list for files that were uploaded.
+*/
+func (a *API) HandleGetFileLineage(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.fileFromPath(w, r)
+	if !ok {
+		return
+	}
+	lineage, err := a.Services.FileService.GetFileLineage(f.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lineage == nil {
+		lineage = []*container.Lineage{}
+	}
+	writeJson(w, lineage)
+}
@@ -1,13 +1,23 @@
 package handler
 
-import "net/http"
+import (
+	"api-3390/container"
+	"api-3390/handler/stats"
+	"net/http"
+)
 
 type QueryParams struct {
-	Operation string   `json:"operation"`
-	Column    []string `json:"columns"`
+	Operation string          `json:"operation"`
+	Column    []string        `json:"columns"`
+	Where     string          `json:"where"`
+	File      *container.File `json:"-"`
+	// Monitor observes the reading of the file by the operation, see stats.Monitor
+	Monitor stats.Monitor `json:"-"`
+	// Related are the other files the operation reads, their content is part of the key of its cached result
+	Related []*container.File `json:"-"`
 }
 
-type QueryHandler func(w http.ResponseWriter, column []string, filePath string)
+type QueryHandler func(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string)
 
 type QueryBuilder struct {
 	queries     map[string]QueryHandler
@@ -25,16 +35,23 @@ func (qb *QueryBuilder) AddQuery(operation string, handler QueryHandler) *QueryB
 	return qb
 }
 
-func (qb *QueryBuilder) Build(w http.ResponseWriter, params QueryParams, filePath string) {
+func (qb *QueryBuilder) Build(w http.ResponseWriter, r *http.Request, params QueryParams, filePath string) {
 	if handler, exists := qb.queries[params.Operation]; exists {
-		handler(w, params.Column, filePath)
+		handler(w, r, params, filePath)
 	} else if qb.defaultCase != nil {
-		qb.defaultCase(w, make([]string, 0), filePath)
+		params.Column = make([]string, 0)
+		qb.defaultCase(w, r, params, filePath)
 	} else {
 		http.Error(w, "invalid operation", http.StatusBadRequest)
 	}
 }
 
+// HasQuery reports whether `operation` is one of the operations added to the builder.
+func (qb *QueryBuilder) HasQuery(operation string) bool {
+	_, exists := qb.queries[operation]
+	return exists
+}
+
 func (qb *QueryBuilder) SetDefaultCase(handler QueryHandler) *QueryBuilder {
 	qb.defaultCase = handler
 	return qb
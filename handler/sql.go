@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"api-3390/sqlcsv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+//SQLRequest
+/*
+A read-only SQL query over the files of a user named in `Files`, each read as a table named after the file,
+see sqlcsv.TableName. `MaxRows` and `TimeoutMs` tighten the limits of the query, see sqlcsv.Options.
+*/
+type SQLRequest struct {
+	Query     string   `json:"query"`
+	Files     []string `json:"files"`
+	MaxRows   int      `json:"max_rows"`
+	TimeoutMs int      `json:"timeout_ms"`
+}
+
+//HandleQueryUserFiles
+/*
+Runs a read-only SQL query over files the user `container.User` has, based off the user_id `uint32` provided in the URI/L.
+
+The method expects a JSON `SQLRequest` e.g.
+'{"query": "SELECT payer, avg(price) FROM prices GROUP BY payer", "files": ["prices.csv"], "max_rows": 100}',
+and writes the columns and rows returned by the query along with the tables it could read and their inferred types.
+The query runs in a sandbox without access to the database of the application, see sqlcsv.Query.
+*/
+func (a *API) HandleQueryUserFiles(w http.ResponseWriter, r *http.Request) {
+	userid, err := getStringId("user_id", r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var req SQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 {
+		http.Error(w, "files must be provided", http.StatusBadRequest)
+		return
+	}
+	sources := make([]sqlcsv.Source, len(req.Files))
+	for i, name := range req.Files {
+		file, err := a.Services.FileService.GetUserFileByName(userid, name)
+		if file == nil || err != nil {
+			http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		sources[i] = sqlcsv.Source{
+			Table:   sqlcsv.TableName(file.Name),
+			Path:    userFilePath(file.UserID, file.Name),
+			Version: file.SHA256,
+		}
+		if _, err := os.Stat(sources[i].Path); os.IsNotExist(err) {
+			http.Error(w, fmt.Sprintf("file '%s' not found", name), http.StatusNotFound)
+			return
+		}
+		for _, d := range file.Delimiter {
+			sources[i].Delimiter = d
+			break
+		}
+	}
+
+	startTime := time.Now()
+	opts := sqlcsv.Options{MaxRows: req.MaxRows, Timeout: time.Duration(req.TimeoutMs) * time.Millisecond}
+	result, err := sqlcsv.Query(r.Context(), sources, req.Query, opts)
+	if err != nil {
+		var statementError *sqlcsv.StatementError
+		if errors.As(err, &statementError) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, map[string]interface{}{
+		"result": result,
+		"time":   time.Since(startTime).Milliseconds(),
+	})
+}
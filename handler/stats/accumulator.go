@@ -0,0 +1,329 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// maxBufferedValues bounds the number of values of a column held in memory to find its median,
+// columns with more values are resolved with additional passes over the file, see resolveRanks.
+// It is a variable so that tests can lower it.
+var maxBufferedValues = 1 << 20
+
+//moments
+/*
+Running count, mean, sum, minimum, maximum and sums of the 2nd to 4th powers of differences from the mean
+of a stream of values, updated with Welford's algorithm (extended to higher moments by Terriberry)
+so the values never have to be stored.
+*/
+type moments struct {
+	n    int64
+	mean float64
+	m2   float64
+	m3   float64
+	m4   float64
+	sum  float64
+	min  float64
+	max  float64
+}
+
+func (m *moments) add(v float64) {
+	if m.n == 0 {
+		m.min, m.max = v, v
+	} else {
+		m.min = math.Min(m.min, v)
+		m.max = math.Max(m.max, v)
+	}
+	n1 := float64(m.n)
+	m.n++
+	n := float64(m.n)
+	delta := v - m.mean
+	deltaN := delta / n
+	deltaN2 := deltaN * deltaN
+	term := delta * deltaN * n1
+	m.mean += deltaN
+	m.m4 += term*deltaN2*(n*n-3*n+3) + 6*deltaN2*m.m2 - 4*deltaN*m.m3
+	m.m3 += term*deltaN*(n-2) - 3*deltaN*m.m2
+	m.m2 += term
+	m.sum += v
+}
+
+//merge
+/*
+Combines the moments of another stream of values into `m` using the parallel update of Chan et al.
+extended to higher moments by Pébay.
+*/
+func (m *moments) merge(o *moments) {
+	if o.n == 0 {
+		return
+	}
+	if m.n == 0 {
+		*m = *o
+		return
+	}
+	na, nb := float64(m.n), float64(o.n)
+	n := na + nb
+	delta := o.mean - m.mean
+	delta2 := delta * delta
+	m2 := m.m2 + o.m2 + delta2*na*nb/n
+	m3 := m.m3 + o.m3 + delta*delta2*na*nb*(na-nb)/(n*n) +
+		3*delta*(na*o.m2-nb*m.m2)/n
+	m4 := m.m4 + o.m4 + delta2*delta2*na*nb*(na*na-na*nb+nb*nb)/(n*n*n) +
+		6*delta2*(na*na*o.m2+nb*nb*m.m2)/(n*n) + 4*delta*(na*o.m3-nb*m.m3)/n
+	m.mean += delta * nb / n
+	m.m2, m.m3, m.m4 = m2, m3, m4
+	m.sum += o.sum
+	m.min = math.Min(m.min, o.min)
+	m.max = math.Max(m.max, o.max)
+	m.n += o.n
+}
+
+func (m *moments) populationVariance() float64 {
+	if m.n == 0 {
+		return 0
+	}
+	return m.m2 / float64(m.n)
+}
+
+func (m *moments) sampleVariance() float64 {
+	return m.m2 / float64(m.n-1)
+}
+
+//skewness
+/*
+Returns the adjusted Fisher-Pearson coefficient of skewness, defined for 3 or more values.
+*/
+func (m *moments) skewness() float64 {
+	n := float64(m.n)
+	g1 := math.Sqrt(n) * m.m3 / math.Pow(m.m2, 1.5)
+	return g1 * math.Sqrt(n*(n-1)) / (n - 2)
+}
+
+//kurtosis
+/*
+Returns the bias corrected excess kurtosis, defined for 4 or more values.
+*/
+func (m *moments) kurtosis() float64 {
+	n := float64(m.n)
+	g2 := n*m.m4/(m.m2*m.m2) - 3
+	return ((n+1)*g2 + 6) * (n - 1) / ((n - 2) * (n - 3))
+}
+
+//accumulator
+/*
+Collects the moments of a column and keeps its values while there are at most `limit` of them,
+so small columns have their quantiles computed without reading the file again.
+`rows` counts every record seen, including those where the column is missing or not numeric.
+`frequencies` is only set when the mode of the column is wanted and `digest` only when quantiles are approximated.
+*/
+type accumulator struct {
+	moments
+	rows        int64
+	values      []float64
+	limit       int
+	overflow    bool
+	frequencies *frequencies[float64]
+	digest      *tdigest
+}
+
+func newAccumulator(limit int) *accumulator {
+	return &accumulator{limit: limit}
+}
+
+func (a *accumulator) add(v float64) {
+	a.moments.add(v)
+	if a.frequencies != nil {
+		a.frequencies.add(v)
+	}
+	if a.digest != nil {
+		a.digest.add(v)
+	}
+	if a.overflow {
+		return
+	}
+	if len(a.values) >= a.limit {
+		a.overflow = true
+		a.values = nil
+		return
+	}
+	a.values = append(a.values, v)
+}
+
+func (a *accumulator) merge(o *accumulator) {
+	a.moments.merge(&o.moments)
+	a.rows += o.rows
+	if a.frequencies != nil && o.frequencies != nil {
+		a.frequencies.merge(o.frequencies)
+	}
+	if a.digest != nil && o.digest != nil {
+		a.digest.merge(o.digest)
+	}
+	if a.overflow || o.overflow || len(a.values)+len(o.values) > a.limit {
+		a.overflow = true
+		a.values = nil
+		return
+	}
+	a.values = append(a.values, o.values...)
+}
+
+//rankQuery
+/*
+Looks for the value at the 0-based position `rank` of the sorted values of a column.
+
+The values are narrowed down to a window [lo, hi] holding the wanted value, `below` values are smaller than `lo`
+and `count` values lie within the window. Each pass over the file either collects the values within the window,
+once there are few enough of them, or counts them into buckets to find a narrower window.
+*/
+type rankQuery struct {
+	column   int
+	rank     int64
+	lo, hi   float64
+	below    int64
+	count    int64
+	resolved bool
+	value    float64
+
+	collected []float64
+	counts    []int64
+	mins      []float64
+	maxs      []float64
+}
+
+// selectionBuckets is the number of buckets a window is split into on each pass.
+const selectionBuckets = 4096
+
+func (q *rankQuery) collecting() bool {
+	return q.count <= int64(maxBufferedValues)
+}
+
+func (q *rankQuery) startPass() {
+	if q.collecting() {
+		q.collected = make([]float64, 0, q.count)
+		return
+	}
+	q.counts = make([]int64, selectionBuckets)
+	q.mins = make([]float64, selectionBuckets)
+	q.maxs = make([]float64, selectionBuckets)
+}
+
+func (q *rankQuery) add(v float64) {
+	if v < q.lo || v > q.hi {
+		return
+	}
+	if q.collected != nil {
+		q.collected = append(q.collected, v)
+		return
+	}
+	b := int((v - q.lo) / (q.hi - q.lo) * selectionBuckets)
+	if b >= selectionBuckets {
+		b = selectionBuckets - 1
+	}
+	if q.counts[b] == 0 || v < q.mins[b] {
+		q.mins[b] = v
+	}
+	if q.counts[b] == 0 || v > q.maxs[b] {
+		q.maxs[b] = v
+	}
+	q.counts[b]++
+}
+
+func (q *rankQuery) endPass() {
+	if q.collected != nil {
+		sort.Float64s(q.collected)
+		q.value = q.collected[q.rank-q.below]
+		q.resolved = true
+		q.collected = nil
+		return
+	}
+	cumulative := q.below
+	for b, count := range q.counts {
+		if cumulative+count > q.rank {
+			q.lo, q.hi = q.mins[b], q.maxs[b]
+			q.below, q.count = cumulative, count
+			break
+		}
+		cumulative += count
+	}
+	q.counts, q.mins, q.maxs = nil, nil, nil
+	if q.lo == q.hi {
+		q.value = q.lo
+		q.resolved = true
+	}
+}
+
+//resolveRanks
+/*
+Finds the value of every query in `queries` by calling `scan` once per pass until every query is resolved,
+`scan` must call its argument with every value of the columns the queries refer to.
+Only a bounded number of values per query is held in memory at once.
+*/
+func resolveRanks(queries []*rankQuery, scan func(yield func(column int, v float64)) error) error {
+	for {
+		var pending []*rankQuery
+		byColumn := make(map[int][]*rankQuery)
+		for _, q := range queries {
+			if !q.resolved {
+				q.startPass()
+				pending = append(pending, q)
+				byColumn[q.column] = append(byColumn[q.column], q)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		err := scan(func(column int, v float64) {
+			for _, q := range byColumn[column] {
+				q.add(v)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for _, q := range pending {
+			q.endPass()
+		}
+	}
+}
+
+//rankQueries
+/*
+Returns queries for the values at the 0-based `ranks` of the sorted values of the column at position `column`.
+The queries are already resolved when the accumulator kept every value, otherwise they start from the whole
+range of the column and are resolved with resolveRanks.
+*/
+func (a *accumulator) rankQueries(column int, ranks []int64) []*rankQuery {
+	queries := make([]*rankQuery, 0, len(ranks))
+	var sorted []float64
+	if !a.overflow {
+		sorted = a.values
+		sort.Float64s(sorted)
+	}
+	for _, rank := range ranks {
+		q := &rankQuery{column: column, rank: rank, lo: a.min, hi: a.max, count: a.n}
+		if sorted != nil {
+			q.value = sorted[rank]
+			q.resolved = true
+		} else if q.lo == q.hi {
+			q.value = q.lo
+			q.resolved = true
+		}
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+func isNaNOrInf(v float64) bool {
+	return math.IsNaN(v) || math.IsInf(v, 0)
+}
+
+//quantileRanks
+/*
+Returns the ranks whose values are interpolated to give the quantile `p` in [0, 1] of `n` values,
+along with the weight of the upper rank, following the linear interpolation used by R's type 7 and numpy.
+*/
+func quantileRanks(n int64, p float64) (int64, int64, float64) {
+	h := float64(n-1) * p
+	lower := math.Floor(h)
+	upper := math.Ceil(h)
+	return int64(lower), int64(upper), h - lower
+}
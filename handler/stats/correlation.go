@@ -0,0 +1,271 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Correlation coefficients that can be requested through CorrelationOptions.Methods.
+const (
+	CorrelationPearson  = "pearson"
+	CorrelationSpearman = "spearman"
+)
+
+// Ways of handling missing values through CorrelationOptions.Missing.
+const (
+	MissingPairwise = "pairwise"
+	MissingListwise = "listwise"
+)
+
+// maxRankedValues bounds the number of values held in memory to rank the columns for the Spearman correlation.
+// It is a variable so that tests can lower it.
+var maxRankedValues = 4 * maxBufferedValues
+
+//CorrelationOptions
+/*
+The coefficients to calculate, Pearson when none are requested, and how rows with missing values are handled:
+'pairwise' (the default) uses every row where both columns of a pair are numbers,
+'listwise' only uses the rows where every column is a number.
+*/
+type CorrelationOptions struct {
+	Methods []string
+	Missing string
+}
+
+//Correlation
+/*
+The correlation and covariance matrices of a set of columns, the entries of a matrix are in the order of Columns.
+N holds the number of rows each entry was calculated from, on the diagonal the number of values of the column.
+Covariance is the sample covariance, on the diagonal the sample variance of the column.
+Entries are null when they are undefined, e.g. for fewer than 2 rows or a column whose values are all the same.
+*/
+type Correlation struct {
+	Columns    []string     `json:"columns"`
+	Missing    string       `json:"missing"`
+	Pearson    [][]*float64 `json:"pearson,omitempty"`
+	Spearman   [][]*float64 `json:"spearman,omitempty"`
+	Covariance [][]*float64 `json:"covariance"`
+	N          [][]int64    `json:"n"`
+}
+
+//comoments
+/*
+Running means, sums of squared differences from the means and sum of the products of the differences
+of a stream of pairs of values, updated with Welford's algorithm.
+*/
+type comoments struct {
+	n            int64
+	meanX, meanY float64
+	m2x, m2y     float64
+	cxy          float64
+}
+
+func (c *comoments) add(x, y float64) {
+	c.n++
+	n := float64(c.n)
+	dx := x - c.meanX
+	c.meanX += dx / n
+	dy := y - c.meanY
+	c.meanY += dy / n
+	c.m2x += dx * (x - c.meanX)
+	c.m2y += dy * (y - c.meanY)
+	c.cxy += dx * (y - c.meanY)
+}
+
+func (c *comoments) covariance() *float64 {
+	if c.n < 2 {
+		return nil
+	}
+	return float(c.cxy / float64(c.n-1))
+}
+
+func (c *comoments) correlation() *float64 {
+	if c.n < 2 || c.m2x == 0 || c.m2y == 0 {
+		return nil
+	}
+	r := c.cxy / math.Sqrt(c.m2x*c.m2y)
+	// rounding may carry the coefficient just beyond [-1, 1]
+	return float(math.Max(-1, math.Min(1, r)))
+}
+
+//CalculateCorrelation
+/*
+Calculates the correlation and covariance matrices of the columns in `columnNames` of the CSV file at `filePath`,
+fields that are not numbers are treated as missing. A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The Pearson correlation and the covariances are computed online in a single pass.
+The Spearman correlation is the Pearson correlation of the ranks of the values, ties sharing their average rank,
+so the values of the columns are held in memory to rank them, up to <maxRankedValues> values.
+With pairwise deletion the values of each pair are ranked among the rows where both are present.
+*/
+func CalculateCorrelation(columnNames []string, filePath string, opts Options, corr CorrelationOptions) (*Correlation, *time.Time, error) {
+	startTime := time.Now()
+
+	pearson, spearman := len(corr.Methods) == 0, false
+	for _, m := range corr.Methods {
+		switch strings.ToLower(m) {
+		case CorrelationPearson:
+			pearson = true
+		case CorrelationSpearman:
+			spearman = true
+		default:
+			return nil, nil, &ParameterError{Message: fmt.Sprintf("unknown method '%s', expected one of: %s, %s",
+				m, CorrelationPearson, CorrelationSpearman)}
+		}
+	}
+	missing := strings.ToLower(corr.Missing)
+	if missing == "" {
+		missing = MissingPairwise
+	}
+	if missing != MissingPairwise && missing != MissingListwise {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("missing must be '%s' or '%s'", MissingPairwise, MissingListwise)}
+	}
+	if len(columnNames) < 2 {
+		return nil, nil, &ParameterError{Message: "at least two columns must be provided"}
+	}
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	k := len(columnIndexes)
+	pairs := make([][]comoments, k)
+	for i := range pairs {
+		pairs[i] = make([]comoments, k)
+	}
+	// the values of every row kept for ranking, NaN where a value is missing
+	var rows []float64
+	values := make([]float64, k)
+	err = readFields(rr, columnIndexes, func(column int, field string) error {
+		v, ok := parseValue(field)
+		if !ok {
+			v = math.NaN()
+		}
+		values[column] = v
+		if column < k-1 {
+			return nil
+		}
+		// every field of the record has been read
+		complete := true
+		for _, v := range values {
+			complete = complete && !math.IsNaN(v)
+		}
+		if missing == MissingListwise && !complete {
+			return nil
+		}
+		for i, x := range values {
+			if math.IsNaN(x) {
+				continue
+			}
+			for j := i; j < k; j++ {
+				if y := values[j]; !math.IsNaN(y) {
+					pairs[i][j].add(x, y)
+				}
+			}
+		}
+		if spearman {
+			if len(rows)+k > maxRankedValues {
+				return &ParameterError{Message: fmt.Sprintf("too many values to rank for the Spearman correlation, at most %d are supported",
+					maxRankedValues)}
+			}
+			rows = append(rows, values...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &Correlation{Missing: missing, Covariance: matrix(k), N: make([][]int64, k)}
+	for _, index := range columnIndexes {
+		result.Columns = append(result.Columns, rr.Header()[index])
+	}
+	if pearson {
+		result.Pearson = matrix(k)
+	}
+	if spearman {
+		result.Spearman = matrix(k)
+	}
+	for i := range result.N {
+		result.N[i] = make([]int64, k)
+	}
+	for i := 0; i < k; i++ {
+		for j := i; j < k; j++ {
+			c := &pairs[i][j]
+			result.N[i][j], result.N[j][i] = c.n, c.n
+			result.Covariance[i][j], result.Covariance[j][i] = c.covariance(), c.covariance()
+			if pearson {
+				result.Pearson[i][j], result.Pearson[j][i] = c.correlation(), c.correlation()
+			}
+			if spearman {
+				r := rankCorrelation(rows, k, i, j)
+				result.Spearman[i][j], result.Spearman[j][i] = r, r
+			}
+		}
+	}
+	return result, &startTime, nil
+}
+
+func matrix(k int) [][]*float64 {
+	m := make([][]*float64, k)
+	for i := range m {
+		m[i] = make([]*float64, k)
+	}
+	return m
+}
+
+//rankCorrelation
+/*
+Returns the Spearman correlation of the columns at `i` and `j` of `rows`, which holds `k` values per row,
+using the rows where both values are present.
+*/
+func rankCorrelation(rows []float64, k, i, j int) *float64 {
+	var xs, ys []float64
+	for r := 0; r+k <= len(rows); r += k {
+		x, y := rows[r+i], rows[r+j]
+		if !math.IsNaN(x) && !math.IsNaN(y) {
+			xs, ys = append(xs, x), append(ys, y)
+		}
+	}
+	rx, ry := ranks(xs), ranks(ys)
+	var c comoments
+	for n := range rx {
+		c.add(rx[n], ry[n])
+	}
+	return c.correlation()
+}
+
+//ranks
+/*
+Returns the 1-based rank of each value of `values`, tied values sharing the average of their ranks.
+*/
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+	ranked := make([]float64, len(values))
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && values[order[end]] == values[order[start]] {
+			end++
+		}
+		// positions start to end-1 hold ranks start+1 to end
+		rank := float64(start+1+end) / 2
+		for _, i := range order[start:end] {
+			ranked[i] = rank
+		}
+		start = end
+	}
+	return ranked
+}
@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// correlationFile holds three columns with a missing value each in different rows, and ties in 'y' and 'z'.
+func correlationFile(t *testing.T) string {
+	t.Helper()
+	return writeCSV(t, t.TempDir(), "correlation.csv",
+		"x,y,z,c",
+		"1,2,3,1",
+		"2,4,1,1",
+		"3,4,2,1",
+		"4,5,2,1",
+		"5,n/a,5,1",
+		"6,9,,1",
+		",7,6,1",
+		"8,10,4,1")
+}
+
+// checkMatrix compares the upper triangle of `got` to `want`, the lower triangle to its mirror.
+func checkMatrix(t *testing.T, name string, got [][]*float64, want [3][3]float64) {
+	t.Helper()
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			w := want[min(i, j)][max(i, j)]
+			if !closeEnough(valueOf(got[i][j]), w) {
+				t.Errorf("%s[%d][%d] = %v, want %v", name, i, j, valueOf(got[i][j]), w)
+			}
+		}
+	}
+}
+
+// valueOf returns the value of `v`, or nil when the entry is null.
+func valueOf(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// The reference values were computed from the textbook definitions: the sample covariance, the Pearson correlation
+// and the Pearson correlation of the ranks for the Spearman correlation, ties sharing their average rank.
+func TestCorrelationReference(t *testing.T) {
+	path := correlationFile(t)
+	tests := []struct {
+		missing    string
+		n          [3][3]int64
+		covariance [3][3]float64
+		pearson    [3][3]float64
+		spearman   [3][3]float64
+	}{
+		{
+			missing: MissingPairwise,
+			n:       [3][3]int64{{7, 6, 6}, {6, 7, 6}, {6, 6, 7}},
+			covariance: [3][3]float64{
+				{5.809523809523809, 8.0, 2.1666666666666665},
+				{0, 8.476190476190476, 2.8},
+				{0, 0, 3.2380952380952386}},
+			pearson: [3][3]float64{
+				{1, 0.976675520089518, 0.5927489783638191},
+				{0, 1, 0.5580687036253706},
+				{0, 0, 1}},
+			spearman: [3][3]float64{
+				{1, 0.9856107606091623, 0.5797710356524485},
+				{0, 1, 0.5441176470588235},
+				{0, 0, 1}},
+		},
+		{
+			missing: MissingListwise,
+			n:       [3][3]int64{{5, 5, 5}, {5, 5, 5}, {5, 5, 5}},
+			covariance: [3][3]float64{
+				{7.3, 8.0, 1.95},
+				{0, 9.0, 2.0},
+				{0, 0, 1.3}},
+			pearson: [3][3]float64{
+				{1, 0.986977613596807, 0.6329967863321656},
+				{0, 1, 0.5847053462046862},
+				{0, 0, 1}},
+			spearman: [3][3]float64{
+				{1, 0.9746794344808964, 0.35909242322980395},
+				{0, 1, 0.2894736842105263},
+				{0, 0, 1}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.missing, func(t *testing.T) {
+			c, _, err := CalculateCorrelation([]string{"x", "Y", "z"}, path, Options{},
+				CorrelationOptions{Methods: []string{"pearson", "Spearman"}, Missing: tc.missing})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(c.Columns) != "[x y z]" || c.Missing != tc.missing {
+				t.Errorf("columns %v with %s deletion", c.Columns, c.Missing)
+			}
+			if fmt.Sprint(c.N) != fmt.Sprint(tc.n[:]) {
+				t.Errorf("n %v, want %v", c.N, tc.n)
+			}
+			checkMatrix(t, "covariance", c.Covariance, tc.covariance)
+			checkMatrix(t, "pearson", c.Pearson, tc.pearson)
+			checkMatrix(t, "spearman", c.Spearman, tc.spearman)
+		})
+	}
+}
+
+func TestCorrelationUndefined(t *testing.T) {
+	path := correlationFile(t)
+	// Pearson only by default, the constant column has no correlation but a covariance of 0
+	c, _, err := CalculateCorrelation([]string{"x", "c"}, path, Options{}, CorrelationOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Spearman != nil || c.Pearson[0][1] != nil || c.Pearson[1][1] != nil || valueOf(c.Covariance[0][1]) != 0.0 {
+		t.Errorf("pearson %v, spearman %v and covariance %v", c.Pearson, c.Spearman, c.Covariance)
+	}
+	// a single row leaves every entry undefined
+	single := writeCSV(t, t.TempDir(), "single.csv", "x,y", "1,2")
+	c, _, err = CalculateCorrelation([]string{"x", "y"}, single, Options{}, CorrelationOptions{Methods: []string{"spearman"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range c.N {
+		for j := range c.N {
+			if c.N[i][j] != 1 || c.Covariance[i][j] != nil || c.Spearman[i][j] != nil {
+				t.Errorf("[%d][%d]: n %d, covariance %v and spearman %v", i, j, c.N[i][j], valueOf(c.Covariance[i][j]), valueOf(c.Spearman[i][j]))
+			}
+		}
+	}
+}
+
+func TestCorrelationOptions(t *testing.T) {
+	path := correlationFile(t)
+	for _, tc := range []struct {
+		columns []string
+		corr    CorrelationOptions
+	}{
+		{[]string{"x", "y"}, CorrelationOptions{Methods: []string{"kendall"}}},
+		{[]string{"x", "y"}, CorrelationOptions{Missing: "drop"}},
+		{[]string{"x"}, CorrelationOptions{}},
+	} {
+		var paramErr *ParameterError
+		if _, _, err := CalculateCorrelation(tc.columns, path, Options{}, tc.corr); !errors.As(err, &paramErr) {
+			t.Errorf("%v %+v: error %v, want a ParameterError", tc.columns, tc.corr, err)
+		}
+	}
+
+	defer func(limit int) { maxRankedValues = limit }(maxRankedValues)
+	maxRankedValues = 20
+	var paramErr *ParameterError
+	if _, _, err := CalculateCorrelation([]string{"x", "y", "z"}, path, Options{}, CorrelationOptions{Methods: []string{"spearman"}}); !errors.As(err, &paramErr) {
+		t.Errorf("ranking 24 values: error %v, want a ParameterError", err)
+	}
+	if _, _, err := CalculateCorrelation([]string{"x", "y", "z"}, path, Options{}, CorrelationOptions{}); err != nil {
+		t.Errorf("the Pearson correlation alone is not limited, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package stats
+
+import "math"
+
+//regularizedBeta
+/*
+Returns the regularized incomplete beta function I_x(a, b), evaluated with the continued fraction of Lentz's method
+on whichever side of the mean of the distribution it converges quickly.
+*/
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lbeta, _ := math.Lgamma(a + b)
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	front := math.Exp(lbeta - la - lb + a*math.Log(x) + b*math.Log1p(-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(x, a, b) / a
+	}
+	return 1 - front*betaFraction(1-x, b, a)/b
+}
+
+func betaFraction(x, a, b float64) float64 {
+	const epsilon = 1e-15
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 1000; m++ {
+		fm := float64(m)
+		for _, numerator := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + numerator*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + numerator/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
+
+//studentTTwoTailed
+/*
+Returns the probability of a t statistic at least as extreme as `t` under Student's t distribution with `df` degrees of freedom.
+*/
+func studentTTwoTailed(t, df float64) float64 {
+	return regularizedBeta(df/(df+t*t), df/2, 0.5)
+}
+
+//fUpperTail
+/*
+Returns the probability of an F statistic of at least `f` under the F distribution with `d1` and `d2` degrees of freedom.
+*/
+func fUpperTail(f, d1, d2 float64) float64 {
+	if f <= 0 {
+		return 1
+	}
+	return regularizedBeta(d2/(d2+d1*f), d2/2, d1/2)
+}
+
+//normalTwoTailed
+/*
+Returns the probability of a z score at least as extreme as `z` under the standard normal distribution.
+*/
+func normalTwoTailed(z float64) float64 {
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+//chiSquareUpperTail
+/*
+Returns the probability of a statistic of at least `x` under the chi-square distribution with `df` degrees of freedom.
+*/
+func chiSquareUpperTail(x, df float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	return regularizedGammaQ(df/2, x/2)
+}
+
+//regularizedGammaQ
+/*
+Returns the regularized upper incomplete gamma function Q(a, x), evaluated with its series below a+1
+and with the continued fraction of Lentz's method above, where each converges quickly.
+*/
+func regularizedGammaQ(a, x float64) float64 {
+	const epsilon = 1e-15
+	const tiny = 1e-300
+	lgamma, _ := math.Lgamma(a)
+	front := math.Exp(-x + a*math.Log(x) - lgamma)
+	if x < a+1 {
+		term, sum := 1/a, 1/a
+		for n := 1.0; n <= 1000; n++ {
+			term *= x / (a + n)
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return math.Max(0, 1-front*sum)
+	}
+	b := x + 1 - a
+	c, d := 1/tiny, 1/b
+	h := d
+	for n := 1.0; n <= 1000; n++ {
+		numerator := -n * (n - a)
+		b += 2
+		d = numerator*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		if math.Abs(d*c-1) < epsilon {
+			break
+		}
+	}
+	return front * h
+}
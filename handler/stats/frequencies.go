@@ -0,0 +1,105 @@
+package stats
+
+import (
+	"cmp"
+	"sort"
+)
+
+// maxTrackedValues bounds the number of distinct values counted to find the mode of a column.
+const maxTrackedValues = 1 << 16
+
+//frequencies
+/*
+Counts how often each value of a column occurs to find its mode, or its most frequent values.
+
+The counts are exact while there are at most `limit` distinct values, beyond that the counts become
+Misra-Gries estimates: every value occurring more than n/(limit+1) times is kept as a candidate,
+and `approximate` is set so the candidates are counted exactly with another pass over the file.
+*/
+type frequencies[T cmp.Ordered] struct {
+	counts      map[T]int64
+	limit       int
+	approximate bool
+}
+
+func newFrequencies[T cmp.Ordered](limit int) *frequencies[T] {
+	return &frequencies[T]{counts: make(map[T]int64), limit: limit}
+}
+
+func (f *frequencies[T]) add(v T) {
+	if _, ok := f.counts[v]; ok || len(f.counts) < f.limit {
+		f.counts[v]++
+		return
+	}
+	f.approximate = true
+	for k, c := range f.counts {
+		if c == 1 {
+			delete(f.counts, k)
+		} else {
+			f.counts[k] = c - 1
+		}
+	}
+}
+
+//merge
+/*
+Adds the counts of `o` to `f`, keeping the `limit` largest counts reduced by the next largest if there are too many.
+*/
+func (f *frequencies[T]) merge(o *frequencies[T]) {
+	for k, c := range o.counts {
+		f.counts[k] += c
+	}
+	f.approximate = f.approximate || o.approximate
+	if len(f.counts) <= f.limit {
+		return
+	}
+	f.approximate = true
+	counts := make([]int64, 0, len(f.counts))
+	for _, c := range f.counts {
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i] > counts[j] })
+	cut := counts[f.limit]
+	for k, c := range f.counts {
+		if c <= cut {
+			delete(f.counts, k)
+		} else {
+			f.counts[k] = c - cut
+		}
+	}
+}
+
+//reset
+/*
+Sets the count of every candidate to zero so they can be counted exactly, see count.
+*/
+func (f *frequencies[T]) reset() {
+	for k := range f.counts {
+		f.counts[k] = 0
+	}
+}
+
+//count
+/*
+Counts `v` if it is a candidate, used when counting the candidates exactly.
+*/
+func (f *frequencies[T]) count(v T) {
+	if _, ok := f.counts[v]; ok {
+		f.counts[v]++
+	}
+}
+
+//mode
+/*
+Returns the most frequent value and how often it occurs, the smallest value is returned when several are tied.
+*/
+func (f *frequencies[T]) mode() (T, int64) {
+	var mode T
+	var best int64
+	for k, c := range f.counts {
+		if c > best || (c == best && k < mode) {
+			mode, best = k, c
+		}
+	}
+	return mode, best
+}
@@ -0,0 +1,374 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxGroups bounds the number of groups GroupBy holds in memory.
+const maxGroups = 1 << 20
+
+// Functions that can be used in the aggregates of GroupBy.
+const (
+	AggregateCount    = "count"
+	AggregateSum      = "sum"
+	AggregateMean     = "mean"
+	AggregateMin      = "min"
+	AggregateMax      = "max"
+	AggregateStdDev   = "stddev"
+	AggregateVariance = "variance"
+	AggregateMedian   = "median"
+)
+
+var aggregateFunctions = []string{AggregateCount, AggregateSum, AggregateMean, AggregateMin, AggregateMax,
+	AggregateStdDev, AggregateVariance, AggregateMedian}
+
+//GroupOptions
+/*
+The grouping of GroupBy: the columns to group by, the aggregates to calculate for each group,
+e.g. 'mean(price)' or 'count(*)', the columns to sort by and the maximum number of groups to return.
+
+A sort column is either one of `By` or an aggregate, prefixed with '-' to sort in descending order.
+Groups are sorted by their keys when `Sort` is empty, and every group is returned when `Limit` is zero.
+*/
+type GroupOptions struct {
+	By         []string
+	Aggregates []string
+	Sort       []string
+	Limit      int
+}
+
+//aggregate
+/*
+An aggregate function applied to the numeric values of a column, `column` is -1 for 'count(*)'.
+*/
+type aggregate struct {
+	function string
+	name     string
+	column   int
+}
+
+//parseAggregate
+/*
+Parses an aggregate such as 'mean(price)' against the header of `rr`, only 'count' may be applied to '*'.
+*/
+func parseAggregate(rr *RecordReader, s string) (*aggregate, error) {
+	open, end := strings.Index(s, "("), len(s)-1
+	if open <= 0 || end <= open || s[end] != ')' {
+		return nil, &ParameterError{Message: fmt.Sprintf("aggregate '%s' must be of the form function(column)", s)}
+	}
+	function := strings.ToLower(strings.TrimSpace(s[:open]))
+	argument := strings.TrimSpace(s[open+1 : end])
+	known := false
+	for _, f := range aggregateFunctions {
+		known = known || f == function
+	}
+	if !known {
+		return nil, &ParameterError{Message: fmt.Sprintf("unknown aggregate function '%s', expected one of: %s",
+			function, strings.Join(aggregateFunctions, ", "))}
+	}
+	if argument == "*" {
+		if function != AggregateCount {
+			return nil, &ParameterError{Message: fmt.Sprintf("'%s' cannot be applied to '*'", function)}
+		}
+		return &aggregate{function: function, name: "count(*)", column: -1}, nil
+	}
+	column := rr.ColumnIndex(argument)
+	if column == -1 {
+		return nil, &ColumnNotFoundError{Columns: []string{argument}}
+	}
+	return &aggregate{
+		function: function,
+		name:     fmt.Sprintf("%s(%s)", function, rr.Header()[column]),
+		column:   column,
+	}, nil
+}
+
+//group
+/*
+The rows sharing the same values of the grouping columns, `values` holds the moments of the column of each aggregate.
+The columns whose median is wanted keep their values in `exact` while the budget shared by every group allows it,
+and are summarised in `digests` to estimate the median once it does not.
+*/
+type group struct {
+	keys    []string
+	rows    int64
+	values  []moments
+	exact   [][]float64
+	digests []*tdigest
+}
+
+//value
+/*
+Returns the result of the aggregate at `i` for the group, or nil when it is undefined such as the mean of no values.
+*/
+func (g *group) value(i int, agg *aggregate) interface{} {
+	if agg.column == -1 {
+		return g.rows
+	}
+	m := &g.values[i]
+	if agg.function == AggregateCount {
+		return m.n
+	}
+	if m.n == 0 {
+		if agg.function == AggregateSum {
+			return 0.0
+		}
+		return nil
+	}
+	switch agg.function {
+	case AggregateSum:
+		return m.sum
+	case AggregateMean:
+		return m.mean
+	case AggregateMin:
+		return m.min
+	case AggregateMax:
+		return m.max
+	case AggregateStdDev:
+		return math.Sqrt(m.populationVariance())
+	case AggregateVariance:
+		if m.n < 2 {
+			return nil
+		}
+		return m.sampleVariance()
+	case AggregateMedian:
+		if values := g.exact[i]; values != nil {
+			sort.Float64s(values)
+			lower, upper, weight := quantileRanks(int64(len(values)), 0.5)
+			return values[lower] + weight*(values[upper]-values[lower])
+		}
+		v, _ := g.digests[i].quantile(0.5)
+		return v
+	}
+	return nil
+}
+
+//grouper
+/*
+Accumulates the aggregates of the groups of a file, groups are kept in the order they are first seen.
+The values of the columns whose median is wanted are kept while `buffered` stays within <maxBufferedValues>.
+*/
+type grouper struct {
+	aggregates []*aggregate
+	groups     map[string]*group
+	order      []*group
+	buffered   int
+}
+
+func newGrouper(aggregates []*aggregate) *grouper {
+	return &grouper{aggregates: aggregates, groups: make(map[string]*group)}
+}
+
+//group
+/*
+Returns the group with the values `keys`, creating it if it is new, or a `ParameterError` when there are too many groups.
+*/
+func (gr *grouper) group(keys []string) (*group, error) {
+	key := strings.Join(keys, "\x00")
+	if g, ok := gr.groups[key]; ok {
+		return g, nil
+	}
+	if len(gr.groups) == maxGroups {
+		return nil, &ParameterError{Message: fmt.Sprintf("more than %d groups", maxGroups)}
+	}
+	g := &group{
+		keys:    append([]string(nil), keys...),
+		values:  make([]moments, len(gr.aggregates)),
+		exact:   make([][]float64, len(gr.aggregates)),
+		digests: make([]*tdigest, len(gr.aggregates)),
+	}
+	for i, agg := range gr.aggregates {
+		if agg.function == AggregateMedian {
+			g.exact[i] = []float64{}
+			g.digests[i] = newDigest()
+		}
+	}
+	gr.groups[key] = g
+	gr.order = append(gr.order, g)
+	return g, nil
+}
+
+// add adds the fields of `record` to the aggregates of `g`.
+func (gr *grouper) add(g *group, record []string) {
+	g.rows++
+	for i, agg := range gr.aggregates {
+		if agg.column == -1 || agg.column >= len(record) {
+			continue
+		}
+		v, ok := parseValue(record[agg.column])
+		if !ok {
+			continue
+		}
+		g.values[i].add(v)
+		if g.digests[i] == nil {
+			continue
+		}
+		g.digests[i].add(v)
+		if g.exact[i] == nil {
+			continue
+		}
+		if gr.buffered == maxBufferedValues {
+			// the group no longer fits, its median is estimated from here on
+			gr.buffered -= len(g.exact[i])
+			g.exact[i] = nil
+			continue
+		}
+		g.exact[i] = append(g.exact[i], v)
+		gr.buffered++
+	}
+}
+
+// row returns the keys of `g` followed by the result of each aggregate.
+func (gr *grouper) row(g *group) []interface{} {
+	row := make([]interface{}, 0, len(g.keys)+len(gr.aggregates))
+	for _, key := range g.keys {
+		row = append(row, key)
+	}
+	for i, agg := range gr.aggregates {
+		row = append(row, g.value(i, agg))
+	}
+	return row
+}
+
+//GroupBy
+/*
+Groups the records of the CSV file at `filePath` by the values of the columns in `grouping.By`
+and calculates the aggregates of each group in a single pass, returned as a `Table` with a column for each
+grouping column followed by a column for each aggregate.
+
+Aggregates other than 'count(*)' only consider the numeric values of their column, 'count(column)' counts them.
+The median of a group is exact while the values of every group fit within <maxBufferedValues>,
+beyond that the median of the groups that no longer fit is estimated with a t-digest.
+*/
+func GroupBy(filePath string, opts Options, grouping GroupOptions) (*Table, *time.Time, error) {
+	startTime := time.Now()
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+
+	if len(grouping.By) == 0 {
+		return nil, nil, &ParameterError{Message: "at least one column to group by must be provided"}
+	}
+	if len(grouping.Aggregates) == 0 {
+		grouping.Aggregates = []string{"count(*)"}
+	}
+	if grouping.Limit < 0 {
+		return nil, nil, &ParameterError{Message: "limit must not be negative"}
+	}
+	byIndexes, err := rr.ColumnIndexes(grouping.By)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0, len(byIndexes)+len(grouping.Aggregates))
+	for _, index := range byIndexes {
+		columns = append(columns, rr.Header()[index])
+	}
+	aggregates := make([]*aggregate, len(grouping.Aggregates))
+	for i, s := range grouping.Aggregates {
+		if aggregates[i], err = parseAggregate(rr, s); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, aggregates[i].name)
+	}
+	less, err := groupOrder(columns, grouping.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gr := newGrouper(aggregates)
+	keys := make([]string, len(byIndexes))
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		for i, index := range byIndexes {
+			keys[i] = ""
+			if index < len(record) {
+				keys[i] = record[index]
+			}
+		}
+		g, err := gr.group(keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		gr.add(g, record)
+	}
+
+	rows := make([][]interface{}, len(gr.order))
+	for i, g := range gr.order {
+		rows[i] = gr.row(g)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+	table := &Table{Columns: columns, Rows: rows, Total: len(rows)}
+	if grouping.Limit > 0 && len(table.Rows) > grouping.Limit {
+		table.Rows = table.Rows[:grouping.Limit]
+	}
+	return table, &startTime, nil
+}
+
+//groupOrder
+/*
+Returns the ordering of the rows of a table with `columns` described by `order`, see GroupOptions.
+Rows are ordered by the grouping columns when `order` is empty.
+*/
+func groupOrder(columns []string, order []string) (func(a, b []interface{}) bool, error) {
+	type key struct {
+		column     int
+		descending bool
+	}
+	var keys []key
+	for _, s := range order {
+		descending := strings.HasPrefix(s, "-")
+		name := strings.TrimPrefix(s, "-")
+		column := -1
+		for i, c := range columns {
+			if strings.EqualFold(c, name) {
+				column = i
+				break
+			}
+		}
+		if column == -1 {
+			return nil, &ParameterError{Message: fmt.Sprintf("cannot sort by '%s', expected one of: %s",
+				name, strings.Join(columns, ", "))}
+		}
+		keys = append(keys, key{column: column, descending: descending})
+	}
+	if len(keys) == 0 {
+		for i := range columns {
+			keys = append(keys, key{column: i})
+		}
+	}
+	return func(a, b []interface{}) bool {
+		for _, k := range keys {
+			// undefined values are placed last in either order
+			if x, y := a[k.column] == nil, b[k.column] == nil; x || y {
+				if x == y {
+					continue
+				}
+				return y
+			}
+			c := compareValues(a[k.column], b[k.column])
+			if c == 0 {
+				continue
+			}
+			if k.descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,367 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Methods of choosing the bins of a histogram, see HistogramOptions.
+const (
+	BinsFixedWidth       = "width"
+	BinsFixedCount       = "count"
+	BinsFreedmanDiaconis = "fd"
+	BinsEdges            = "edges"
+	BinsValues           = "values"
+)
+
+var binMethods = []string{BinsFixedWidth, BinsFixedCount, BinsFreedmanDiaconis, BinsEdges, BinsValues}
+
+// DefaultBins is the number of bins of the fixed-width and fixed-count methods when none is requested.
+const DefaultBins = 10
+
+// maxBins bounds the number of bins of a histogram.
+const maxBins = 10000
+
+//HistogramOptions
+/*
+How the bins of a histogram are chosen:
+  - 'width' splits the range of the values into `Bins` bins of the same width
+  - 'count' places the edges at quantiles so the `Bins` bins hold about as many values each
+  - 'fd' uses bins of width 2*IQR/n^(1/3) (Freedman-Diaconis) over the range of the values
+  - 'edges' uses the bin edges in `Edges`, which must be increasing
+  - 'values' counts how often each distinct value occurs, as is done for categorical columns with every method
+
+`Limit` bounds the number of distinct values reported for a categorical column, every value is reported when it is zero.
+*/
+type HistogramOptions struct {
+	Method string
+	Bins   int
+	Edges  []float64
+	Limit  int
+}
+
+//Histogram
+/*
+The distribution of the values of a column.
+
+The histogram of a numeric column has len(Edges)-1 bins, bin i holds the values in [Edges[i], Edges[i+1]),
+the last bin also holding the values equal to its upper edge.
+Densities are the counts divided by the number of values in the bins and the width of the bin, so they integrate to 1.
+Outside counts the values beyond the edges supplied with the 'edges' method.
+
+The histogram of a categorical column holds the count of each distinct value in Values, most frequent first,
+and Other counts the values left out by the limit.
+Missing counts the rows whose field is empty, or is not a number when the column is numeric.
+*/
+type Histogram struct {
+	Type      string       `json:"type"`
+	Count     int64        `json:"count"`
+	Missing   int64        `json:"missing"`
+	Edges     []float64    `json:"edges,omitempty"`
+	Counts    []int64      `json:"counts,omitempty"`
+	Densities []float64    `json:"densities,omitempty"`
+	Outside   int64        `json:"outside,omitempty"`
+	Values    []ValueCount `json:"values,omitempty"`
+	Other     int64        `json:"other,omitempty"`
+}
+
+// ValueCount is how often a value of a categorical column occurs, Frequency is its share of the values counted.
+type ValueCount struct {
+	Value     string  `json:"value"`
+	Count     int64   `json:"count"`
+	Frequency float64 `json:"frequency"`
+}
+
+//histogramColumn
+/*
+The state of the histogram of a column while the file is read: the numeric values are accumulated on the first pass
+to choose the edges, `text` counts the fields that are not numbers, and the bins or distinct values are counted
+on the second pass.
+*/
+type histogramColumn struct {
+	acc         *accumulator
+	text        int64
+	categorical bool
+	histogram   *Histogram
+	values      map[string]int64
+}
+
+//CalculateHistograms
+/*
+Calculates the `Histogram` of every column in `columnNames` of the CSV file at `filePath`, returned by column name.
+A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+A column is categorical when most of its non-empty fields are not numbers or when the 'values' method is used.
+The file is read once to find the range, and the quantiles the method needs, of every column
+and once more to count the values into bins.
+*/
+func CalculateHistograms(columnNames []string, filePath string, opts Options, hist HistogramOptions) (map[string]*Histogram, *time.Time, error) {
+	startTime := time.Now()
+
+	if err := checkHistogramOptions(&hist); err != nil {
+		return nil, nil, err
+	}
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	if err != nil {
+		rr.Close()
+		return nil, nil, err
+	}
+
+	columns := make([]*histogramColumn, len(columnIndexes))
+	accumulators := make([]*accumulator, len(columnIndexes))
+	for i := range columns {
+		accumulators[i] = newAccumulator(0)
+		if hist.Method == BinsFixedCount || hist.Method == BinsFreedmanDiaconis {
+			accumulators[i].limit = maxBufferedValues / len(accumulators)
+		}
+		columns[i] = &histogramColumn{acc: accumulators[i]}
+	}
+	err = readFields(rr, columnIndexes, func(column int, field string) error {
+		c := columns[column]
+		if v, ok := parseValue(field); ok {
+			c.acc.add(v)
+		} else if strings.TrimSpace(field) != "" {
+			c.text++
+		}
+		return nil
+	})
+	rr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var quantiles []float64
+	switch hist.Method {
+	case BinsFixedCount:
+		for k := 1; k < hist.Bins; k++ {
+			quantiles = append(quantiles, float64(k)/float64(hist.Bins))
+		}
+	case BinsFreedmanDiaconis:
+		quantiles = []float64{0.25, 0.75}
+	}
+	quantile, err := resolveQuantiles(accumulators, quantiles, func(yield func(column int, v float64)) error {
+		return scanColumns(filePath, opts, columnIndexes, yield)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, c := range columns {
+		c.categorical = hist.Method == BinsValues || c.text > c.acc.n
+		if c.categorical {
+			c.histogram = &Histogram{Type: "categorical"}
+			c.values = make(map[string]int64)
+			continue
+		}
+		c.histogram = &Histogram{Type: "numeric", Edges: binEdges(c.acc, hist, func(p float64) float64 { return quantile(i, p) })}
+		if len(c.histogram.Edges) > 0 {
+			c.histogram.Counts = make([]int64, len(c.histogram.Edges)-1)
+		}
+	}
+
+	rr, err = OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	distinct := 0
+	err = readFields(rr, columnIndexes, func(column int, field string) error {
+		c := columns[column]
+		h := c.histogram
+		if c.categorical {
+			if strings.TrimSpace(field) == "" {
+				h.Missing++
+				return nil
+			}
+			if _, ok := c.values[field]; !ok {
+				if distinct++; distinct > maxGroups {
+					return &ParameterError{Message: fmt.Sprintf("more than %d distinct values", maxGroups)}
+				}
+			}
+			c.values[field]++
+			h.Count++
+			return nil
+		}
+		v, ok := parseValue(field)
+		if !ok {
+			h.Missing++
+			return nil
+		}
+		edges := h.Edges
+		if len(edges) == 0 || v < edges[0] || v > edges[len(edges)-1] {
+			h.Outside++
+			return nil
+		}
+		// the bin whose lower edge is the last one not above v, the upper edge belongs to the last bin
+		b := sort.Search(len(edges), func(i int) bool { return edges[i] > v }) - 1
+		if b == len(edges)-1 {
+			b--
+		}
+		h.Counts[b]++
+		h.Count++
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	histograms := make(map[string]*Histogram, len(columns))
+	for i, c := range columns {
+		if c.categorical {
+			c.histogram.Values, c.histogram.Other = valueCounts(c.values, c.histogram.Count, hist.Limit)
+		} else {
+			c.histogram.Densities = densities(c.histogram)
+		}
+		histograms[columnNames[i]] = c.histogram
+	}
+	return histograms, &startTime, nil
+}
+
+//checkHistogramOptions
+/*
+Validates `hist`, defaulting the method to 'width' and the number of bins to DefaultBins.
+*/
+func checkHistogramOptions(hist *HistogramOptions) error {
+	hist.Method = strings.ToLower(hist.Method)
+	if hist.Method == "" {
+		hist.Method = BinsFixedWidth
+		if len(hist.Edges) > 0 {
+			hist.Method = BinsEdges
+		}
+	}
+	known := false
+	for _, m := range binMethods {
+		known = known || m == hist.Method
+	}
+	if !known {
+		return &ParameterError{Message: fmt.Sprintf("unknown method '%s', expected one of: %s",
+			hist.Method, strings.Join(binMethods, ", "))}
+	}
+	if hist.Bins == 0 {
+		hist.Bins = DefaultBins
+	}
+	if hist.Bins < 1 || hist.Bins > maxBins {
+		return &ParameterError{Message: fmt.Sprintf("bins must be between 1 and %d", maxBins)}
+	}
+	if hist.Limit < 0 {
+		return &ParameterError{Message: "limit must not be negative"}
+	}
+	if hist.Method == BinsEdges {
+		if len(hist.Edges) < 2 || len(hist.Edges) > maxBins+1 {
+			return &ParameterError{Message: fmt.Sprintf("between 2 and %d edges must be provided", maxBins+1)}
+		}
+		for i, e := range hist.Edges {
+			if isNaNOrInf(e) || (i > 0 && e <= hist.Edges[i-1]) {
+				return &ParameterError{Message: "edges must be finite and increasing"}
+			}
+		}
+	}
+	return nil
+}
+
+//readFields
+/*
+Calls `yield` with the field of every column at `columnIndexes` of each remaining record of `rr`,
+fields missing from a record are empty. The read stops at the first error returned by `yield`.
+*/
+func readFields(rr *RecordReader, columnIndexes []int, yield func(column int, field string) error) error {
+	return eachRecord(rr, func(record []string) error {
+		for i, index := range columnIndexes {
+			field := ""
+			if index < len(record) {
+				field = record[index]
+			}
+			if err := yield(i, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//binEdges
+/*
+Returns the edges of the bins of a numeric column chosen by the method of `hist`,
+`quantile` gives the quantiles the method asked for. Columns without values have no bins.
+*/
+func binEdges(acc *accumulator, hist HistogramOptions, quantile func(p float64) float64) []float64 {
+	if hist.Method == BinsEdges {
+		return append([]float64(nil), hist.Edges...)
+	}
+	if acc.n == 0 {
+		return nil
+	}
+	if acc.min == acc.max {
+		// a single bin centred on the only value, as numpy does
+		return []float64{acc.min - 0.5, acc.max + 0.5}
+	}
+	bins := hist.Bins
+	switch hist.Method {
+	case BinsFixedCount:
+		edges := []float64{acc.min}
+		for k := 1; k < bins; k++ {
+			// ties between quantiles would give empty bins of no width, which are left out
+			if e := quantile(float64(k) / float64(bins)); e > edges[len(edges)-1] && e < acc.max {
+				edges = append(edges, e)
+			}
+		}
+		return append(edges, acc.max)
+	case BinsFreedmanDiaconis:
+		width := 2 * (quantile(0.75) - quantile(0.25)) / math.Cbrt(float64(acc.n))
+		if width > 0 {
+			bins = int(math.Min(math.Ceil((acc.max-acc.min)/width), maxBins))
+		} else {
+			// Sturges' rule when the values are too concentrated for the interquartile range to give a width
+			bins = int(math.Ceil(math.Log2(float64(acc.n)))) + 1
+		}
+	}
+	edges := make([]float64, bins+1)
+	for i := range edges {
+		edges[i] = acc.min + (acc.max-acc.min)*float64(i)/float64(bins)
+	}
+	edges[bins] = acc.max
+	return edges
+}
+
+func densities(h *Histogram) []float64 {
+	if h.Count == 0 {
+		return nil
+	}
+	densities := make([]float64, len(h.Counts))
+	for i, count := range h.Counts {
+		densities[i] = float64(count) / float64(h.Count) / (h.Edges[i+1] - h.Edges[i])
+	}
+	return densities
+}
+
+//valueCounts
+/*
+Returns the counts of the distinct values in `counts` most frequent first, ties ordered by value,
+keeping at most `limit` of them when it is positive along with the total count of the values left out.
+*/
+func valueCounts(counts map[string]int64, total int64, limit int) ([]ValueCount, int64) {
+	values := make([]ValueCount, 0, len(counts))
+	for v, c := range counts {
+		values = append(values, ValueCount{Value: v, Count: c, Frequency: float64(c) / float64(total)})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Value < values[j].Value
+	})
+	var other int64
+	if limit > 0 && len(values) > limit {
+		for _, v := range values[limit:] {
+			other += v.Count
+		}
+		values = values[:limit]
+	}
+	return values, other
+}
@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// columnFile writes the fields of a single column 'v' to a CSV file and returns its path.
+func columnFile(t *testing.T, fields ...string) string {
+	t.Helper()
+	return writeCSV(t, t.TempDir(), "column.csv", append([]string{"id,v"}, numbered(fields)...)...)
+}
+
+func numbered(fields []string) []string {
+	lines := make([]string, len(fields))
+	for i, f := range fields {
+		lines[i] = fmt.Sprintf("%d,%s", i, f)
+	}
+	return lines
+}
+
+func numbers(from, to int) []string {
+	var fields []string
+	for i := from; i <= to; i++ {
+		fields = append(fields, fmt.Sprint(i))
+	}
+	return fields
+}
+
+func TestNumericHistograms(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []string
+		hist    HistogramOptions
+		edges   []float64
+		counts  []int64
+		missing int64
+		outside int64
+	}{
+		{
+			// the last bin closes on its upper edge
+			name: "width", fields: append(numbers(0, 10), "", "n/a"), hist: HistogramOptions{Bins: 5},
+			edges: []float64{0, 2, 4, 6, 8, 10}, counts: []int64{2, 2, 2, 2, 3}, missing: 2,
+		},
+		{
+			name: "count", fields: numbers(1, 8), hist: HistogramOptions{Method: BinsFixedCount, Bins: 4},
+			edges: []float64{1, 2.75, 4.5, 6.25, 8}, counts: []int64{2, 2, 2, 2},
+		},
+		{
+			// ties between the quantiles leave out the empty bins
+			name: "count with ties", fields: []string{"1", "1", "1", "1", "1", "1", "2", "3"}, hist: HistogramOptions{Method: BinsFixedCount, Bins: 4},
+			edges: []float64{1, 1.25, 3}, counts: []int64{6, 2},
+		},
+		{
+			// an interquartile range of 3.5 over 8 values gives bins of width 2*3.5/2
+			name: "fd", fields: numbers(1, 8), hist: HistogramOptions{Method: BinsFreedmanDiaconis},
+			edges: []float64{1, 4.5, 8}, counts: []int64{4, 4},
+		},
+		{
+			name: "edges", fields: []string{"-1", "0", "3", "5", "10", "11"}, hist: HistogramOptions{Edges: []float64{0, 5, 10}},
+			edges: []float64{0, 5, 10}, counts: []int64{2, 2}, outside: 2,
+		},
+		{
+			name: "single value", fields: []string{"4", "4"}, hist: HistogramOptions{Bins: 3},
+			edges: []float64{3.5, 4.5}, counts: []int64{2},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			histograms, _, err := CalculateHistograms([]string{"v"}, columnFile(t, tc.fields...), Options{}, tc.hist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := histograms["v"]
+			if h.Type != "numeric" || fmt.Sprint(h.Edges) != fmt.Sprint(tc.edges) || fmt.Sprint(h.Counts) != fmt.Sprint(tc.counts) {
+				t.Errorf("%s histogram with edges %v and counts %v, want %v and %v", h.Type, h.Edges, h.Counts, tc.edges, tc.counts)
+			}
+			var count int64
+			for _, c := range tc.counts {
+				count += c
+			}
+			if h.Count != count || h.Missing != tc.missing || h.Outside != tc.outside {
+				t.Errorf("count %d, missing %d and outside %d, want %d, %d and %d", h.Count, h.Missing, h.Outside, count, tc.missing, tc.outside)
+			}
+			var total float64
+			for i, d := range h.Densities {
+				total += d * (h.Edges[i+1] - h.Edges[i])
+			}
+			if math.Abs(total-1) > 1e-12 {
+				t.Errorf("densities %v integrate to %v", h.Densities, total)
+			}
+		})
+	}
+}
+
+func TestHistogramQuantilesOverBufferLimit(t *testing.T) {
+	lines := []string{"a,b"}
+	for i := 1; i <= 8; i++ {
+		lines = append(lines, fmt.Sprintf("%d,%d", i, 10*i))
+	}
+	path := writeCSV(t, t.TempDir(), "two.csv", lines...)
+	defer func(limit int) { maxBufferedValues = limit }(maxBufferedValues)
+	// the limit is shared between the columns, so neither keeps its values and the quantiles are found by another pass
+	maxBufferedValues = 12
+	histograms, _, err := CalculateHistograms([]string{"a", "b"}, path, Options{}, HistogramOptions{Method: BinsFixedCount, Bins: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(histograms["a"].Edges, histograms["b"].Edges); got != "[1 2.75 4.5 6.25 8] [10 27.5 45 62.5 80]" {
+		t.Errorf("edges %s", got)
+	}
+}
+
+func TestCategoricalHistograms(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		hist   HistogramOptions
+		values string
+		other  int64
+	}{
+		// most of the fields are not numbers, the number is counted as a value like the others
+		{name: "detected", fields: []string{"a", "b", "a", "1", "", "c", "a"}, values: "[{a 3 0.5} {1 1 0.16666666666666666} {b 1 0.16666666666666666} {c 1 0.16666666666666666}]"},
+		{name: "limit", fields: []string{"a", "b", "a", "1", "", "c", "a"}, hist: HistogramOptions{Limit: 2}, values: "[{a 3 0.5} {1 1 0.16666666666666666}]", other: 2},
+		{name: "values method", fields: []string{"2", "1", "2", ""}, hist: HistogramOptions{Method: BinsValues}, values: "[{2 2 0.6666666666666666} {1 1 0.3333333333333333}]"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			histograms, _, err := CalculateHistograms([]string{"v"}, columnFile(t, tc.fields...), Options{}, tc.hist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := histograms["v"]
+			if h.Type != "categorical" || h.Edges != nil || h.Missing != 1 {
+				t.Errorf("%s histogram with edges %v and %d missing", h.Type, h.Edges, h.Missing)
+			}
+			if got := fmt.Sprint(h.Values); got != tc.values || h.Other != tc.other {
+				t.Errorf("values %s and %d other, want %s and %d", got, h.Other, tc.values, tc.other)
+			}
+		})
+	}
+}
+
+func TestHistogramOptions(t *testing.T) {
+	path := columnFile(t, numbers(1, 8)...)
+	for _, hist := range []HistogramOptions{
+		{Method: "sturges"},
+		{Bins: -1},
+		{Bins: maxBins + 1},
+		{Limit: -1},
+		{Method: BinsEdges},
+		{Edges: []float64{0, 5, 5}},
+		{Edges: []float64{0, math.Inf(1)}},
+	} {
+		var paramErr *ParameterError
+		if _, _, err := CalculateHistograms([]string{"v"}, path, Options{}, hist); !errors.As(err, &paramErr) {
+			t.Errorf("%+v: error %v, want a ParameterError", hist, err)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package stats
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hypothesis tests that can be run with CompareSamples.
+const (
+	TestWelch       = "welch"
+	TestMannWhitney = "mannwhitney"
+	TestChiSquare   = "chisquare"
+)
+
+// Measures of effect size reported in TestResult.Effect.
+const (
+	EffectCohensD      = "cohens_d"
+	EffectRankBiserial = "rank_biserial"
+	EffectCramersV     = "cramers_v"
+)
+
+// maxTestCategories bounds the number of distinct values of each variable of a chi-square test.
+const maxTestCategories = 1000
+
+//Sample
+/*
+One of the two samples compared by a test: the values of the column `Column` of the CSV file at `FilePath`,
+read with `Options`, in the rows that also match the filter expression `Where` when it is set.
+`Name` labels the sample in the result.
+*/
+type Sample struct {
+	Name     string
+	FilePath string
+	Options  Options
+	Column   string
+	Where    string
+}
+
+//SampleSummary
+/*
+Describes a sample of a test: the number of values it was tested on and the number of rows where the column was
+missing, or not a number for the Welch and Mann–Whitney tests. Mean and StdDev, the sample standard deviation,
+are set for numeric tests, Categories, the number of distinct values, for the chi-square test.
+*/
+type SampleSummary struct {
+	Name       string   `json:"name"`
+	N          int64    `json:"n"`
+	Missing    int64    `json:"missing"`
+	Mean       *float64 `json:"mean,omitempty"`
+	StdDev     *float64 `json:"stddev,omitempty"`
+	Categories int      `json:"categories,omitempty"`
+}
+
+//TestResult
+/*
+The outcome of a hypothesis test: its statistic, degrees of freedom, two-sided p-value and effect size,
+`Effect` naming the measure of the effect size. Z is the normal approximation of the Mann–Whitney U statistic,
+which has no degrees of freedom. Values are null when they are undefined, e.g. for a sample of a single value
+or samples whose values are all the same.
+
+Cohen's d and the rank-biserial correlation are positive when the first sample tends to be larger than the second.
+*/
+type TestResult struct {
+	Test       string          `json:"test"`
+	Samples    []SampleSummary `json:"samples"`
+	Statistic  *float64        `json:"statistic"`
+	Z          *float64        `json:"z,omitempty"`
+	DF         *float64        `json:"df"`
+	PValue     *float64        `json:"p_value"`
+	Effect     string          `json:"effect"`
+	EffectSize *float64        `json:"effect_size"`
+}
+
+//CompareSamples
+/*
+Runs the hypothesis `test` on two independent samples, see Sample:
+'welch' tests whether their means differ with Welch's t-test, which does not assume equal variances,
+'mannwhitney' whether one tends to be larger than the other with the Mann–Whitney U test,
+and 'chisquare' whether the distribution of their values differs with Pearson's chi-square test,
+the values of the column being treated as categories.
+
+The Welch test reads the samples in a single pass each. The Mann–Whitney test ranks the values of both samples
+together so they are held in memory, up to <maxRankedValues> values, and its p-value is found with the normal
+approximation corrected for ties and continuity. The chi-square test supports up to <maxTestCategories> categories.
+*/
+func CompareSamples(test string, samples [2]Sample) (*TestResult, *time.Time, error) {
+	startTime := time.Now()
+
+	result := &TestResult{Test: strings.ToLower(test), Samples: make([]SampleSummary, 2)}
+	for i, s := range samples {
+		result.Samples[i].Name = s.Name
+	}
+	var err error
+	switch result.Test {
+	case TestWelch:
+		err = welchTest(samples, result)
+	case TestMannWhitney:
+		err = mannWhitneyTest(samples, result)
+	case TestChiSquare:
+		err = chiSquareSamples(samples, result)
+	default:
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("unknown test '%s', expected one of: %s, %s, %s",
+			test, TestWelch, TestMannWhitney, TestChiSquare)}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, &startTime, nil
+}
+
+//TestIndependence
+/*
+Tests whether the categorical columns `columnNames`, exactly two, of the CSV file at `filePath` are independent
+with Pearson's chi-square test on their contingency table, using the rows where both are present.
+Cramér's V measures the strength of their association. Each column may have up to <maxTestCategories> values.
+*/
+func TestIndependence(columnNames []string, filePath string, opts Options) (*TestResult, *time.Time, error) {
+	startTime := time.Now()
+
+	if len(columnNames) != 2 {
+		return nil, nil, &ParameterError{Message: "exactly two columns must be provided"}
+	}
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &TestResult{Test: TestChiSquare, Samples: make([]SampleSummary, 2)}
+	for i, index := range columnIndexes {
+		result.Samples[i].Name = rr.Header()[index]
+	}
+	table := newContingency()
+	err = eachRecord(rr, func(record []string) error {
+		row, column := field(record, columnIndexes[0]), field(record, columnIndexes[1])
+		complete := true
+		for i, f := range []string{row, column} {
+			if f == "" {
+				result.Samples[i].Missing++
+				complete = false
+			}
+		}
+		if !complete {
+			return nil
+		}
+		return table.add(row, column)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, categories := range []int{len(table.rows), len(table.columns)} {
+		result.Samples[i].N = table.n
+		result.Samples[i].Categories = categories
+	}
+	table.test(result)
+	return result, &startTime, nil
+}
+
+//eachSampleField
+/*
+Calls `yield` with the field of the column of `s` in every record of its file matching its filters.
+*/
+func eachSampleField(s Sample, yield func(field string) error) error {
+	opts := s.Options
+	if s.Where != "" {
+		if opts.Where != "" {
+			opts.Where = fmt.Sprintf("(%s) AND (%s)", opts.Where, s.Where)
+		} else {
+			opts.Where = s.Where
+		}
+	}
+	rr, err := OpenRecords(s.FilePath, opts)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	index := rr.ColumnIndex(s.Column)
+	if index == -1 {
+		return &ColumnNotFoundError{Columns: []string{s.Column}}
+	}
+	return eachRecord(rr, func(record []string) error {
+		return yield(field(record, index))
+	})
+}
+
+//numericSample
+/*
+Reads the numeric values of `s` into the moments `m`, and into `values` unless it is nil,
+counting the other rows as missing in `summary`. `limit` bounds the number of values held across the calls sharing `values`.
+*/
+func numericSample(s Sample, m *moments, values *[]float64, limit int, summary *SampleSummary) error {
+	err := eachSampleField(s, func(field string) error {
+		v, ok := parseValue(field)
+		if !ok {
+			summary.Missing++
+			return nil
+		}
+		m.add(v)
+		if values != nil {
+			if len(*values) >= limit {
+				return &ParameterError{Message: fmt.Sprintf("too many values to rank for the Mann–Whitney test, at most %d are supported",
+					limit)}
+			}
+			*values = append(*values, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	summary.N = m.n
+	if m.n > 0 {
+		summary.Mean = float(m.mean)
+	}
+	if m.n > 1 {
+		summary.StdDev = float(math.Sqrt(m.sampleVariance()))
+	}
+	return nil
+}
+
+//welchTest
+/*
+Compares the means of `samples` with Welch's t-test, its degrees of freedom given by the Welch–Satterthwaite equation,
+and measures the difference with Cohen's d over the pooled standard deviation.
+*/
+func welchTest(samples [2]Sample, result *TestResult) error {
+	var m [2]moments
+	for i, s := range samples {
+		if err := numericSample(s, &m[i], nil, 0, &result.Samples[i]); err != nil {
+			return err
+		}
+	}
+	result.Effect = EffectCohensD
+	if m[0].n < 2 || m[1].n < 2 {
+		return nil
+	}
+	n1, n2 := float64(m[0].n), float64(m[1].n)
+	v1, v2 := m[0].sampleVariance()/n1, m[1].sampleVariance()/n2
+	diff := m[0].mean - m[1].mean
+	if pooled := math.Sqrt((m[0].m2 + m[1].m2) / (n1 + n2 - 2)); pooled > 0 {
+		result.EffectSize = float(diff / pooled)
+	}
+	if v1+v2 == 0 {
+		return nil
+	}
+	t := diff / math.Sqrt(v1+v2)
+	df := (v1 + v2) * (v1 + v2) / (v1*v1/(n1-1) + v2*v2/(n2-1))
+	result.Statistic = float(t)
+	result.DF = float(df)
+	result.PValue = float(studentTTwoTailed(t, df))
+	return nil
+}
+
+//mannWhitneyTest
+/*
+Compares `samples` with the Mann–Whitney U test, U being the statistic of the first sample,
+and measures the difference with the rank-biserial correlation 2U/(n1·n2) - 1.
+*/
+func mannWhitneyTest(samples [2]Sample, result *TestResult) error {
+	var m [2]moments
+	var values []float64
+	for i, s := range samples {
+		if err := numericSample(s, &m[i], &values, maxRankedValues, &result.Samples[i]); err != nil {
+			return err
+		}
+	}
+	result.Effect = EffectRankBiserial
+	if m[0].n == 0 || m[1].n == 0 {
+		return nil
+	}
+	n1, n2 := float64(m[0].n), float64(m[1].n)
+	n := n1 + n2
+	ranked := ranks(values)
+	r1 := 0.0
+	for _, r := range ranked[:m[0].n] {
+		r1 += r
+	}
+	u := r1 - n1*(n1+1)/2
+	result.Statistic = float(u)
+	result.EffectSize = float(2*u/(n1*n2) - 1)
+
+	// tied values lower the variance of U
+	sort.Float64s(values)
+	ties := 0.0
+	for start := 0; start < len(values); {
+		end := start + 1
+		for end < len(values) && values[end] == values[start] {
+			end++
+		}
+		t := float64(end - start)
+		ties += t*t*t - t
+		start = end
+	}
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return nil
+	}
+	deviation := u - n1*n2/2
+	// continuity correction towards the mean
+	deviation = math.Copysign(math.Max(math.Abs(deviation)-0.5, 0), deviation)
+	z := deviation / math.Sqrt(variance)
+	result.Z = float(z)
+	result.PValue = float(normalTwoTailed(z))
+	return nil
+}
+
+//chiSquareSamples
+/*
+Compares the distribution of the values of `samples` with the chi-square test of the contingency table of
+the sample each value was drawn from against the value, fields that are empty are missing.
+*/
+func chiSquareSamples(samples [2]Sample, result *TestResult) error {
+	table := newContingency()
+	for i, s := range samples {
+		summary := &result.Samples[i]
+		categories := make(map[string]bool)
+		err := eachSampleField(s, func(field string) error {
+			if field == "" {
+				summary.Missing++
+				return nil
+			}
+			summary.N++
+			categories[field] = true
+			return table.add(strconv.Itoa(i), field)
+		})
+		if err != nil {
+			return err
+		}
+		summary.Categories = len(categories)
+	}
+	table.test(result)
+	return nil
+}
+
+//contingency
+/*
+Counts the rows of each pair of categories of two variables.
+*/
+type contingency struct {
+	rows    map[string]int64
+	columns map[string]int64
+	cells   map[[2]string]int64
+	n       int64
+}
+
+func newContingency() *contingency {
+	return &contingency{
+		rows:    make(map[string]int64),
+		columns: make(map[string]int64),
+		cells:   make(map[[2]string]int64),
+	}
+}
+
+func (c *contingency) add(row, column string) error {
+	if _, ok := c.rows[row]; !ok && len(c.rows) >= maxTestCategories {
+		return &ParameterError{Message: fmt.Sprintf("too many categories for a chi-square test, at most %d are supported",
+			maxTestCategories)}
+	}
+	if _, ok := c.columns[column]; !ok && len(c.columns) >= maxTestCategories {
+		return &ParameterError{Message: fmt.Sprintf("too many categories for a chi-square test, at most %d are supported",
+			maxTestCategories)}
+	}
+	c.rows[row]++
+	c.columns[column]++
+	c.cells[[2]string{row, column}]++
+	c.n++
+	return nil
+}
+
+//test
+/*
+Sets the chi-square statistic of the table, its degrees of freedom, p-value and Cramér's V on `result`,
+which are undefined unless both variables have at least two categories.
+*/
+func (c *contingency) test(result *TestResult) {
+	result.Effect = EffectCramersV
+	if len(c.rows) < 2 || len(c.columns) < 2 {
+		return
+	}
+	n := float64(c.n)
+	chi2 := 0.0
+	// summed in a fixed order so that the statistic does not change between runs
+	rows, columns := slices.Sorted(maps.Keys(c.rows)), slices.Sorted(maps.Keys(c.columns))
+	for _, row := range rows {
+		for _, column := range columns {
+			expected := float64(c.rows[row]) * float64(c.columns[column]) / n
+			d := float64(c.cells[[2]string{row, column}]) - expected
+			chi2 += d * d / expected
+		}
+	}
+	df := float64((len(c.rows) - 1) * (len(c.columns) - 1))
+	result.Statistic = float(chi2)
+	result.DF = float(df)
+	result.PValue = float(chiSquareUpperTail(chi2, df))
+	result.EffectSize = float(math.Sqrt(chi2 / (n * float64(min(len(c.rows), len(c.columns))-1))))
+}
@@ -0,0 +1,199 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+// The reference values were computed independently of this package: the statistics from their textbook formulas,
+// the t-distribution p-value by numerical integration of its density and the other p-values from closed forms.
+
+func checkValue(t *testing.T, name string, got *float64, want float64) {
+	t.Helper()
+	if got == nil {
+		t.Errorf("%s = null, want %.10g", name, want)
+		return
+	}
+	if math.Abs(*got-want) > 1e-6*math.Max(1, math.Abs(want)) {
+		t.Errorf("%s = %.10g, want %.10g", name, *got, want)
+	}
+}
+
+func TestWelchReference(t *testing.T) {
+	lines := []string{"group,value"}
+	for _, v := range []string{"12.1", "14.3", "13.8", "15.2", "12.9", "14.0"} {
+		lines = append(lines, "a,"+v)
+	}
+	for _, v := range []string{"15.5", "16.1", "14.9", "17.2", "16.8", "15.4", "18.0"} {
+		lines = append(lines, "b,"+v)
+	}
+	lines = append(lines, "a,n/a", "b,")
+	path := writeCSV(t, t.TempDir(), "welch.csv", lines...)
+
+	result, _, err := CompareSamples("Welch", [2]Sample{
+		{Name: "a", FilePath: path, Column: "value", Where: "group = 'a'"},
+		{Name: "b", FilePath: path, Column: "value", Where: "group = 'b'"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Test != TestWelch || result.Effect != EffectCohensD {
+		t.Errorf("test %q effect %q", result.Test, result.Effect)
+	}
+	checkValue(t, "t", result.Statistic, -4.183084867180404)
+	checkValue(t, "df", result.DF, 10.765697613916561)
+	checkValue(t, "p", result.PValue, 0.0016010683146635563)
+	checkValue(t, "d", result.EffectSize, -2.3231550552189852)
+	if s := result.Samples; s[0].N != 6 || s[0].Missing != 1 || s[1].N != 7 || s[1].Missing != 1 {
+		t.Errorf("samples %+v", s)
+	}
+}
+
+func TestWelchNoVariance(t *testing.T) {
+	path := writeCSV(t, t.TempDir(), "constant.csv", "value", "3", "3", "3")
+	result, _, err := CompareSamples(TestWelch, [2]Sample{
+		{FilePath: path, Column: "value"},
+		{FilePath: path, Column: "value"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Statistic != nil || result.PValue != nil || result.EffectSize != nil {
+		t.Errorf("samples without variance: %+v", result)
+	}
+}
+
+func TestMannWhitneyTies(t *testing.T) {
+	dir := t.TempDir()
+	first := writeCSV(t, dir, "first.csv", "x", "1", "2", "2", "3", "5", "5", "7")
+	second := writeCSV(t, dir, "second.csv", "y", "2", "4", "5", "6", "8", "8", "9", "10")
+
+	result, _, err := CompareSamples(TestMannWhitney, [2]Sample{
+		{FilePath: first, Column: "x"},
+		{FilePath: second, Column: "y"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, "U", result.Statistic, 11)
+	checkValue(t, "z", result.Z, -1.925035649138405)
+	checkValue(t, "p", result.PValue, 0.054224898519599274)
+	checkValue(t, "r", result.EffectSize, -0.6071428571428572)
+	if result.DF != nil {
+		t.Errorf("df = %v, want null", *result.DF)
+	}
+}
+
+func TestChiSquareSamples(t *testing.T) {
+	dir := t.TempDir()
+	first := []string{"colour,id"}
+	second := []string{"colour,id"}
+	for colour, counts := range map[string][2]int{"red": {12, 25}, "green": {18, 9}, "blue": {10, 16}} {
+		for i := 0; i < counts[0]; i++ {
+			first = append(first, colour+",1")
+		}
+		for i := 0; i < counts[1]; i++ {
+			second = append(second, colour+",2")
+		}
+	}
+	first = append(first, ",1")
+
+	result, _, err := CompareSamples(TestChiSquare, [2]Sample{
+		{FilePath: writeCSV(t, dir, "first.csv", first...), Column: "colour"},
+		{FilePath: writeCSV(t, dir, "second.csv", second...), Column: "colour"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, "chi2", result.Statistic, 7.939085239085238)
+	checkValue(t, "df", result.DF, 2)
+	checkValue(t, "p", result.PValue, 0.018882067449063893)
+	checkValue(t, "V", result.EffectSize, 0.2970051484605245)
+	if s := result.Samples; s[0].N != 40 || s[0].Missing != 1 || s[0].Categories != 3 || s[1].N != 50 {
+		t.Errorf("samples %+v", s)
+	}
+}
+
+func TestIndependenceReference(t *testing.T) {
+	lines := []string{"row,column"}
+	for cell, count := range map[string]int{"a,x": 10, "a,y": 20, "b,x": 30, "b,y": 15} {
+		for i := 0; i < count; i++ {
+			lines = append(lines, cell)
+		}
+	}
+	lines = append(lines, "a,", ",y")
+	path := writeCSV(t, t.TempDir(), "table.csv", lines...)
+
+	result, _, err := TestIndependence([]string{"row", "column"}, path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, "chi2", result.Statistic, 8.035714285714285)
+	checkValue(t, "df", result.DF, 1)
+	checkValue(t, "p", result.PValue, 0.004586392080253493)
+	checkValue(t, "V", result.EffectSize, 0.32732683535398854)
+}
+
+func TestUnknownTest(t *testing.T) {
+	_, _, err := CompareSamples("anova", [2]Sample{})
+	if _, ok := err.(*ParameterError); !ok {
+		t.Errorf("error %v, want a ParameterError", err)
+	}
+}
+
+func TestRegularizedGammaQ(t *testing.T) {
+	poisson := func(n int, x float64) float64 {
+		// Q(n, x) of an integer n is the probability of fewer than n events of a Poisson distribution of mean x
+		sum, term := 0.0, 1.0
+		for k := 0; k < n; k++ {
+			if k > 0 {
+				term *= x / float64(k)
+			}
+			sum += term
+		}
+		return math.Exp(-x) * sum
+	}
+	tests := []struct {
+		a, x, want float64
+	}{
+		{1, 0.5, math.Exp(-0.5)},
+		{1, 7, math.Exp(-7)},
+		{0.5, 0.2, math.Erfc(math.Sqrt(0.2))},
+		{0.5, 4, math.Erfc(2)},
+		{3, 1.5, poisson(3, 1.5)},
+		{3, 9, poisson(3, 9)},
+		{50, 40, poisson(50, 40)},
+		{50, 60, poisson(50, 60)},
+		{10, 10, poisson(10, 10)},
+		{10, 11, poisson(10, 11)},
+	}
+	for _, test := range tests {
+		got := regularizedGammaQ(test.a, test.x)
+		if math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("Q(%g, %g) = %.15g, want %.15g", test.a, test.x, got, test.want)
+		}
+	}
+	if got := chiSquareUpperTail(0, 3); got != 1 {
+		t.Errorf("chi-square tail of 0 = %g, want 1", got)
+	}
+	if got := chiSquareUpperTail(18.307038, 10); math.Abs(got-0.05) > 1e-7 {
+		t.Errorf("chi-square tail of the 95th percentile = %g, want 0.05", got)
+	}
+}
+
+func TestNormalTwoTailed(t *testing.T) {
+	tests := []struct {
+		z, want float64
+	}{
+		{0, 1},
+		{1, 0.31731050786291410},
+		{-1.959963984540054, 0.05},
+		{2.5758293035489004, 0.01},
+		{-3.2905267314918945, 0.001},
+	}
+	for _, test := range tests {
+		if got := normalTwoTailed(test.z); math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("normalTwoTailed(%g) = %.15g, want %.15g", test.z, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metrics that can be requested through Options.Metrics.
+const (
+	MetricCount              = "count"
+	MetricMissing            = "missing"
+	MetricSum                = "sum"
+	MetricMean               = "mean"
+	MetricMedian             = "median"
+	MetricStdDev             = "stddev"
+	MetricSampleStdDev       = "sample_stddev"
+	MetricVariance           = "variance"
+	MetricPopulationVariance = "population_variance"
+	MetricMin                = "min"
+	MetricMax                = "max"
+	MetricRange              = "range"
+	MetricQuartiles          = "quartiles"
+	MetricPercentiles        = "percentiles"
+	MetricIQR                = "iqr"
+	MetricMode               = "mode"
+	MetricSkewness           = "skewness"
+	MetricKurtosis           = "kurtosis"
+	MetricCV                 = "cv"
+	// MetricAll requests every metric.
+	MetricAll = "all"
+)
+
+// DefaultMetrics are calculated when no metrics are requested.
+var DefaultMetrics = []string{MetricMean, MetricMedian, MetricStdDev}
+
+var allMetrics = []string{MetricCount, MetricMissing, MetricSum, MetricMean, MetricMedian, MetricStdDev,
+	MetricSampleStdDev, MetricVariance, MetricPopulationVariance, MetricMin, MetricMax, MetricRange,
+	MetricQuartiles, MetricPercentiles, MetricIQR, MetricMode, MetricSkewness, MetricKurtosis, MetricCV}
+
+//Statistics
+/*
+The descriptive statistics of a column, only the metrics that were requested are set.
+Metrics that are undefined for the values of the column, e.g. the sample variance of a single value, are left unset.
+
+StdDev is the population standard deviation, Variance is the sample variance,
+Skewness is the adjusted Fisher-Pearson coefficient and Kurtosis is the bias corrected excess kurtosis.
+Quantiles are interpolated linearly between the closest ranks. When quantiles are approximated,
+Approximate is set and ErrorBounds holds the bound of each quantile by the key it is reported under,
+'median', 'q1', 'q3' or the key of a percentile.
+*/
+type Statistics struct {
+	Count              *int64             `json:"count,omitempty"`
+	Missing            *int64             `json:"missing,omitempty"`
+	Sum                *float64           `json:"sum,omitempty"`
+	Mean               *float64           `json:"mean,omitempty"`
+	Median             *float64           `json:"median,omitempty"`
+	StdDev             *float64           `json:"stddev,omitempty"`
+	SampleStdDev       *float64           `json:"sample_stddev,omitempty"`
+	Variance           *float64           `json:"variance,omitempty"`
+	PopulationVariance *float64           `json:"population_variance,omitempty"`
+	Min                *float64           `json:"min,omitempty"`
+	Max                *float64           `json:"max,omitempty"`
+	Range              *float64           `json:"range,omitempty"`
+	Q1                 *float64           `json:"q1,omitempty"`
+	Q3                 *float64           `json:"q3,omitempty"`
+	IQR                *float64           `json:"iqr,omitempty"`
+	Percentiles        map[string]float64 `json:"percentiles,omitempty"`
+	Mode               *float64           `json:"mode,omitempty"`
+	ModeCount          *int64             `json:"mode_count,omitempty"`
+	Skewness           *float64           `json:"skewness,omitempty"`
+	Kurtosis           *float64           `json:"kurtosis,omitempty"`
+	CV                 *float64           `json:"cv,omitempty"`
+
+	Approximate bool                      `json:"approximate,omitempty"`
+	ErrorBounds map[string]*QuantileBound `json:"error_bounds,omitempty"`
+}
+
+// ParameterError is returned when the options of a calculation are invalid.
+type ParameterError struct {
+	Message string
+}
+
+func (e *ParameterError) Error() string {
+	return e.Message
+}
+
+//metricSet
+/*
+The metrics requested for a calculation along with the percentiles, in [0, 100], to report.
+*/
+type metricSet struct {
+	metrics     map[string]bool
+	percentiles []float64
+	approximate bool
+}
+
+//newMetricSet
+/*
+Validates the metrics and percentiles of `opts`, falling back to DefaultMetrics when no metrics are requested.
+Requesting percentiles implies the 'percentiles' metric.
+*/
+func newMetricSet(opts Options) (*metricSet, error) {
+	names := opts.Metrics
+	if len(names) == 0 {
+		names = DefaultMetrics
+	}
+	known := make(map[string]bool, len(allMetrics))
+	for _, m := range allMetrics {
+		known[m] = true
+	}
+	ms := &metricSet{metrics: make(map[string]bool), approximate: opts.Approximate}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == MetricAll {
+			for _, m := range allMetrics {
+				ms.metrics[m] = true
+			}
+			continue
+		}
+		if !known[name] {
+			return nil, &ParameterError{Message: fmt.Sprintf("unknown metric '%s', expected one of: %s, %s",
+				name, strings.Join(allMetrics, ", "), MetricAll)}
+		}
+		ms.metrics[name] = true
+	}
+	for _, p := range opts.Percentiles {
+		if p < 0 || p > 100 || math.IsNaN(p) {
+			return nil, &ParameterError{Message: fmt.Sprintf("percentile %v must be between 0 and 100", p)}
+		}
+		ms.percentiles = append(ms.percentiles, p)
+	}
+	if len(ms.percentiles) > 0 {
+		ms.metrics[MetricPercentiles] = true
+	} else if ms.metrics[MetricPercentiles] {
+		ms.percentiles = []float64{5, 25, 50, 75, 95}
+	}
+	sort.Float64s(ms.percentiles)
+	return ms, nil
+}
+
+func (ms *metricSet) has(metrics ...string) bool {
+	for _, m := range metrics {
+		if ms.metrics[m] {
+			return true
+		}
+	}
+	return false
+}
+
+//quantiles
+/*
+Returns the quantiles, in [0, 1], needed for the requested metrics.
+*/
+func (ms *metricSet) quantiles() []float64 {
+	var qs []float64
+	if ms.has(MetricMedian) {
+		qs = append(qs, 0.5)
+	}
+	if ms.has(MetricQuartiles, MetricIQR) {
+		qs = append(qs, 0.25, 0.75)
+	}
+	for _, p := range ms.percentiles {
+		qs = append(qs, p/100)
+	}
+	return qs
+}
+
+func (ms *metricSet) needsValues() bool {
+	return !ms.approximate && len(ms.quantiles()) > 0
+}
+
+func (ms *metricSet) needsDigest() bool {
+	return ms.approximate && len(ms.quantiles()) > 0
+}
+
+func (ms *metricSet) needsFrequencies() bool {
+	return ms.has(MetricMode)
+}
+
+//percentileKey
+/*
+Returns the key a percentile is reported under, e.g. 'p95' or 'p99.9'.
+*/
+func percentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func integer(v int64) *int64 {
+	return &v
+}
+
+//statistics
+/*
+Builds the `Statistics` of an accumulated column, `quantile` returns the value of the quantile `p` in [0, 1].
+When quantiles are approximated they are taken from the digest of the accumulator instead, along with their bounds.
+*/
+func (ms *metricSet) statistics(acc *accumulator, quantile func(p float64) float64) *Statistics {
+	s := &Statistics{Approximate: ms.approximate && acc.digest != nil}
+	if s.Approximate {
+		s.ErrorBounds = make(map[string]*QuantileBound)
+	}
+	// value returns the quantile `p` reported under `key`, recording its bound when it is approximated
+	value := func(key string, p float64) float64 {
+		if !s.Approximate {
+			return quantile(p)
+		}
+		v, bound := acc.digest.quantile(p)
+		s.ErrorBounds[key] = &bound
+		return v
+	}
+	n := acc.n
+	if ms.has(MetricCount) {
+		s.Count = integer(n)
+	}
+	if ms.has(MetricMissing) {
+		s.Missing = integer(acc.rows - n)
+	}
+	if n == 0 {
+		// an empty column reports zeroes for its basic metrics and leaves the rest undefined
+		if ms.has(MetricSum) {
+			s.Sum = float(0)
+		}
+		if ms.has(MetricMean) {
+			s.Mean = float(0)
+		}
+		if ms.has(MetricMedian) {
+			s.Median = float(0)
+		}
+		if ms.has(MetricStdDev) {
+			s.StdDev = float(0)
+		}
+		return s
+	}
+	if ms.has(MetricSum) {
+		s.Sum = float(acc.sum)
+	}
+	if ms.has(MetricMean) {
+		s.Mean = float(acc.mean)
+	}
+	if ms.has(MetricMedian) {
+		s.Median = float(value(MetricMedian, 0.5))
+	}
+	if ms.has(MetricStdDev) {
+		s.StdDev = float(math.Sqrt(acc.populationVariance()))
+	}
+	if ms.has(MetricPopulationVariance) {
+		s.PopulationVariance = float(acc.populationVariance())
+	}
+	if n > 1 {
+		if ms.has(MetricSampleStdDev) {
+			s.SampleStdDev = float(math.Sqrt(acc.sampleVariance()))
+		}
+		if ms.has(MetricVariance) {
+			s.Variance = float(acc.sampleVariance())
+		}
+	}
+	if ms.has(MetricMin) {
+		s.Min = float(acc.min)
+	}
+	if ms.has(MetricMax) {
+		s.Max = float(acc.max)
+	}
+	if ms.has(MetricRange) {
+		s.Range = float(acc.max - acc.min)
+	}
+	if ms.has(MetricQuartiles, MetricIQR) {
+		q1, q3 := value("q1", 0.25), value("q3", 0.75)
+		if ms.has(MetricQuartiles) {
+			s.Q1, s.Q3 = float(q1), float(q3)
+		}
+		if ms.has(MetricIQR) {
+			s.IQR = float(q3 - q1)
+		}
+	}
+	if len(ms.percentiles) > 0 {
+		s.Percentiles = make(map[string]float64, len(ms.percentiles))
+		for _, p := range ms.percentiles {
+			s.Percentiles[percentileKey(p)] = value(percentileKey(p), p/100)
+		}
+	}
+	if ms.has(MetricMode) && acc.frequencies != nil {
+		mode, count := acc.frequencies.mode()
+		s.Mode, s.ModeCount = float(mode), integer(count)
+	}
+	if acc.m2 > 0 {
+		if ms.has(MetricSkewness) && n > 2 {
+			s.Skewness = float(acc.skewness())
+		}
+		if ms.has(MetricKurtosis) && n > 3 {
+			s.Kurtosis = float(acc.kurtosis())
+		}
+	}
+	if ms.has(MetricCV) && acc.mean != 0 && n > 1 {
+		s.CV = float(math.Sqrt(acc.sampleVariance()) / acc.mean)
+	}
+	return s
+}
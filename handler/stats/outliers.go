@@ -0,0 +1,293 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Methods of detecting outliers through OutlierOptions.Method.
+const (
+	OutliersZScore         = "zscore"
+	OutliersModifiedZScore = "mad"
+	OutliersIQR            = "iqr"
+)
+
+// defaultThresholds are the thresholds of each method when none is requested.
+var defaultThresholds = map[string]float64{
+	OutliersZScore:         3,
+	OutliersModifiedZScore: 3.5,
+	OutliersIQR:            1.5,
+}
+
+// DefaultOutlierRows is the number of flagged rows returned when no limit is requested.
+const DefaultOutlierRows = 100
+
+//OutlierOptions
+/*
+How outliers are detected: values whose score under `Method` is beyond `Threshold` are flagged.
+  - 'zscore' (the default) scores a value by its distance from the mean in sample standard deviations
+  - 'mad' scores it by 0.6745 times its distance from the median in median absolute deviations (Iglewicz and Hoaglin)
+  - 'iqr' scores it by its distance beyond the quartiles in interquartile ranges, so 1.5 gives Tukey's fences
+
+`Threshold` is the usual threshold of the method, given in defaultThresholds, when nil.
+At most `Limit` flagged rows are returned. `Recompute` recalculates the statistics of each column without its outliers.
+*/
+type OutlierOptions struct {
+	Method    string
+	Threshold *float64
+	Limit     int
+	Recompute bool
+}
+
+//Outliers
+/*
+The outliers of a set of columns: the bounds values were kept within for each column, the flagged rows
+ordered by line, at most the limit of them, and the total number of flagged values.
+Statistics holds the statistics of each column calculated without its outliers when they were recomputed.
+*/
+type Outliers struct {
+	Method     string                    `json:"method"`
+	Threshold  float64                   `json:"threshold"`
+	Bounds     map[string]*OutlierBounds `json:"bounds"`
+	Rows       []OutlierRow              `json:"rows"`
+	Total      int64                     `json:"total"`
+	Statistics map[string]*Statistics    `json:"statistics,omitempty"`
+}
+
+//OutlierBounds
+/*
+The centre and scale a column is scored against along with the bounds its values are kept within, and how many were not.
+The bounds are null when the scale is zero, as every value then scores the same and none are flagged.
+*/
+type OutlierBounds struct {
+	Center  float64  `json:"center"`
+	Scale   float64  `json:"scale"`
+	Lower   *float64 `json:"lower"`
+	Upper   *float64 `json:"upper"`
+	Flagged int64    `json:"flagged"`
+}
+
+// OutlierRow is a value flagged as an outlier along with its score, the line of the file it is on and its record.
+type OutlierRow struct {
+	Line   int      `json:"line"`
+	Column string   `json:"column"`
+	Value  float64  `json:"value"`
+	Score  float64  `json:"score"`
+	Record []string `json:"record"`
+}
+
+//scorer
+/*
+Scores the values of a column: the score of a value v is (v - center) / scale,
+measured from `upper` rather than `center` for values above it with the 'iqr' method,
+values between `center` and `upper` scoring 0.
+*/
+type scorer struct {
+	center, upper, scale float64
+}
+
+func (s scorer) score(v float64) float64 {
+	switch {
+	case v > s.upper:
+		return (v - s.upper) / s.scale
+	case v < s.center:
+		return (v - s.center) / s.scale
+	}
+	return 0
+}
+
+//DetectOutliers
+/*
+Detects the outliers of every column in `columnNames` of the CSV file at `filePath` as described by `od`.
+A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The file is read once for the moments and quantiles of each column, once more for the median absolute deviations
+with the 'mad' method, and once to score every value. Recomputing the statistics reads the file again for each column.
+*/
+func DetectOutliers(columnNames []string, filePath string, opts Options, od OutlierOptions) (*Outliers, *time.Time, error) {
+	startTime := time.Now()
+
+	method := strings.ToLower(od.Method)
+	if method == "" {
+		method = OutliersZScore
+	}
+	threshold, ok := defaultThresholds[method]
+	if !ok {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("unknown method '%s', expected one of: %s, %s, %s",
+			od.Method, OutliersZScore, OutliersModifiedZScore, OutliersIQR)}
+	}
+	if od.Threshold != nil {
+		threshold = *od.Threshold
+	}
+	if threshold < 0 || isNaNOrInf(threshold) {
+		return nil, nil, &ParameterError{Message: "threshold must not be negative"}
+	}
+	limit := od.Limit
+	if limit == 0 {
+		limit = DefaultOutlierRows
+	}
+	if limit < 0 {
+		return nil, nil, &ParameterError{Message: "limit must not be negative"}
+	}
+	if len(columnNames) == 0 {
+		return nil, nil, &ParameterError{Message: "columns must be provided"}
+	}
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	rr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	scan := func(yield func(column int, v float64)) error {
+		return scanColumns(filePath, opts, columnIndexes, yield)
+	}
+
+	// the centre and scale of each column
+	var quantiles []float64
+	switch method {
+	case OutliersModifiedZScore:
+		quantiles = []float64{0.5}
+	case OutliersIQR:
+		quantiles = []float64{0.25, 0.75}
+	}
+	accumulators := make([]*accumulator, len(columnIndexes))
+	for i := range accumulators {
+		accumulators[i] = newAccumulator(0)
+		if len(quantiles) > 0 {
+			accumulators[i].limit = maxBufferedValues / len(accumulators)
+		}
+	}
+	if err := scan(func(column int, v float64) { accumulators[column].add(v) }); err != nil {
+		return nil, nil, err
+	}
+	quantile, err := resolveQuantiles(accumulators, quantiles, scan)
+	if err != nil {
+		return nil, nil, err
+	}
+	scorers := make([]scorer, len(accumulators))
+	for i, acc := range accumulators {
+		switch {
+		case acc.n == 0:
+		case method == OutliersZScore:
+			if acc.n > 1 {
+				scorers[i] = scorer{center: acc.mean, upper: acc.mean, scale: math.Sqrt(acc.sampleVariance())}
+			}
+		case method == OutliersIQR:
+			q1, q3 := quantile(i, 0.25), quantile(i, 0.75)
+			scorers[i] = scorer{center: q1, upper: q3, scale: q3 - q1}
+		case method == OutliersModifiedZScore:
+			median := quantile(i, 0.5)
+			scorers[i] = scorer{center: median, upper: median}
+		}
+	}
+	if method == OutliersModifiedZScore {
+		// the median absolute deviation is the median of the distances from the median
+		deviations := make([]*accumulator, len(accumulators))
+		for i := range deviations {
+			deviations[i] = newAccumulator(maxBufferedValues / len(deviations))
+		}
+		scanDeviations := func(yield func(column int, v float64)) error {
+			return scan(func(column int, v float64) { yield(column, math.Abs(v-scorers[column].center)) })
+		}
+		if err := scanDeviations(func(column int, v float64) { deviations[column].add(v) }); err != nil {
+			return nil, nil, err
+		}
+		mad, err := resolveQuantiles(deviations, []float64{0.5}, scanDeviations)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, d := range deviations {
+			if d.n > 0 {
+				scorers[i].scale = mad(i, 0.5) / 0.6745
+			}
+		}
+	}
+
+	result := &Outliers{Method: method, Threshold: threshold, Bounds: make(map[string]*OutlierBounds), Rows: []OutlierRow{}}
+	for i, s := range scorers {
+		b := &OutlierBounds{Center: s.center, Scale: s.scale}
+		if method == OutliersModifiedZScore {
+			b.Scale = s.scale * 0.6745
+		}
+		if s.scale > 0 {
+			b.Lower, b.Upper = float(s.center-threshold*s.scale), float(s.upper+threshold*s.scale)
+		}
+		result.Bounds[columnNames[i]] = b
+	}
+
+	// score every value
+	rr, err = OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	err = eachRecord(rr, func(record []string) error {
+		for i, index := range columnIndexes {
+			s := scorers[i]
+			if s.scale == 0 || index >= len(record) {
+				continue
+			}
+			v, ok := parseValue(record[index])
+			if !ok {
+				continue
+			}
+			score := s.score(v)
+			if math.Abs(score) <= threshold {
+				continue
+			}
+			result.Bounds[columnNames[i]].Flagged++
+			result.Total++
+			if len(result.Rows) < limit {
+				result.Rows = append(result.Rows, OutlierRow{
+					Line:   rr.Line(),
+					Column: columnNames[i],
+					Value:  v,
+					Score:  score,
+					Record: append([]string(nil), record...),
+				})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if od.Recompute {
+		result.Statistics = make(map[string]*Statistics, len(columnNames))
+		for _, name := range columnNames {
+			s, _, err := CalculateStatisticsN([]string{name}, filePath, withinBounds(opts, name, result.Bounds[name]))
+			if err != nil {
+				return nil, nil, err
+			}
+			result.Statistics[name] = s[name]
+		}
+	}
+	return result, &startTime, nil
+}
+
+//withinBounds
+/*
+Returns `opts` with its filter narrowed to the rows where the column `name` is within the bounds `b`,
+rows where it is missing or not a number are left out as well.
+*/
+func withinBounds(opts Options, name string, b *OutlierBounds) Options {
+	if b.Lower == nil {
+		return opts
+	}
+	column := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	where := fmt.Sprintf("%s >= %s AND %s <= %s", column, strconv.FormatFloat(*b.Lower, 'g', -1, 64),
+		column, strconv.FormatFloat(*b.Upper, 'g', -1, 64))
+	if opts.Where != "" {
+		where = fmt.Sprintf("(%s) AND %s", opts.Where, where)
+	}
+	opts.Where = where
+	return opts
+}
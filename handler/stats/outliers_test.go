@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"testing"
+)
+
+func TestOutlierThreshold(t *testing.T) {
+	path := writeCSV(t, t.TempDir(), "values.csv", "v", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10")
+	zero, half, negative := 0.0, 0.5, -1.0
+	for _, tc := range []struct {
+		threshold *float64
+		want      float64
+		flagged   int64
+	}{
+		{nil, 1.5, 0},
+		// quartiles 3.25 and 7.75, every value outside them is flagged
+		{&zero, 0, 6},
+		// the fences are 1 and 10, values between the quartiles are not scored from the lower one
+		{&half, 0.5, 0},
+	} {
+		o, _, err := DetectOutliers([]string{"v"}, path, Options{}, OutlierOptions{Method: OutliersIQR, Threshold: tc.threshold})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Threshold != tc.want || o.Total != tc.flagged {
+			t.Errorf("used threshold %v and flagged %d, want %v and %d", o.Threshold, o.Total, tc.want, tc.flagged)
+		}
+	}
+	_, _, err := DetectOutliers([]string{"v"}, path, Options{}, OutlierOptions{Threshold: &negative})
+	if _, ok := err.(*ParameterError); !ok {
+		t.Errorf("negative threshold: error %v, want a ParameterError", err)
+	}
+}
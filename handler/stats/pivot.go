@@ -0,0 +1,211 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxPivotColumns bounds the number of columns the values of the column keys of Pivot may spread into.
+const maxPivotColumns = 1000
+
+// PivotTotal names the column and the row holding the totals of a pivot table.
+const PivotTotal = "total"
+
+// pivotKeySeparator joins the values of several column keys into the name of a column of a pivot table.
+const pivotKeySeparator = " | "
+
+//PivotOptions
+/*
+The layout of Pivot: a row for each combination of the values of `Rows` and a column for each combination of the values
+of `Columns`, each cell holding `Aggregate` applied to `Value`, e.g. 'mean' of 'price', or 'count' of the rows when
+`Value` is empty. The aggregate functions are those of GroupBy, 'count' is used when `Aggregate` is empty.
+
+`RowTotals` adds a column holding the aggregate of each row over every column,
+and `ColumnTotals` a last row holding the aggregate of each column over every row.
+*/
+type PivotOptions struct {
+	Rows         []string
+	Columns      []string
+	Value        string
+	Aggregate    string
+	RowTotals    bool
+	ColumnTotals bool
+}
+
+//pivotKeys
+/*
+The distinct values of a set of key columns in the order they are first seen.
+*/
+type pivotKeys struct {
+	index map[string]bool
+	keys  [][]string
+}
+
+func (pk *pivotKeys) add(keys []string) bool {
+	key := strings.Join(keys, "\x00")
+	if pk.index[key] {
+		return false
+	}
+	pk.index[key] = true
+	pk.keys = append(pk.keys, append([]string(nil), keys...))
+	return true
+}
+
+// sort orders the keys by their values, compared numerically when they hold numbers.
+func (pk *pivotKeys) sort() {
+	sort.SliceStable(pk.keys, func(i, j int) bool {
+		for k := range pk.keys[i] {
+			if c := compareValues(pk.keys[i][k], pk.keys[j][k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+//Pivot
+/*
+Reads the CSV file at `filePath` into a wide `Table` described by `pivot` in a single pass:
+a column for each of the row keys followed by a column for each combination of the values of the column keys,
+named after them, and a column of totals when asked for.
+
+Rows and columns are ordered by their key values. A cell without any row is undefined,
+or zero when rows are counted so that counts read as a contingency table.
+The totals are aggregated from the rows themselves rather than from the cells, so medians and deviations stay exact.
+*/
+func Pivot(filePath string, opts Options, pivot PivotOptions) (*Table, *time.Time, error) {
+	startTime := time.Now()
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+
+	if len(pivot.Rows) == 0 || len(pivot.Columns) == 0 {
+		return nil, nil, &ParameterError{Message: "at least one row key and one column key must be provided"}
+	}
+	rowIndexes, err := rr.ColumnIndexes(pivot.Rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	columnIndexes, err := rr.ColumnIndexes(pivot.Columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	function := pivot.Aggregate
+	if function == "" {
+		function = AggregateCount
+	}
+	value := pivot.Value
+	if value == "" {
+		value = "*"
+	}
+	agg, err := parseAggregate(rr, fmt.Sprintf("%s(%s)", function, value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aggregates := []*aggregate{agg}
+	cells, rowTotals, columnTotals, total := newGrouper(aggregates), newGrouper(aggregates), newGrouper(aggregates), newGrouper(aggregates)
+	rowKeys := &pivotKeys{index: make(map[string]bool)}
+	columnKeys := &pivotKeys{index: make(map[string]bool)}
+	keyIndexes := append(append([]int(nil), rowIndexes...), columnIndexes...)
+	keys := make([]string, len(keyIndexes))
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		for i, index := range keyIndexes {
+			keys[i] = ""
+			if index < len(record) {
+				keys[i] = record[index]
+			}
+		}
+		rowKey, columnKey := keys[:len(rowIndexes)], keys[len(rowIndexes):]
+		rowKeys.add(rowKey)
+		if columnKeys.add(columnKey) && len(columnKeys.keys) > maxPivotColumns {
+			return nil, nil, &ParameterError{Message: fmt.Sprintf("the column keys have more than %d values", maxPivotColumns)}
+		}
+		g, err := cells.group(keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		cells.add(g, record)
+		if pivot.RowTotals {
+			if g, err = rowTotals.group(rowKey); err != nil {
+				return nil, nil, err
+			}
+			rowTotals.add(g, record)
+		}
+		if pivot.ColumnTotals {
+			if g, err = columnTotals.group(columnKey); err != nil {
+				return nil, nil, err
+			}
+			columnTotals.add(g, record)
+			if g, err = total.group(nil); err != nil {
+				return nil, nil, err
+			}
+			total.add(g, record)
+		}
+	}
+	rowKeys.sort()
+	columnKeys.sort()
+
+	// empty is the value of a cell without any row
+	var empty interface{}
+	if agg.function == AggregateCount {
+		empty = int64(0)
+	}
+	cell := func(gr *grouper, keys []string) interface{} {
+		if g, ok := gr.groups[strings.Join(keys, "\x00")]; ok {
+			return g.value(0, agg)
+		}
+		return empty
+	}
+
+	table := &Table{}
+	for _, index := range rowIndexes {
+		table.Columns = append(table.Columns, rr.Header()[index])
+	}
+	for _, ck := range columnKeys.keys {
+		table.Columns = append(table.Columns, strings.Join(ck, pivotKeySeparator))
+	}
+	if pivot.RowTotals {
+		table.Columns = append(table.Columns, PivotTotal)
+	}
+	for _, rk := range rowKeys.keys {
+		row := make([]interface{}, 0, len(table.Columns))
+		for _, k := range rk {
+			row = append(row, k)
+		}
+		for _, ck := range columnKeys.keys {
+			row = append(row, cell(cells, append(append([]string(nil), rk...), ck...)))
+		}
+		if pivot.RowTotals {
+			row = append(row, cell(rowTotals, rk))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	table.Total = len(table.Rows)
+	if pivot.ColumnTotals {
+		row := make([]interface{}, len(rowIndexes), len(table.Columns))
+		row[0] = PivotTotal
+		for _, ck := range columnKeys.keys {
+			row = append(row, cell(columnTotals, ck))
+		}
+		if pivot.RowTotals {
+			row = append(row, cell(total, nil))
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table, &startTime, nil
+}
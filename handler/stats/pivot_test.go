@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func pivotFile(t *testing.T) string {
+	t.Helper()
+	return writeCSV(t, t.TempDir(), "pivot.csv",
+		"payer,setting,code,price",
+		"B,in,x,30",
+		"A,out,x,20",
+		"A,in,y,10",
+		"A,in,x,50",
+		"C,out,y,")
+}
+
+func TestPivot(t *testing.T) {
+	path := pivotFile(t)
+	tests := []struct {
+		name    string
+		pivot   PivotOptions
+		columns string
+		rows    string
+	}{
+		{
+			// the cells without rows are undefined, as is the mean of C which has no price
+			name:    "mean with totals",
+			pivot:   PivotOptions{Rows: []string{"payer"}, Columns: []string{"setting"}, Value: "price", Aggregate: "mean", RowTotals: true, ColumnTotals: true},
+			columns: "[payer in out total]",
+			rows:    "[[A 30 20 26.666666666666664] [B 30 <nil> 30] [C <nil> <nil> <nil>] [total 30 20 27.5]]",
+		},
+		{
+			// a contingency table, the counts of the pairs without rows are zero
+			name:    "crosstab",
+			pivot:   PivotOptions{Rows: []string{"payer"}, Columns: []string{"setting"}, RowTotals: true, ColumnTotals: true},
+			columns: "[payer in out total]",
+			rows:    "[[A 2 1 3] [B 1 0 1] [C 0 1 1] [total 3 2 5]]",
+		},
+		{
+			// a sum without rows is undefined, over rows without a value it is zero
+			name:    "column totals only",
+			pivot:   PivotOptions{Rows: []string{"setting"}, Columns: []string{"payer"}, Value: "price", Aggregate: "sum", ColumnTotals: true},
+			columns: "[setting A B C]",
+			rows:    "[[in 60 30 <nil>] [out 20 <nil> 0] [total 80 30 0]]",
+		},
+		{
+			// several keys are joined into the name of a column, the total row is labelled in the first key only
+			name:    "several keys",
+			pivot:   PivotOptions{Rows: []string{"setting", "code"}, Columns: []string{"payer", "code"}, ColumnTotals: true},
+			columns: "[setting code A | x A | y B | x C | y]",
+			rows:    "[[in x 1 0 1 0] [in y 0 1 0 0] [out x 1 0 0 0] [out y 0 0 0 1] [total <nil> 2 1 1 1]]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			table, _, err := Pivot(path, Options{}, tc.pivot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(table.Columns); got != tc.columns {
+				t.Errorf("columns %s, want %s", got, tc.columns)
+			}
+			if got := fmt.Sprint(table.Rows); got != tc.rows {
+				t.Errorf("rows %s, want %s", got, tc.rows)
+			}
+		})
+	}
+}
+
+func TestPivotOptions(t *testing.T) {
+	path := pivotFile(t)
+	for _, pivot := range []PivotOptions{
+		{Rows: []string{"payer"}},
+		{Columns: []string{"payer"}},
+		{Rows: []string{"payer"}, Columns: []string{"setting"}, Value: "price", Aggregate: "mode"},
+	} {
+		var paramErr *ParameterError
+		if _, _, err := Pivot(path, Options{}, pivot); !errors.As(err, &paramErr) {
+			t.Errorf("%+v: error %v, want a ParameterError", pivot, err)
+		}
+	}
+	var notFound *ColumnNotFoundError
+	if _, _, err := Pivot(path, Options{}, PivotOptions{Rows: []string{"payer"}, Columns: []string{"plan"}}); !errors.As(err, &notFound) {
+		t.Errorf("error %v, want a ColumnNotFoundError", err)
+	}
+}
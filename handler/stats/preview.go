@@ -0,0 +1,210 @@
+package stats
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+// DefaultPreviewRows is the number of rows previewed when no limit is requested.
+const DefaultPreviewRows = 100
+
+// maxPreviewRows bounds the number of rows previewed at a time.
+const maxPreviewRows = 10000
+
+//PreviewOptions
+/*
+The page of rows to preview: `Limit` rows starting at the row `Offset`, or at `Cursor`,
+the NextCursor of the previous page, which continues from where that page ended without reading the rows before it.
+Only the columns in `Columns` are included, every column when it is empty.
+
+`Version` identifies the content of the file, cursors handed out for another version of the file are rejected.
+`Total` is the number of records of the file when it is already known, zero when it is not, e.g. for files uploaded
+before row counts were recorded, in which case the records are counted. It is ignored when rows are filtered.
+*/
+type PreviewOptions struct {
+	Columns []string
+	Offset  int64
+	Limit   int
+	Cursor  string
+	Version string
+	Total   int64
+}
+
+//Preview
+/*
+A page of the rows of a file, each keyed by column name with fields missing from the record set to null.
+Columns lists the columns in the order of the header, Offset is the index of the first row of the page
+and Total the number of rows, only counting those matching the filter of the preview.
+NextCursor continues with the next page and is left out on the last page.
+*/
+type Preview struct {
+	Columns    []string                 `json:"columns"`
+	Rows       []map[string]interface{} `json:"rows"`
+	Offset     int64                    `json:"offset"`
+	Total      int64                    `json:"total"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+//cursor
+/*
+The position a page ended at: the byte offset of the next record and the index of the next row,
+along with fingerprints of the version of the file and of the filter it was read with.
+*/
+type cursor struct {
+	Version string `json:"v"`
+	Where   uint32 `json:"w"`
+	Offset  int64  `json:"o"`
+	Row     int64  `json:"r"`
+}
+
+func fingerprint(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//decodeCursor
+/*
+Decodes a cursor, returning a `ParameterError` if it is malformed or was not handed out for the version of the file
+and the filter of `pv` and `opts`.
+*/
+func decodeCursor(s string, pv PreviewOptions, opts Options) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Offset < 0 || c.Row < 0 {
+		return c, &ParameterError{Message: "invalid cursor"}
+	}
+	if c.Version != pv.Version {
+		return c, &ParameterError{Message: "the cursor is for another version of the file"}
+	}
+	if c.Where != fingerprint(opts.Where) {
+		return c, &ParameterError{Message: "the cursor is for another where expression"}
+	}
+	return c, nil
+}
+
+//PreviewFile
+/*
+Reads a page of the rows of the CSV file at `filePath` as described by `pv`.
+A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The rows before the page are read and skipped when it starts at an offset, a cursor seeks straight to the page.
+When the rows are filtered, or the number of records is not known, the rest of the file is read to count them.
+*/
+func PreviewFile(filePath string, opts Options, pv PreviewOptions) (*Preview, *time.Time, error) {
+	startTime := time.Now()
+
+	limit := pv.Limit
+	if limit == 0 {
+		limit = DefaultPreviewRows
+	}
+	if limit < 0 || limit > maxPreviewRows {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("limit must be between 1 and %d", maxPreviewRows)}
+	}
+	if pv.Offset < 0 {
+		return nil, nil, &ParameterError{Message: "offset must not be negative"}
+	}
+	if pv.Cursor != "" && pv.Offset != 0 {
+		return nil, nil, &ParameterError{Message: "either an offset or a cursor can be given, not both"}
+	}
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	columns := pv.Columns
+	if len(columns) == 0 {
+		columns = rr.Header()
+	}
+	columnIndexes, err := rr.ColumnIndexes(columns)
+	if err != nil {
+		return nil, nil, err
+	}
+	preview := &Preview{Rows: []map[string]interface{}{}, Offset: pv.Offset}
+	for _, index := range columnIndexes {
+		preview.Columns = append(preview.Columns, rr.Header()[index])
+	}
+
+	if pv.Cursor != "" {
+		c, err := decodeCursor(pv.Cursor, pv, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := rr.SeekRecord(c.Offset); err != nil {
+			return nil, nil, err
+		}
+		preview.Offset = c.Row
+	}
+
+	// row is the index of the next row read, the cursor is where the page ended
+	row := preview.Offset
+	if pv.Cursor == "" {
+		row = 0
+	}
+	var next *cursor
+	for len(preview.Rows) < limit {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		row++
+		if row <= preview.Offset {
+			continue
+		}
+		values := make(map[string]interface{}, len(columnIndexes))
+		for i, index := range columnIndexes {
+			var v interface{}
+			if index < len(record) {
+				v = record[index]
+			}
+			values[preview.Columns[i]] = v
+		}
+		preview.Rows = append(preview.Rows, values)
+		if len(preview.Rows) == limit {
+			next = &cursor{Version: pv.Version, Where: fingerprint(opts.Where), Offset: rr.Offset(), Row: row}
+		}
+	}
+
+	if next != nil {
+		if opts.Where == "" && pv.Total > 0 {
+			row = pv.Total
+		} else if row, err = countRecords(rr, row); err != nil {
+			return nil, nil, err
+		}
+	}
+	preview.Total = row
+	if next != nil && next.Row < preview.Total {
+		preview.NextCursor = next.encode()
+	}
+	return preview, &startTime, nil
+}
+
+//countRecords
+/*
+Returns `n` plus the number of records left to read from `rr`.
+*/
+func countRecords(rr *RecordReader, n int64) (int64, error) {
+	for {
+		if _, err := rr.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return 0, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func previewFile(t *testing.T) string {
+	t.Helper()
+	return writeCSV(t, t.TempDir(), "data.csv", "id,name,price", "1,a,10", "2,b,20", "3,c,30", "4,d", "5,e,50")
+}
+
+// previewColumn returns the values of `column` in the rows of a page.
+func previewColumn(preview *Preview, column string) string {
+	var values []interface{}
+	for _, row := range preview.Rows {
+		values = append(values, row[column])
+	}
+	return fmt.Sprint(values)
+}
+
+func TestPreviewFilePages(t *testing.T) {
+	path := previewFile(t)
+	tests := []struct {
+		name  string
+		opts  Options
+		total int64
+		ids   string
+		count int64
+	}{
+		// files uploaded before row counts were recorded have a total of zero
+		{name: "unknown total", ids: "[1 2 3 4 5]", count: 5},
+		{name: "recorded total", total: 5, ids: "[1 2 3 4 5]", count: 5},
+		// the recorded total is not that of the filtered rows
+		{name: "filtered", opts: Options{Where: "id != 2"}, total: 5, ids: "[1 3 4 5]", count: 4},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pv := PreviewOptions{Limit: 2, Version: "v", Total: tc.total}
+			var ids []interface{}
+			for page := 0; ; page++ {
+				if page > 3 {
+					t.Fatal("more pages than expected")
+				}
+				preview, _, err := PreviewFile(path, tc.opts, pv)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if preview.Total != tc.count || preview.Offset != int64(2*page) {
+					t.Errorf("page %d at offset %d reports a total of %d, want %d", page, preview.Offset, preview.Total, tc.count)
+				}
+				for _, row := range preview.Rows {
+					ids = append(ids, row["id"])
+				}
+				if preview.NextCursor == "" {
+					break
+				}
+				pv.Cursor = preview.NextCursor
+			}
+			if fmt.Sprint(ids) != tc.ids {
+				t.Errorf("read rows %v, want %s", ids, tc.ids)
+			}
+		})
+	}
+}
+
+func TestPreviewFileOffset(t *testing.T) {
+	path := previewFile(t)
+	tests := []struct {
+		offset int64
+		limit  int
+		ids    string
+		next   bool
+	}{
+		{offset: 0, limit: 2, ids: "[1 2]", next: true},
+		{offset: 3, limit: 2, ids: "[4 5]"},
+		{offset: 3, limit: 10, ids: "[4 5]"},
+		{offset: 4, limit: 0, ids: "[5]"},
+		{offset: 9, limit: 2, ids: "[]"},
+	}
+	for _, tc := range tests {
+		preview, _, err := PreviewFile(path, Options{}, PreviewOptions{Offset: tc.offset, Limit: tc.limit, Version: "v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := previewColumn(preview, "id"); got != tc.ids || preview.Offset != tc.offset || preview.Total != 5 {
+			t.Errorf("offset %d and limit %d: rows %s at offset %d of %d, want %s", tc.offset, tc.limit, got, preview.Offset, preview.Total, tc.ids)
+		}
+		if (preview.NextCursor != "") != tc.next {
+			t.Errorf("offset %d and limit %d: next cursor %q", tc.offset, tc.limit, preview.NextCursor)
+		}
+	}
+
+	// the cursor of a page reached by offset continues after it
+	preview, _, err := PreviewFile(path, Options{}, PreviewOptions{Offset: 1, Limit: 2, Version: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	preview, _, err = PreviewFile(path, Options{}, PreviewOptions{Cursor: preview.NextCursor, Limit: 2, Version: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := previewColumn(preview, "id"); got != "[4 5]" || preview.Offset != 3 {
+		t.Errorf("rows %s at offset %d, want [4 5] at 3", got, preview.Offset)
+	}
+}
+
+func TestPreviewFileColumns(t *testing.T) {
+	path := previewFile(t)
+	preview, _, err := PreviewFile(path, Options{}, PreviewOptions{Columns: []string{"price", "ID"}, Offset: 2, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(preview.Columns) != "[price id]" || len(preview.Rows) != 2 || len(preview.Rows[0]) != 2 {
+		t.Fatalf("columns %v and rows %v", preview.Columns, preview.Rows)
+	}
+	// the price missing from the short row is null
+	if got := previewColumn(preview, "price"); got != "[30 <nil>]" {
+		t.Errorf("prices %s", got)
+	}
+	var notFound *ColumnNotFoundError
+	if _, _, err := PreviewFile(path, Options{}, PreviewOptions{Columns: []string{"id", "cost"}}); !errors.As(err, &notFound) {
+		t.Errorf("error %v, want a ColumnNotFoundError", err)
+	}
+}
+
+func TestPreviewFileCursors(t *testing.T) {
+	path := previewFile(t)
+	preview, _, err := PreviewFile(path, Options{Where: "price > 0"}, PreviewOptions{Limit: 1, Version: "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := preview.NextCursor
+	if _, _, err := PreviewFile(path, Options{Where: "price > 0"}, PreviewOptions{Cursor: cursor, Version: "v"}); err != nil {
+		t.Fatalf("cursor rejected: %v", err)
+	}
+	tests := []struct {
+		name string
+		opts Options
+		pv   PreviewOptions
+	}{
+		{name: "another version", opts: Options{Where: "price > 0"}, pv: PreviewOptions{Cursor: cursor, Version: "w"}},
+		{name: "another where expression", opts: Options{Where: "price > 10"}, pv: PreviewOptions{Cursor: cursor, Version: "v"}},
+		{name: "no where expression", pv: PreviewOptions{Cursor: cursor, Version: "v"}},
+		{name: "malformed", opts: Options{Where: "price > 0"}, pv: PreviewOptions{Cursor: cursor[1:], Version: "v"}},
+		{name: "with an offset", opts: Options{Where: "price > 0"}, pv: PreviewOptions{Cursor: cursor, Offset: 1, Version: "v"}},
+		{name: "negative offset", pv: PreviewOptions{Offset: -1}},
+		{name: "limit", pv: PreviewOptions{Limit: maxPreviewRows + 1}},
+	}
+	for _, tc := range tests {
+		var paramErr *ParameterError
+		if _, _, err := PreviewFile(path, tc.opts, tc.pv); !errors.As(err, &paramErr) {
+			t.Errorf("%s: error %v, want a ParameterError", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package stats
+
+import (
+	"api-3390/filter"
+	"errors"
+	"hash/maphash"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types a column can be inferred as by Profile.
+const (
+	TypeEmpty       = "empty"
+	TypeBoolean     = "boolean"
+	TypeInteger     = "integer"
+	TypeFloat       = "float"
+	TypeCurrency    = "currency"
+	TypeDate        = "date"
+	TypeCategorical = "categorical"
+	TypeText        = "text"
+)
+
+// inferredTypes are the types tried in order when inferring the type of a column, the first that fits is chosen.
+var inferredTypes = []string{TypeBoolean, TypeInteger, TypeFloat, TypeCurrency, TypeDate}
+
+// typeThreshold is the share of the values of a column that must fit a type for the column to be inferred as it,
+// the values that do not are counted as parse errors.
+const typeThreshold = 0.95
+
+// maxCategories is the largest number of distinct values a column of strings can have to be categorical.
+const maxCategories = 1000
+
+// DefaultTopValues is the number of most frequent values reported for each column when none is requested.
+const DefaultTopValues = 10
+
+// profileMetrics are the metrics of the numeric summary of a column.
+var profileMetrics = []string{MetricMin, MetricMax, MetricMean, MetricMedian, MetricStdDev, MetricQuartiles}
+
+//Profile
+/*
+A report on every column of a file along with the number of rows read, the number of columns in its header,
+the number of rows whose number of fields differs from the header and the total number of parse errors.
+*/
+type Profile struct {
+	RowCount    int64            `json:"row_count"`
+	ColumnCount int              `json:"column_count"`
+	RaggedRows  int64            `json:"ragged_rows"`
+	ParseErrors int64            `json:"parse_errors"`
+	Columns     []*ColumnProfile `json:"columns"`
+}
+
+//ColumnProfile
+/*
+The inferred type of a column, see Profile, and a summary of its values.
+
+Nulls counts the empty fields, and the fields missing from short rows, NullRate is their share of the rows.
+Distinct is the number of distinct values, estimated with a HyperLogLog sketch when DistinctApproximate is set.
+ParseErrors counts the values that do not fit the inferred type, those values are left out of the numeric summary.
+TopValues are the most frequent values with their share of the values that are not null.
+Numeric holds the min, max, mean, median, standard deviation and quartiles of numeric columns,
+Earliest and Latest the range of date columns.
+*/
+type ColumnProfile struct {
+	Name                string       `json:"name"`
+	Type                string       `json:"type"`
+	Count               int64        `json:"count"`
+	Nulls               int64        `json:"nulls"`
+	NullRate            float64      `json:"null_rate"`
+	Distinct            int64        `json:"distinct"`
+	DistinctApproximate bool         `json:"distinct_approximate,omitempty"`
+	ParseErrors         int64        `json:"parse_errors"`
+	TopValues           []ValueCount `json:"top_values"`
+	Numeric             *Statistics  `json:"numeric,omitempty"`
+	Earliest            *time.Time   `json:"earliest,omitempty"`
+	Latest              *time.Time   `json:"latest,omitempty"`
+}
+
+//columnProfiler
+/*
+Collects what is needed to profile a column in a single pass: how many values fit each type,
+the frequencies of its values, which are exact while there are few enough distinct values to count them all,
+a HyperLogLog sketch of its distinct values once there are not, and the range of its dates.
+*/
+type columnProfiler struct {
+	count       int64
+	fits        map[string]int64
+	frequencies *frequencies[string]
+	sketch      *hyperLogLog
+	earliest    time.Time
+	latest      time.Time
+}
+
+func newColumnProfiler(limit int) *columnProfiler {
+	return &columnProfiler{fits: make(map[string]int64), frequencies: newFrequencies[string](limit)}
+}
+
+func (c *columnProfiler) add(field string) {
+	c.count++
+	for _, t := range inferredTypes {
+		if t == TypeDate {
+			if d, ok := filter.ParseDate(field); ok {
+				if c.fits[t] == 0 || d.Before(c.earliest) {
+					c.earliest = d
+				}
+				if c.fits[t] == 0 || d.After(c.latest) {
+					c.latest = d
+				}
+				c.fits[t]++
+			}
+			continue
+		}
+		if _, ok := parseAs(t, field); ok {
+			c.fits[t]++
+		}
+	}
+	if c.sketch == nil && len(c.frequencies.counts) >= c.frequencies.limit {
+		if _, ok := c.frequencies.counts[field]; !ok {
+			// the counts are about to become estimates, until now they hold every distinct value seen
+			c.sketch = newHyperLogLog()
+			for v := range c.frequencies.counts {
+				c.sketch.add(v)
+			}
+		}
+	}
+	c.frequencies.add(field)
+	if c.sketch != nil {
+		c.sketch.add(field)
+	}
+}
+
+//distinct
+/*
+Returns the number of distinct values of the column and whether it is estimated.
+*/
+func (c *columnProfiler) distinct() (int64, bool) {
+	if c.sketch == nil {
+		return int64(len(c.frequencies.counts)), false
+	}
+	return c.sketch.estimate(), true
+}
+
+//inferType
+/*
+Returns the first of inferredTypes that at least <typeThreshold> of the values fit,
+otherwise 'categorical' when there are few distinct values, no more than half of the values, and 'text' when there are not.
+*/
+func (c *columnProfiler) inferType() string {
+	if c.count == 0 {
+		return TypeEmpty
+	}
+	for _, t := range inferredTypes {
+		if float64(c.fits[t]) >= typeThreshold*float64(c.count) {
+			return t
+		}
+	}
+	distinct, _ := c.distinct()
+	if distinct <= maxCategories && 2*distinct <= c.count {
+		return TypeCategorical
+	}
+	return TypeText
+}
+
+//parseAs
+/*
+Parses a field as a value of the type `t`, booleans are parsed as 1 for true and 0 for false.
+*/
+func parseAs(t string, field string) (float64, bool) {
+	field = strings.TrimSpace(field)
+	switch t {
+	case TypeBoolean:
+		switch strings.ToLower(field) {
+		case "true", "t", "yes", "y":
+			return 1, true
+		case "false", "f", "no", "n":
+			return 0, true
+		}
+	case TypeInteger:
+		if v, err := strconv.ParseInt(field, 10, 64); err == nil {
+			return float64(v), true
+		}
+	case TypeFloat:
+		return parseValue(field)
+	case TypeCurrency:
+		return parseCurrency(field)
+	}
+	return 0, false
+}
+
+//parseCurrency
+/*
+Parses an amount of money such as '$1,234.50', '-€12', '12.00 £' or '(1,000)', negative in accounting notation.
+Numbers without a currency symbol are accepted as well, commas must group the thousands.
+*/
+func parseCurrency(field string) (float64, bool) {
+	negative := false
+	if strings.HasPrefix(field, "(") && strings.HasSuffix(field, ")") {
+		negative, field = true, field[1:len(field)-1]
+	}
+	if strings.HasPrefix(field, "-") {
+		negative, field = !negative, field[1:]
+	}
+	for _, symbol := range []string{"$", "€", "£", "¥"} {
+		if s, ok := strings.CutPrefix(field, symbol); ok {
+			field = s
+			break
+		}
+		if s, ok := strings.CutSuffix(field, symbol); ok {
+			field = s
+			break
+		}
+	}
+	field = strings.TrimSpace(field)
+	if strings.HasPrefix(field, "-") && !negative {
+		negative, field = true, field[1:]
+	}
+	whole, fraction, hasFraction := strings.Cut(field, ".")
+	if strings.Contains(whole, ",") {
+		groups := strings.Split(whole, ",")
+		if len(groups[0]) == 0 || len(groups[0]) > 3 {
+			return 0, false
+		}
+		for _, g := range groups[1:] {
+			if len(g) != 3 {
+				return 0, false
+			}
+		}
+		whole = strings.Join(groups, "")
+	}
+	if whole == "" || strings.ContainsAny(whole, "+-") {
+		return 0, false
+	}
+	for _, r := range whole + fraction {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	if hasFraction {
+		whole += "." + fraction
+	}
+	v, err := strconv.ParseFloat(whole, 64)
+	if err != nil {
+		return 0, false
+	}
+	if negative {
+		v = -v
+	}
+	return v, true
+}
+
+//ProfileFile
+/*
+Profiles the columns in `columnNames` of the CSV file at `filePath`, or every column when it is empty,
+reporting the `top` most frequent values of each, DefaultTopValues when it is zero.
+A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The type of each column is inferred from its values in a first pass over the file.
+A second pass summarises the numeric columns and counts the most frequent values exactly
+when a column had too many distinct values to count them all in the first,
+the file is read again for the quantiles of numeric columns with too many values to keep, see resolveQuantiles.
+*/
+func ProfileFile(columnNames []string, filePath string, opts Options, top int) (*Profile, *time.Time, error) {
+	startTime := time.Now()
+
+	if top == 0 {
+		top = DefaultTopValues
+	}
+	if top < 0 {
+		return nil, nil, &ParameterError{Message: "top must not be negative"}
+	}
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	header := rr.Header()
+	if len(columnNames) == 0 {
+		columnNames = header
+	}
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the values counted are shared out between the columns, but every column can count enough to find its categories
+	limit := max(maxTrackedValues/len(columnIndexes), 4*maxCategories)
+	profilers := make([]*columnProfiler, len(columnIndexes))
+	for i := range profilers {
+		profilers[i] = newColumnProfiler(limit)
+	}
+	profile := &Profile{ColumnCount: len(header)}
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		profile.RowCount++
+		if len(record) != len(header) {
+			profile.RaggedRows++
+		}
+		for i, index := range columnIndexes {
+			if index < len(record) && strings.TrimSpace(record[index]) != "" {
+				profilers[i].add(record[index])
+			}
+		}
+	}
+
+	types := make([]string, len(profilers))
+	numeric := false
+	recount := false
+	for i, c := range profilers {
+		types[i] = c.inferType()
+		switch types[i] {
+		case TypeBoolean, TypeInteger, TypeFloat, TypeCurrency:
+			numeric = true
+		}
+		if c.frequencies.approximate {
+			c.frequencies.reset()
+			recount = true
+		}
+	}
+
+	// summarise the numeric columns and count the candidate top values exactly
+	ms, err := newMetricSet(Options{Metrics: profileMetrics})
+	if err != nil {
+		return nil, nil, err
+	}
+	accumulators := make([]*accumulator, len(profilers))
+	for i := range accumulators {
+		accumulators[i] = newAccumulator(maxBufferedValues / len(accumulators))
+	}
+	// scan yields the values of the numeric columns parsed as their type
+	scan := func(yield func(column int, v float64)) error {
+		rr, err := OpenRecords(filePath, opts)
+		if err != nil {
+			return err
+		}
+		defer rr.Close()
+		return readFields(rr, columnIndexes, func(column int, field string) error {
+			if v, ok := parseAs(types[column], field); ok {
+				yield(column, v)
+			}
+			return nil
+		})
+	}
+	if numeric || recount {
+		rr, err := OpenRecords(filePath, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = readFields(rr, columnIndexes, func(column int, field string) error {
+			if strings.TrimSpace(field) == "" {
+				return nil
+			}
+			if v, ok := parseAs(types[column], field); ok {
+				accumulators[column].add(v)
+			}
+			if profilers[column].frequencies.approximate {
+				profilers[column].frequencies.count(field)
+			}
+			return nil
+		})
+		rr.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	quantile, err := resolveQuantiles(accumulators, ms.quantiles(), scan)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, c := range profilers {
+		p := &ColumnProfile{
+			Name:  header[columnIndexes[i]],
+			Type:  types[i],
+			Count: c.count,
+			Nulls: profile.RowCount - c.count,
+		}
+		if profile.RowCount > 0 {
+			p.NullRate = float64(p.Nulls) / float64(profile.RowCount)
+		}
+		p.Distinct, p.DistinctApproximate = c.distinct()
+		p.TopValues, _ = valueCounts(c.frequencies.counts, c.count, top)
+		switch p.Type {
+		case TypeBoolean, TypeInteger, TypeFloat, TypeCurrency:
+			p.ParseErrors = c.count - c.fits[p.Type]
+			p.Numeric = ms.statistics(accumulators[i], func(q float64) float64 { return quantile(i, q) })
+		case TypeDate:
+			p.ParseErrors = c.count - c.fits[p.Type]
+			p.Earliest, p.Latest = &c.earliest, &c.latest
+		}
+		profile.ParseErrors += p.ParseErrors
+		profile.Columns = append(profile.Columns, p)
+	}
+	return profile, &startTime, nil
+}
+
+// hllPrecision is the number of bits of a hash that pick the register of a HyperLogLog sketch.
+const hllPrecision = 14
+
+// hashSeed seeds the hashes of the values added to HyperLogLog sketches.
+var hashSeed = maphash.MakeSeed()
+
+//hyperLogLog
+/*
+Estimates the number of distinct strings added to it in a fixed 16KiB with a standard error of about 0.8%.
+*/
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(s string) {
+	x := maphash.String(hashSeed, s)
+	i := x >> (64 - hllPrecision)
+	// the position of the first set bit among the remaining bits, bounded so it is found
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[i] {
+		h.registers[i] = rank
+	}
+}
+
+//estimate
+/*
+Returns the estimated number of distinct strings, by linear counting while many registers are still empty.
+*/
+func (h *hyperLogLog) estimate() int64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(e))
+}
@@ -0,0 +1,190 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseCurrency(t *testing.T) {
+	tests := []struct {
+		field string
+		want  float64
+		ok    bool
+	}{
+		{"$1,234.50", 1234.5, true},
+		{"-€12", -12, true},
+		{"€-12", -12, true},
+		{"12.00 £", 12, true},
+		{"¥1,000,000", 1000000, true},
+		{"42", 42, true},
+		{".5", 0, false},
+		// accounting notation
+		{"(1,000)", -1000, true},
+		{"($12.34)", -12.34, true},
+		{"(-5)", 5, true},
+		// commas must group the thousands
+		{"1,23", 0, false},
+		{"1234,567", 0, false},
+		{",123", 0, false},
+		{"$", 0, false},
+		{"$+5", 0, false},
+		{"1.2.3", 0, false},
+		{"1e3", 0, false},
+		{"12 USD", 0, false},
+		{"(12", 0, false},
+	}
+	for _, tc := range tests {
+		if got, ok := parseCurrency(tc.field); got != tc.want || ok != tc.ok {
+			t.Errorf("parseCurrency(%q) = %v, %v, want %v, %v", tc.field, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestInferType(t *testing.T) {
+	// repeat returns `n` copies of the values in turn
+	repeat := func(n int, values ...string) []string {
+		fields := make([]string, n)
+		for i := range fields {
+			fields[i] = values[i%len(values)]
+		}
+		return fields
+	}
+	distinct := func(n int) []string {
+		fields := make([]string, n)
+		for i := range fields {
+			fields[i] = fmt.Sprintf("value %d", i)
+		}
+		return fields
+	}
+	tests := []struct {
+		name   string
+		fields []string
+		want   string
+	}{
+		{"empty", nil, TypeEmpty},
+		{"boolean", repeat(20, "Yes", "n", "TRUE", "f"), TypeBoolean},
+		// 19 of 20 values are at the threshold, 18 are below it
+		{"integer at threshold", append(repeat(19, "1", "-20"), "x"), TypeInteger},
+		{"integer below threshold", append(repeat(18, "1", "-20"), "x", "y"), TypeCategorical},
+		// integers fit the later types as well
+		{"float", append(repeat(18, "1", "2"), "2.5", "-0.5"), TypeFloat},
+		{"currency", repeat(20, "$1,000", "(3.50)", "12"), TypeCurrency},
+		{"date", repeat(20, "2024-01-31", "2024-02-01T10:00:00Z"), TypeDate},
+		// half of the values distinct is still categorical
+		{"categorical", append(distinct(10), distinct(10)...), TypeCategorical},
+		{"text", append(distinct(11), distinct(9)...), TypeText},
+		{"many categories", append(distinct(maxCategories+1), distinct(maxCategories+1)...), TypeText},
+	}
+	for _, tc := range tests {
+		c := newColumnProfiler(maxTrackedValues)
+		for _, f := range tc.fields {
+			c.add(f)
+		}
+		if got := c.inferType(); got != tc.want {
+			t.Errorf("%s: inferred %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{100, 5000, 200000} {
+		h := newHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprint(i))
+			// repeated values are not counted again
+			h.add(fmt.Sprint(i / 2))
+		}
+		// about five times the standard error
+		if got := h.estimate(); float64(got) < 0.96*float64(n) || float64(got) > 1.04*float64(n) {
+			t.Errorf("%d distinct values estimated as %d", n, got)
+		}
+	}
+
+	// a profiler switches to a sketch once it sees more distinct values than it counts
+	c := newColumnProfiler(100)
+	for i := 0; i < 3000; i++ {
+		c.add(fmt.Sprint(i % 1500))
+	}
+	if n, approximate := c.distinct(); !approximate || n < 1440 || n > 1560 {
+		t.Errorf("1500 distinct values estimated as %d, approximate %v", n, approximate)
+	}
+}
+
+func TestProfileFile(t *testing.T) {
+	lines := []string{"flag,n,x,price,day,kind,note,blank"}
+	for i := 0; i < 20; i++ {
+		flag := []string{"yes", "no"}[i%2]
+		if i == 0 {
+			flag = ""
+		}
+		n := fmt.Sprint(i)
+		if i == 5 {
+			n = "five"
+		}
+		lines = append(lines, fmt.Sprintf("%s,%s,%v,\"$%d,000.25\",2024-01-%02d,%s,note %d,",
+			flag, n, float64(i)+0.5, i+1, 20-i, []string{"a", "b", "c", "a"}[i%4], i))
+	}
+	// a short row, its missing fields are nulls
+	lines = append(lines, "no,20,20.5")
+	path := writeCSV(t, t.TempDir(), "profile.csv", lines...)
+
+	p, _, err := ProfileFile(nil, path, Options{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RowCount != 21 || p.ColumnCount != 8 || p.RaggedRows != 1 || p.ParseErrors != 1 || len(p.Columns) != 8 {
+		t.Fatalf("%d rows, %d columns, %d ragged and %d parse errors over %d columns profiled",
+			p.RowCount, p.ColumnCount, p.RaggedRows, p.ParseErrors, len(p.Columns))
+	}
+	tests := []struct {
+		typ         string
+		nulls       int64
+		distinct    int64
+		parseErrors int64
+		top         string
+		min, max    float64
+	}{
+		{TypeBoolean, 1, 2, 0, "[{no 11 0.55} {yes 9 0.45}]", 0, 1},
+		{TypeInteger, 0, 21, 1, "[{0 1 0.047619047619047616} {1 1 0.047619047619047616}]", 0, 20},
+		{TypeFloat, 0, 21, 0, "[{0.5 1 0.047619047619047616} {1.5 1 0.047619047619047616}]", 0.5, 20.5},
+		{TypeCurrency, 1, 20, 0, "[{$1,000.25 1 0.05} {$10,000.25 1 0.05}]", 1000.25, 20000.25},
+		{TypeDate, 1, 20, 0, "[{2024-01-01 1 0.05} {2024-01-02 1 0.05}]", 0, 0},
+		{TypeCategorical, 1, 3, 0, "[{a 10 0.5} {b 5 0.25}]", 0, 0},
+		{TypeText, 1, 20, 0, "[{note 0 1 0.05} {note 1 1 0.05}]", 0, 0},
+		{TypeEmpty, 21, 0, 0, "[]", 0, 0},
+	}
+	for i, tc := range tests {
+		c := p.Columns[i]
+		if c.Type != tc.typ || c.Nulls != tc.nulls || c.Count != p.RowCount-tc.nulls || c.NullRate != float64(tc.nulls)/21 {
+			t.Errorf("%s: %s with %d of %d null at a rate of %v, want %s with %d null",
+				c.Name, c.Type, c.Nulls, c.Count, c.NullRate, tc.typ, tc.nulls)
+		}
+		if c.Distinct != tc.distinct || c.DistinctApproximate || c.ParseErrors != tc.parseErrors || fmt.Sprint(c.TopValues) != tc.top {
+			t.Errorf("%s: %d distinct, %d parse errors and top values %v, want %d, %d and %s",
+				c.Name, c.Distinct, c.ParseErrors, c.TopValues, tc.distinct, tc.parseErrors, tc.top)
+		}
+		if numeric := c.Numeric != nil; numeric != (tc.min != tc.max) {
+			t.Errorf("%s: numeric summary %+v", c.Name, c.Numeric)
+		} else if numeric && (*c.Numeric.Min != tc.min || *c.Numeric.Max != tc.max) {
+			t.Errorf("%s: from %v to %v, want %v to %v", c.Name, *c.Numeric.Min, *c.Numeric.Max, tc.min, tc.max)
+		}
+	}
+	// the value that is not an integer is left out of the summary
+	if mean := *p.Columns[1].Numeric.Mean; mean != 10.25 {
+		t.Errorf("integers with a mean of %v", mean)
+	}
+	day := p.Columns[4]
+	if day.Earliest == nil || !day.Earliest.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) ||
+		!day.Latest.Equal(time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("dates from %v to %v", day.Earliest, day.Latest)
+	}
+
+	p, _, err = ProfileFile([]string{"kind"}, path, Options{Where: "n < 4"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RowCount != 4 || len(p.Columns) != 1 || fmt.Sprint(p.Columns[0].TopValues) != "[{a 2 0.5} {b 1 0.25} {c 1 0.25}]" {
+		t.Errorf("%d rows filtered with %v", p.RowCount, p.Columns[0].TopValues)
+	}
+}
@@ -0,0 +1,274 @@
+package stats
+
+import (
+	"api-3390/csvutil"
+	"api-3390/filter"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Options changes how a file is read and what is calculated by the functions of this package.
+type Options struct {
+	// Delimiter separates the fields of a record, a comma is used when it is zero.
+	Delimiter rune
+	// Metrics are the names of the metrics to calculate, DefaultMetrics are calculated when it is empty.
+	Metrics []string
+	// Percentiles, in [0, 100], are reported when the 'percentiles' metric is calculated.
+	Percentiles []float64
+	// Approximate estimates quantiles from a t-digest built in a single pass instead of finding them exactly.
+	Approximate bool
+	// Where is an expression records must match to be read, see filter.Filter, every record is read when it is empty.
+	Where string
+	// Monitor is told of every read of the file when it is set, see Monitor.
+	Monitor Monitor
+	// Workers is the number of goroutines parsing the file in CalculateStatistics, runtime.GOMAXPROCS(0) when it is zero.
+	Workers int
+}
+
+//Monitor
+/*
+Observes the reading of a file: `Read` is called with the number of bytes read each time the file is read from,
+and an error returned by it ends the read, e.g. when the operation reading the file is cancelled.
+It may be called concurrently when parts of the file are read in parallel, see CalculateStatistics.
+*/
+type Monitor interface {
+	Read(n int) error
+}
+
+type monitoredReader struct {
+	reader  io.Reader
+	monitor Monitor
+}
+
+func (mr *monitoredReader) Read(p []byte) (int, error) {
+	n, err := mr.reader.Read(p)
+	if monitorErr := mr.monitor.Read(n); monitorErr != nil {
+		return n, monitorErr
+	}
+	return n, err
+}
+
+//RecordReader
+/*
+Iterates over the records of a CSV file one at a time so a file never has to be held in memory,
+the header row is read when the reader is opened.
+The slice returned by Next is reused between calls, fields must be copied to be kept beyond the next call.
+Records that do not match the filter of the reader are skipped.
+*/
+type RecordReader struct {
+	file      *os.File
+	monitor   Monitor
+	reader    *csv.Reader
+	delimiter rune
+	base      int64
+	header    []string
+	filter    *filter.Filter
+}
+
+//OpenRecords
+/*
+Opens the CSV file at `filePath` and reads its header row,
+the 'Where' expression of `opts` is compiled against the header and a `*filter.Error` is returned if it is invalid.
+*/
+func OpenRecords(filePath string, opts Options) (*RecordReader, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	rr := &RecordReader{
+		file:      file,
+		monitor:   opts.Monitor,
+		delimiter: opts.Delimiter,
+	}
+	rr.reader = csvutil.NewReader(rr.source(), opts.Delimiter)
+	header, err := rr.reader.Read()
+	if err != nil {
+		file.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, err
+	}
+	rr.header = append([]string(nil), header...)
+	if opts.Where != "" {
+		if rr.filter, err = filter.Compile(opts.Where, rr.header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return rr, nil
+}
+
+func (rr *RecordReader) Header() []string {
+	return rr.header
+}
+
+//ColumnIndex
+/*
+Returns the index of the column `name` in the header, compared case-insensitively, or -1 if there is no such column.
+*/
+func (rr *RecordReader) ColumnIndex(name string) int {
+	for i, header := range rr.header {
+		if strings.EqualFold(header, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+//ColumnIndexes
+/*
+Returns the index of each column in `names`, or a `ColumnNotFoundError` listing every name that is not in the header.
+*/
+func (rr *RecordReader) ColumnIndexes(names []string) ([]int, error) {
+	indexes := make([]int, len(names))
+	var missing []string
+	for i, name := range names {
+		indexes[i] = rr.ColumnIndex(name)
+		if indexes[i] == -1 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &ColumnNotFoundError{Columns: missing}
+	}
+	return indexes, nil
+}
+
+// ColumnNotFoundError is returned when columns are requested that are not in the header of a file.
+type ColumnNotFoundError struct {
+	Columns []string
+}
+
+func (e *ColumnNotFoundError) Error() string {
+	return fmt.Sprintf("column not found: %s", strings.Join(e.Columns, ", "))
+}
+
+//Next
+/*
+Returns the next record of the file matching the filter, or io.EOF once every record has been read.
+*/
+func (rr *RecordReader) Next() ([]string, error) {
+	for {
+		record, err := rr.reader.Read()
+		if err != nil || rr.filter == nil || rr.filter.Match(record) {
+			return record, err
+		}
+	}
+}
+
+//Line
+/*
+Returns the line of the file the record last returned by Next starts on, the header is on line 1.
+*/
+func (rr *RecordReader) Line() int {
+	line, _ := rr.reader.FieldPos(0)
+	return line
+}
+
+//Offset
+/*
+Returns the byte offset of the file the next record is read from.
+*/
+func (rr *RecordReader) Offset() int64 {
+	return rr.base + rr.reader.InputOffset()
+}
+
+//SeekRecord
+/*
+Moves the reader to the byte `offset` of the file, which must be the start of a record such as one returned by Offset.
+Lines returned by Line are counted from the offset afterwards.
+*/
+func (rr *RecordReader) SeekRecord(offset int64) error {
+	if _, err := rr.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	rr.reader = csvutil.NewReader(rr.source(), rr.delimiter)
+	rr.base = offset
+	return nil
+}
+
+//rangeReader
+/*
+Returns a reader of the records of the file of `rr` from the byte offset `start`, which must be the start of a record,
+up to `end`. The reader shares the file of `rr` but reads it independently, with a filter of its own,
+so any number of ranges can be read concurrently. It must not be closed or seeked.
+*/
+func (rr *RecordReader) rangeReader(start, end int64, opts Options) (*RecordReader, error) {
+	var source io.Reader = io.NewSectionReader(rr.file, start, end-start)
+	if rr.monitor != nil {
+		source = &monitoredReader{reader: source, monitor: rr.monitor}
+	}
+	chunk := &RecordReader{
+		reader:    csvutil.NewReader(source, rr.delimiter),
+		delimiter: rr.delimiter,
+		base:      start,
+		header:    rr.header,
+	}
+	if opts.Where != "" {
+		var err error
+		if chunk.filter, err = filter.Compile(opts.Where, rr.header); err != nil {
+			return nil, err
+		}
+	}
+	return chunk, nil
+}
+
+// source returns the file read by the reader, observed by its monitor if it has one.
+func (rr *RecordReader) source() io.Reader {
+	if rr.monitor == nil {
+		return rr.file
+	}
+	return &monitoredReader{reader: rr.file, monitor: rr.monitor}
+}
+
+func (rr *RecordReader) Close() error {
+	return rr.file.Close()
+}
+
+//parseValue
+/*
+Parses a field as a float, fields that are not numbers or are not finite are rejected.
+*/
+func parseValue(field string) (float64, bool) {
+	v, err := strconv.ParseFloat(field, 64)
+	if err != nil || isNaNOrInf(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+//scanColumns
+/*
+Reads the file at `filePath` from the start and calls `yield` with every numeric value of the columns at `columns`,
+along with the position of the column in `columns`.
+*/
+func scanColumns(filePath string, opts Options, columns []int, yield func(column int, v float64)) error {
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for i, index := range columns {
+			if index >= len(record) {
+				continue
+			}
+			if v, ok := parseValue(record[index]); ok {
+				yield(i, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxLevels bounds the number of distinct values of a categorical predictor of a regression.
+const maxLevels = 1000
+
+// InterceptName is the name the intercept of a regression is reported under.
+const InterceptName = "(intercept)"
+
+//RegressionOptions
+/*
+The model fitted by Regress: the response `Response` is regressed on the numeric predictors `Predictors`
+and the categorical predictors `Categorical`, with an intercept unless `NoIntercept` is set.
+*/
+type RegressionOptions struct {
+	Response    string
+	Predictors  []string
+	Categorical []string
+	NoIntercept bool
+}
+
+//Regression
+/*
+An ordinary least squares fit.
+
+Each categorical predictor is one-hot encoded, with a coefficient named 'column[value]' for every value
+except the first in sorted order, which is the reference level listed in ReferenceLevels.
+Fit statistics are null when they are undefined, such as standard errors when there are no residual degrees of freedom.
+Without an intercept R² is measured around zero instead of the mean of the response.
+*/
+type Regression struct {
+	Response         string            `json:"response"`
+	Coefficients     []Coefficient     `json:"coefficients"`
+	ReferenceLevels  map[string]string `json:"reference_levels,omitempty"`
+	N                int64             `json:"n"`
+	Missing          int64             `json:"missing"`
+	ResidualDF       int64             `json:"residual_df"`
+	RSquared         *float64          `json:"r_squared"`
+	AdjustedRSquared *float64          `json:"adjusted_r_squared"`
+	ResidualStdError *float64          `json:"residual_std_error"`
+	FStatistic       *float64          `json:"f_statistic"`
+	FPValue          *float64          `json:"f_p_value"`
+	Residuals        *ResidualSummary  `json:"residuals"`
+}
+
+// Coefficient is the estimate of a term of a regression along with its standard error, t statistic and two-sided p-value.
+type Coefficient struct {
+	Name       string   `json:"name"`
+	Estimate   float64  `json:"estimate"`
+	StdError   *float64 `json:"std_error"`
+	TStatistic *float64 `json:"t_statistic"`
+	PValue     *float64 `json:"p_value"`
+}
+
+// ResidualSummary holds the quantiles of the residuals of a regression, their mean is zero when there is an intercept.
+type ResidualSummary struct {
+	Min    float64 `json:"min"`
+	Q1     float64 `json:"q1"`
+	Median float64 `json:"median"`
+	Q3     float64 `json:"q3"`
+	Max    float64 `json:"max"`
+}
+
+//design
+/*
+Builds the rows of the design matrix of a regression from the records of a file.
+*/
+type design struct {
+	response    int
+	predictors  []int
+	categorical []int
+	levels      [][]string
+	intercept   bool
+	names       []string
+}
+
+func field(record []string, i int) string {
+	if i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+//complete
+/*
+Reports whether the model can be fitted to `record`: the response and the numeric predictors are numbers
+and the categorical predictors are not empty.
+*/
+func (d *design) complete(record []string) bool {
+	for _, index := range append([]int{d.response}, d.predictors...) {
+		if _, ok := parseValue(field(record, index)); !ok {
+			return false
+		}
+	}
+	for _, index := range d.categorical {
+		if strings.TrimSpace(field(record, index)) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+//row
+/*
+Fills `x` with the terms of `record` and returns its response, or false when the record is not complete.
+*/
+func (d *design) row(record []string, x []float64) (float64, bool) {
+	if !d.complete(record) {
+		return 0, false
+	}
+	y, _ := parseValue(record[d.response])
+	k := 0
+	if d.intercept {
+		x[k] = 1
+		k++
+	}
+	for _, index := range d.predictors {
+		x[k], _ = parseValue(record[index])
+		k++
+	}
+	for c, index := range d.categorical {
+		value := record[index]
+		levels := d.levels[c]
+		for i := 1; i < len(levels); i++ {
+			x[k] = 0
+			if levels[i] == value {
+				x[k] = 1
+			}
+			k++
+		}
+	}
+	return y, true
+}
+
+//leastSquares
+/*
+An incremental QR decomposition of the design matrix: each row is rotated into the upper triangular `r` with Givens
+rotations, along with its response into `qty`, and what is left of the response is the residual of the row.
+Only p×p values are kept however many rows are added.
+*/
+type leastSquares struct {
+	r   [][]float64
+	qty []float64
+	sse float64
+}
+
+func newLeastSquares(p int) *leastSquares {
+	r := make([][]float64, p)
+	for i := range r {
+		r[i] = make([]float64, p)
+	}
+	return &leastSquares{r: r, qty: make([]float64, p)}
+}
+
+// add rotates the row `x` with response `y` into the decomposition, `x` is overwritten.
+func (ls *leastSquares) add(x []float64, y float64) {
+	for i := range x {
+		if x[i] == 0 {
+			continue
+		}
+		h := math.Hypot(ls.r[i][i], x[i])
+		c, s := ls.r[i][i]/h, x[i]/h
+		ls.r[i][i] = h
+		for j := i + 1; j < len(x); j++ {
+			a, b := ls.r[i][j], x[j]
+			ls.r[i][j], x[j] = c*a+s*b, c*b-s*a
+		}
+		a := ls.qty[i]
+		ls.qty[i], y = c*a+s*y, c*y-s*a
+	}
+	ls.sse += y * y
+}
+
+//solve
+/*
+Returns the coefficients and the diagonal of (XᵀX)⁻¹, or the index of the first term that is a linear combination
+of the terms before it.
+*/
+func (ls *leastSquares) solve() ([]float64, []float64, int) {
+	p := len(ls.qty)
+	scale := 0.0
+	for i := 0; i < p; i++ {
+		scale = math.Max(scale, math.Abs(ls.r[i][i]))
+	}
+	for i := 0; i < p; i++ {
+		if math.Abs(ls.r[i][i]) <= 1e-10*scale || scale == 0 {
+			return nil, nil, i
+		}
+	}
+	beta := make([]float64, p)
+	for i := p - 1; i >= 0; i-- {
+		sum := ls.qty[i]
+		for j := i + 1; j < p; j++ {
+			sum -= ls.r[i][j] * beta[j]
+		}
+		beta[i] = sum / ls.r[i][i]
+	}
+	// (XᵀX)⁻¹ = R⁻¹R⁻ᵀ, so its diagonal holds the sums of squares of the rows of R⁻¹
+	inverse := make([][]float64, p)
+	for i := range inverse {
+		inverse[i] = make([]float64, p)
+	}
+	for j := 0; j < p; j++ {
+		inverse[j][j] = 1 / ls.r[j][j]
+		for i := j - 1; i >= 0; i-- {
+			sum := 0.0
+			for k := i + 1; k <= j; k++ {
+				sum += ls.r[i][k] * inverse[k][j]
+			}
+			inverse[i][j] = -sum / ls.r[i][i]
+		}
+	}
+	diagonal := make([]float64, p)
+	for i := 0; i < p; i++ {
+		for j := i; j < p; j++ {
+			diagonal[i] += inverse[i][j] * inverse[i][j]
+		}
+	}
+	return beta, diagonal, -1
+}
+
+//Regress
+/*
+Fits the model of `reg` to the CSV file at `filePath` by ordinary least squares, using the rows where the response
+and every predictor are present. A `ColumnNotFoundError` is returned if any of the columns is not in the file,
+and a `ParameterError` if the predictors are collinear.
+
+The file is read once to find the levels of the categorical predictors, once to fit the model with an incremental
+QR decomposition, which is numerically stable and holds no rows in memory, and once more for the residual quantiles,
+more passes being needed when there are too many residuals to keep, see resolveQuantiles.
+*/
+func Regress(filePath string, opts Options, reg RegressionOptions) (*Regression, *time.Time, error) {
+	startTime := time.Now()
+
+	if reg.Response == "" {
+		return nil, nil, &ParameterError{Message: "the response must be provided"}
+	}
+	if len(reg.Predictors) == 0 && len(reg.Categorical) == 0 && reg.NoIntercept {
+		return nil, nil, &ParameterError{Message: "at least one predictor or the intercept must be included"}
+	}
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	columns, err := rr.ColumnIndexes(append(append([]string{reg.Response}, reg.Predictors...), reg.Categorical...))
+	if err != nil {
+		rr.Close()
+		return nil, nil, err
+	}
+	d := &design{
+		response:    columns[0],
+		predictors:  columns[1 : 1+len(reg.Predictors)],
+		categorical: columns[1+len(reg.Predictors):],
+		levels:      make([][]string, len(reg.Categorical)),
+		intercept:   !reg.NoIntercept,
+	}
+	header := rr.Header()
+
+	// find the levels of the categorical predictors in the rows the model is fitted to
+	seen := make([]map[string]bool, len(d.categorical))
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+	var rows int64
+	err = eachRecord(rr, func(record []string) error {
+		rows++
+		if !d.complete(record) {
+			return nil
+		}
+		for i, index := range d.categorical {
+			value := record[index]
+			if !seen[i][value] {
+				if len(seen[i]) == maxLevels {
+					return &ParameterError{Message: fmt.Sprintf("'%s' has more than %d values", header[index], maxLevels)}
+				}
+				seen[i][value] = true
+				d.levels[i] = append(d.levels[i], value)
+			}
+		}
+		return nil
+	})
+	rr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result := &Regression{Response: header[d.response]}
+	if d.intercept {
+		d.names = append(d.names, InterceptName)
+	}
+	for _, index := range d.predictors {
+		d.names = append(d.names, header[index])
+	}
+	for i, index := range d.categorical {
+		sort.Strings(d.levels[i])
+		if len(d.levels[i]) == 0 {
+			continue
+		}
+		if result.ReferenceLevels == nil {
+			result.ReferenceLevels = make(map[string]string)
+		}
+		result.ReferenceLevels[header[index]] = d.levels[i][0]
+		for _, level := range d.levels[i][1:] {
+			d.names = append(d.names, fmt.Sprintf("%s[%s]", header[index], level))
+		}
+	}
+	p := len(d.names)
+
+	// fit the model
+	ls := newLeastSquares(p)
+	var response moments
+	var sumSquares float64
+	x := make([]float64, p)
+	rr, err = OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = eachRecord(rr, func(record []string) error {
+		y, ok := d.row(record, x)
+		if !ok {
+			return nil
+		}
+		response.add(y)
+		sumSquares += y * y
+		ls.add(x, y)
+		return nil
+	})
+	rr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	n := response.n
+	result.N, result.Missing = n, rows-n
+	if n < int64(p) {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("%d complete rows are too few to fit %d coefficients", n, p)}
+	}
+	beta, diagonal, collinear := ls.solve()
+	if collinear >= 0 {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("'%s' is collinear with the other predictors", d.names[collinear])}
+	}
+
+	df := n - int64(p)
+	result.ResidualDF = df
+	var variance float64
+	if df > 0 {
+		variance = ls.sse / float64(df)
+		result.ResidualStdError = float(math.Sqrt(variance))
+	}
+	for i, name := range d.names {
+		c := Coefficient{Name: name, Estimate: beta[i]}
+		if df > 0 {
+			se := math.Sqrt(variance * diagonal[i])
+			c.StdError = float(se)
+			if se > 0 {
+				t := beta[i] / se
+				c.TStatistic = float(t)
+				c.PValue = float(studentTTwoTailed(t, float64(df)))
+			}
+		}
+		result.Coefficients = append(result.Coefficients, c)
+	}
+
+	// the total sum of squares is around the mean with an intercept and around zero without
+	total, totalDF, modelDF := sumSquares, float64(n), float64(p)
+	if d.intercept {
+		total, totalDF, modelDF = response.m2, float64(n-1), float64(p-1)
+	}
+	if total > 0 {
+		r2 := 1 - ls.sse/total
+		result.RSquared = float(r2)
+		if df > 0 {
+			result.AdjustedRSquared = float(1 - (1-r2)*totalDF/float64(df))
+			if modelDF > 0 && ls.sse > 0 {
+				f := (total - ls.sse) / modelDF / variance
+				result.FStatistic = float(f)
+				result.FPValue = float(fUpperTail(f, modelDF, float64(df)))
+			}
+		}
+	}
+
+	// summarise the residuals
+	acc := newAccumulator(maxBufferedValues)
+	residuals := func(yield func(column int, v float64)) error {
+		rr, err := OpenRecords(filePath, opts)
+		if err != nil {
+			return err
+		}
+		defer rr.Close()
+		row := make([]float64, p)
+		return eachRecord(rr, func(record []string) error {
+			y, ok := d.row(record, row)
+			if !ok {
+				return nil
+			}
+			fitted := 0.0
+			for i, v := range row {
+				fitted += beta[i] * v
+			}
+			yield(0, y-fitted)
+			return nil
+		})
+	}
+	if err := residuals(func(_ int, v float64) { acc.add(v) }); err != nil {
+		return nil, nil, err
+	}
+	if acc.n > 0 {
+		quantile, err := resolveQuantiles([]*accumulator{acc}, []float64{0.25, 0.5, 0.75}, residuals)
+		if err != nil {
+			return nil, nil, err
+		}
+		result.Residuals = &ResidualSummary{
+			Min:    acc.min,
+			Q1:     quantile(0, 0.25),
+			Median: quantile(0, 0.5),
+			Q3:     quantile(0, 0.75),
+			Max:    acc.max,
+		}
+	}
+	return result, &startTime, nil
+}
+
+//eachRecord
+/*
+Calls `yield` with each remaining record of `rr`, stopping at the first error returned by `yield`.
+*/
+func eachRecord(rr *RecordReader, yield func(record []string) error) error {
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := yield(record); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// regressionFile holds a response 'y', a numeric predictor 'x', a categorical predictor 'g' with the levels a, b and c,
+// 'x2' which is a linear function of 'x', and a row missing each of 'y', 'x' and 'g'.
+func regressionFile(t *testing.T) string {
+	t.Helper()
+	lines := []string{"y,x,g,x2"}
+	for _, row := range []string{"3.1,1,a", "4.9,2,b", "7.2,3,a", "8.8,4,c", "11.3,5,b", "12.7,6,c", "15.2,7,a",
+		"16.9,8,b", "19.4,9,c", "n/a,10,a", "21.0,,b", "22.8,11,", "23.5,12,a"} {
+		x2 := ""
+		if x, err := strconv.Atoi(strings.Split(row, ",")[1]); err == nil {
+			x2 = strconv.Itoa(2*x + 1)
+		}
+		lines = append(lines, row+","+x2)
+	}
+	return writeCSV(t, t.TempDir(), "regression.csv", lines...)
+}
+
+// The reference values were computed independently of this package by solving the normal equations in exact
+// rational arithmetic, they agree with those of R's lm(y ~ x + g) and lm(y ~ x - 1) on the same rows.
+func TestRegressionReference(t *testing.T) {
+	path := regressionFile(t)
+	tests := []struct {
+		name             string
+		reg              RegressionOptions
+		n, missing, df   int64
+		names            []string
+		estimates        []float64
+		stdErrors        []float64
+		r2, adjustedR2   float64
+		residualStdError float64
+		f                float64
+		residuals        ResidualSummary
+	}{
+		{
+			name: "categorical", reg: RegressionOptions{Response: "y", Predictors: []string{"x"}, Categorical: []string{"g"}},
+			n: 10, missing: 3, df: 6,
+			names:            []string{InterceptName, "x", "g[b]", "g[c]"},
+			estimates:        []float64{1.2328677074774035, 1.9160230073952342, 0.22035058887975897, 0.26565324568611337},
+			stdErrors:        []float64{0.41199799513048807, 0.053904710344156816, 0.4165764323682593, 0.41580068414879146},
+			r2:               0.9953983726846517,
+			adjustedR2:       0.9930975590269776,
+			residualStdError: 0.5428519257356483,
+			f:                432.62885256465864,
+			residuals:        ResidualSummary{-0.7251437962202136, -0.34562448644207067, 0.034853464804163244, 0.2547658175842235, 0.6572719802793755},
+		},
+		{
+			// without an intercept R² is measured around zero
+			name: "no intercept", reg: RegressionOptions{Response: "y", Predictors: []string{"x"}, NoIntercept: true},
+			n: 11, missing: 2, df: 10,
+			names:            []string{"x"},
+			estimates:        []float64{2.0934545454545455},
+			stdErrors:        []float64{0.03457912234975519},
+			r2:               0.9972790687702685,
+			adjustedR2:       0.9970069756472953,
+			residualStdError: 0.8109523021507716,
+			f:                3665.2123283126534,
+			residuals:        ResidualSummary{-1.6214545454545455, 0.14581818181818182, 0.5458181818181819, 0.7729090909090909, 1.0065454545454546},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _, err := Regress(path, Options{}, tc.reg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.N != tc.n || r.Missing != tc.missing || r.ResidualDF != tc.df {
+				t.Errorf("n %d, missing %d and residual df %d, want %d, %d and %d", r.N, r.Missing, r.ResidualDF, tc.n, tc.missing, tc.df)
+			}
+			if len(r.Coefficients) != len(tc.names) {
+				t.Fatalf("coefficients %+v, want %v", r.Coefficients, tc.names)
+			}
+			for i, c := range r.Coefficients {
+				if c.Name != tc.names[i] || !closeEnough(c.Estimate, tc.estimates[i]) || !closeEnough(valueOf(c.StdError), tc.stdErrors[i]) {
+					t.Errorf("%s = %v with standard error %v, want %s = %v with %v",
+						c.Name, c.Estimate, valueOf(c.StdError), tc.names[i], tc.estimates[i], tc.stdErrors[i])
+				}
+				if !closeEnough(valueOf(c.TStatistic), tc.estimates[i]/tc.stdErrors[i]) || c.PValue == nil {
+					t.Errorf("%s: t %v and p %v", c.Name, valueOf(c.TStatistic), valueOf(c.PValue))
+				}
+			}
+			for name, v := range map[string][2]interface{}{
+				"r_squared":          {valueOf(r.RSquared), tc.r2},
+				"adjusted_r_squared": {valueOf(r.AdjustedRSquared), tc.adjustedR2},
+				"residual_std_error": {valueOf(r.ResidualStdError), tc.residualStdError},
+				"f_statistic":        {valueOf(r.FStatistic), tc.f},
+			} {
+				if !closeEnough(v[0], v[1]) {
+					t.Errorf("%s = %v, want %v", name, v[0], v[1])
+				}
+			}
+			got, want := *r.Residuals, tc.residuals
+			for i, v := range []float64{got.Min, got.Q1, got.Median, got.Q3, got.Max} {
+				if w := []float64{want.Min, want.Q1, want.Median, want.Q3, want.Max}[i]; !closeEnough(v, w) {
+					t.Errorf("residuals %+v, want %+v", got, want)
+					break
+				}
+			}
+		})
+	}
+	r, _, err := Regress(path, Options{}, RegressionOptions{Response: "y", Categorical: []string{"g"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(r.ReferenceLevels) != "map[g:a]" {
+		t.Errorf("reference levels %v", r.ReferenceLevels)
+	}
+}
+
+func TestRegressionCollinear(t *testing.T) {
+	path := regressionFile(t)
+	_, _, err := Regress(path, Options{}, RegressionOptions{Response: "y", Predictors: []string{"x", "x2"}})
+	var paramErr *ParameterError
+	if !errors.As(err, &paramErr) || !strings.Contains(err.Error(), "'x2' is collinear") {
+		t.Errorf("error %v, want x2 reported as collinear", err)
+	}
+
+	for _, reg := range []RegressionOptions{
+		{Predictors: []string{"x"}},
+		{Response: "y", NoIntercept: true},
+		// fewer complete rows than coefficients
+		{Response: "y", Predictors: []string{"x"}, Categorical: []string{"x2"}},
+	} {
+		if _, _, err := Regress(path, Options{}, reg); !errors.As(err, &paramErr) {
+			t.Errorf("%+v: error %v, want a ParameterError", reg, err)
+		}
+	}
+}
@@ -1,166 +1,309 @@
 package stats
 
 import (
-	"encoding/csv"
 	"errors"
-	"math"
-	"os"
-	"sort"
-	"strconv"
-	"strings"
+	"fmt"
+	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type Statistics struct {
-	Mean   float64 `json:"mean"`
-	Median float64 `json:"median"`
-	StdDev float64 `json:"stddev"`
-}
+// MaxWorkers bounds the number of goroutines parsing a file in CalculateStatistics.
+const MaxWorkers = 64
+
+// chunksPerWorker is the number of chunks CalculateStatistics splits a file into for each of its workers.
+const chunksPerWorker = 4
+
+// minChunkSize is the smallest number of bytes CalculateStatistics hands to a worker at a time,
+// smaller files are read by fewer workers.
+const minChunkSize = 256 << 10
 
-func CalculateStatisticsN(columnName []string, filePath string) (*Statistics, *time.Time, error) {
+//CalculateStatisticsN
+/*
+Calculates the `Statistics` of every column in `columnNames` of the CSV file at `filePath` on a single goroutine,
+returned by column name. A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The file is streamed one record at a time, moments are computed online
+and quantiles such as the median are found from a bounded number of values, see resolveRanks.
+The metrics calculated are chosen by `opts`, see Statistics.
+*/
+func CalculateStatisticsN(columnNames []string, filePath string, opts Options) (map[string]*Statistics, *time.Time, error) {
 	startTime := time.Now()
 
-	file, err := os.Open(filePath)
+	rr, err := OpenRecords(filePath, opts)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer file.Close()
+	defer rr.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	columnIndex := -1
-	for i, header := range records[0] {
-		if strings.EqualFold(header, columnName[0]) {
-			columnIndex = i
-			break
-		}
-	}
-
-	if columnIndex == -1 {
-		return nil, nil, errors.New("column not found")
+	ms, err := newMetricSet(opts)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var values []float64
-
-	// Parse values from the specified column
-	for _, record := range records[1:] { // Skip the header
-		if len(record) <= columnIndex {
-			continue
+	accumulators := newAccumulators(len(columnIndexes), ms, 1)
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
 		}
-		value, err := strconv.ParseFloat(record[columnIndex], 64)
-		if err == nil {
-			values = append(values, value)
+		for i, columnIndex := range columnIndexes {
+			accumulators[i].rows++
+			if len(record) <= columnIndex {
+				continue
+			}
+			if value, ok := parseValue(record[columnIndex]); ok {
+				accumulators[i].add(value)
+			}
 		}
 	}
 
-	// Calculate statistics
-	stats := calculateStats(values)
-
-	return &stats, &startTime, nil
+	stats, err := calculateStats(accumulators, ms, columnNames, columnIndexes, filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stats, &startTime, nil
 }
-func CalculateStatistics(columnName []string, filePath string) (*Statistics, *time.Time, error) {
+
+//CalculateStatistics
+/*
+Calculates the `Statistics` of every column in `columnNames` of the CSV file at `filePath`, returned by column name.
+A `ColumnNotFoundError` is returned if any of the columns is not in the file.
+
+The records after the header are split by byte range into chunks starting at record boundaries, see recordBoundaries,
+which are parsed and accumulated concurrently by `opts.Workers` workers, each taking the next chunk once it is done.
+The partial results of the workers are merged once every chunk has been read.
+*/
+func CalculateStatistics(columnNames []string, filePath string, opts Options) (map[string]*Statistics, *time.Time, error) {
 	startTime := time.Now()
 
-	file, err := os.Open(filePath)
+	rr, err := OpenRecords(filePath, opts)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer file.Close()
+	defer rr.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	columnIndexes, err := rr.ColumnIndexes(columnNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	ms, err := newMetricSet(opts)
 	if err != nil {
 		return nil, nil, err
 	}
+	workers := opts.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers < 0 || workers > MaxWorkers {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("workers must be between 1 and %d", MaxWorkers)}
+	}
 
-	columnIndex := -1
-	for i, header := range records[0] {
-		if strings.EqualFold(header, columnName[0]) {
-			columnIndex = i
-			break
+	info, err := rr.file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	start := rr.Offset()
+	// every worker takes a few chunks so that chunks parsing slower do not hold up the others
+	chunks := int(min(int64(workers*chunksPerWorker), (info.Size()-start)/minChunkSize))
+	bounds, err := recordBoundaries(rr.file, start, info.Size(), max(chunks, 1))
+	if err != nil {
+		return nil, nil, err
+	}
+	workers = min(workers, len(bounds)-1)
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	errs := make([]error, workers)
+	ranges := make(chan int, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		ranges <- i
+	}
+	close(ranges)
+	partials := make([][]*accumulator, workers)
+	for w := range partials {
+		partials[w] = newAccumulators(len(columnIndexes), ms, workers)
+		wg.Add(1)
+		go func(accumulators []*accumulator) {
+			defer wg.Done()
+			for i := range ranges {
+				if failed.Load() {
+					return
+				}
+				if err := accumulateRange(rr, bounds[i], bounds[i+1], opts, columnIndexes, accumulators); err != nil {
+					errs[w] = err
+					failed.Store(true)
+					return
+				}
+			}
+		}(partials[w])
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	accumulators := newAccumulators(len(columnIndexes), ms, 1)
+	for _, partial := range partials {
+		for i, acc := range partial {
+			accumulators[i].merge(acc)
 		}
 	}
 
-	if columnIndex == -1 {
+	stats, err := calculateStats(accumulators, ms, columnNames, columnIndexes, filePath, opts)
+	if err != nil {
 		return nil, nil, err
 	}
+	return stats, &startTime, nil
+}
 
-	var values []float64
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-	const numWorkers = 4
-	chunkSize := len(records[1:]) / numWorkers
-
-	// Worker function to parse values and calculate partial sums
-	processChunk := func(chunk [][]string) {
-		defer wg.Done()
-		localValues := make([]float64, 0, len(chunk))
-		for _, record := range chunk {
-			if len(record) <= columnIndex {
+//accumulateRange
+/*
+Adds the values of the columns at `columnIndexes` of the records of `rr` between the byte offsets `start` and `end`
+to `accumulators`.
+*/
+func accumulateRange(rr *RecordReader, start, end int64, opts Options, columnIndexes []int, accumulators []*accumulator) error {
+	chunk, err := rr.rangeReader(start, end, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := chunk.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		for i, columnIndex := range columnIndexes {
+			accumulators[i].rows++
+			if columnIndex >= len(record) {
 				continue
 			}
-			value, err := strconv.ParseFloat(record[columnIndex], 64)
-			if err == nil {
-				localValues = append(localValues, value)
+			if value, ok := parseValue(record[columnIndex]); ok {
+				accumulators[i].add(value)
 			}
 		}
-		mu.Lock()
-		values = append(values, localValues...) // Merge results
-		mu.Unlock()
 	}
+}
 
-	// Distribute work among workers
-	for i := 1; i < len(records); i += chunkSize {
-		end := i + chunkSize
-		if end > len(records) {
-			end = len(records)
+//newAccumulators
+/*
+Returns an accumulator for each of `n` columns, keeping values and counting frequencies only when the metrics need them.
+The number of values kept is shared out between `shares` accumulators of the same column.
+*/
+func newAccumulators(n int, ms *metricSet, shares int) []*accumulator {
+	limit := 0
+	if ms.needsValues() {
+		limit = maxBufferedValues / shares
+	}
+	accumulators := make([]*accumulator, n)
+	for i := range accumulators {
+		accumulators[i] = newAccumulator(limit)
+		if ms.needsFrequencies() {
+			accumulators[i].frequencies = newFrequencies[float64](maxTrackedValues)
+		}
+		if ms.needsDigest() {
+			accumulators[i].digest = newDigest()
 		}
-		wg.Add(1)
-		go processChunk(records[i:end])
 	}
-
-	wg.Wait()
-
-	// Calculate statistics
-	stats := calculateStats(values)
-
-	return &stats, &startTime, nil
+	return accumulators
 }
-func calculateStats(values []float64) Statistics {
-	n := len(values)
-	if n == 0 {
-		return Statistics{Mean: 0, Median: 0, StdDev: 0}
-	}
 
-	// Calculate mean
-	var sum float64
-	for _, v := range values {
-		sum += v
+//calculateStats
+/*
+Derives the `Statistics` of each column from its accumulated values.
+The file is read again to find the quantiles of the columns whose accumulator could not keep every value,
+see resolveQuantiles, and to count the candidate modes exactly when there were too many distinct values to count them all.
+*/
+func calculateStats(accumulators []*accumulator, ms *metricSet, columnNames []string, columnIndexes []int, filePath string, opts Options) (map[string]*Statistics, error) {
+	scan := func(yield func(column int, v float64)) error {
+		return scanColumns(filePath, opts, columnIndexes, yield)
+	}
+	var quantiles []float64
+	if !ms.approximate {
+		quantiles = ms.quantiles()
+	}
+	quantile, err := resolveQuantiles(accumulators, quantiles, scan)
+	if err != nil {
+		return nil, err
 	}
-	mean := sum / float64(n)
 
-	// Calculate median
-	var median float64
-	sortedValues := make([]float64, n)
-	copy(sortedValues, values)
-	sort.Float64s(sortedValues)
-	if n%2 == 0 {
-		median = (sortedValues[n/2-1] + sortedValues[n/2]) / 2
-	} else {
-		median = sortedValues[n/2]
+	var approximate []*frequencies[float64]
+	for _, acc := range accumulators {
+		if acc.frequencies != nil && acc.frequencies.approximate {
+			acc.frequencies.reset()
+			approximate = append(approximate, acc.frequencies)
+		} else {
+			approximate = append(approximate, nil)
+		}
+	}
+	for _, f := range approximate {
+		if f != nil {
+			err := scan(func(column int, v float64) {
+				if approximate[column] != nil {
+					approximate[column].count(v)
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 	}
 
-	// Calculate standard deviation
-	var variance float64
-	for _, v := range values {
-		variance += (v - mean) * (v - mean)
+	stats := make(map[string]*Statistics, len(accumulators))
+	for i, acc := range accumulators {
+		stats[columnNames[i]] = ms.statistics(acc, func(p float64) float64 { return quantile(i, p) })
 	}
-	stdDev := math.Sqrt(variance / float64(n))
+	return stats, nil
+}
 
-	return Statistics{Mean: mean, Median: median, StdDev: stdDev}
+//resolveQuantiles
+/*
+Finds the `quantiles`, in [0, 1], of the values of every accumulator, reading the file with `scan` for the columns
+whose accumulator could not keep every value. The quantiles of every column are found in the same passes.
+The returned function gives the quantile `p`, one of `quantiles`, of the accumulator at `column`.
+*/
+func resolveQuantiles(accumulators []*accumulator, quantiles []float64, scan func(yield func(column int, v float64)) error) (func(column int, p float64) float64, error) {
+	ranks := make([]map[int64]*rankQuery, len(accumulators))
+	var queries []*rankQuery
+	for i, acc := range accumulators {
+		if acc.n == 0 || len(quantiles) == 0 {
+			continue
+		}
+		var wanted []int64
+		seen := make(map[int64]bool)
+		for _, p := range quantiles {
+			lower, upper, _ := quantileRanks(acc.n, p)
+			for _, rank := range []int64{lower, upper} {
+				if !seen[rank] {
+					seen[rank] = true
+					wanted = append(wanted, rank)
+				}
+			}
+		}
+		ranks[i] = make(map[int64]*rankQuery, len(wanted))
+		for _, q := range acc.rankQueries(i, wanted) {
+			ranks[i][q.rank] = q
+			queries = append(queries, q)
+		}
+	}
+	if err := resolveRanks(queries, scan); err != nil {
+		return nil, err
+	}
+	return func(column int, p float64) float64 {
+		lower, upper, weight := quantileRanks(accumulators[column].n, p)
+		lo, hi := ranks[column][lower].value, ranks[column][upper].value
+		return lo + weight*(hi-lo)
+	}, nil
 }
@@ -0,0 +1,408 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCSV writes `lines` as a CSV file named `name` to `dir` and returns its path.
+func writeCSV(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// closeEnough reports whether `a` and `b` are equal, numbers within a relative tolerance and maps key by key.
+func closeEnough(a, b interface{}) bool {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		return ok && math.Abs(x-y) <= 1e-9*math.Max(1, math.Abs(y))
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k := range x {
+			if !closeEnough(x[k], y[k]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func statisticsJSON(t *testing.T, s map[string]*Statistics) map[string]map[string]interface{} {
+	t.Helper()
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+var calculations = map[string]func([]string, string, Options) (map[string]*Statistics, *time.Time, error){
+	"CalculateStatisticsN": CalculateStatisticsN, "CalculateStatistics": CalculateStatistics,
+}
+
+// baseline holds the statistics of a column computed the way they were before they were streamed:
+// every value is read into memory and the quantiles are taken from the sorted values.
+type baseline struct {
+	mean, median, stddev float64
+	sorted               []float64
+}
+
+func baselineStatistics(t *testing.T, path, column string) baseline {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	columnIndex := -1
+	for i, header := range records[0] {
+		if strings.EqualFold(header, column) {
+			columnIndex = i
+		}
+	}
+	var values []float64
+	for _, record := range records[1:] {
+		if v, err := strconv.ParseFloat(record[columnIndex], 64); err == nil {
+			values = append(values, v)
+		}
+	}
+
+	n := len(values)
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(n)
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return baseline{mean: mean, median: median, stddev: math.Sqrt(variance / float64(n)), sorted: sorted}
+}
+
+// percentile interpolates linearly between the sorted values closest to the percentile `p`.
+func (b baseline) percentile(p float64) float64 {
+	h := p / 100 * float64(len(b.sorted)-1)
+	lower := int(math.Floor(h))
+	upper := int(math.Ceil(h))
+	return b.sorted[lower] + (b.sorted[upper]-b.sorted[lower])*(h-float64(lower))
+}
+
+// writeBaselineFile writes a file of `rows` records whose column 'large' holds a value in each record,
+// with repeated and negative values, and whose column 'small' holds a value every 400 records,
+// its other fields being empty or not numbers.
+func writeBaselineFile(t *testing.T, rows int) string {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(1))
+	lines := []string{"id,large,small"}
+	for i := 0; i < rows; i++ {
+		large := fmt.Sprintf("%.3f", rnd.NormFloat64()*250+40)
+		if i%5 == 0 {
+			large = fmt.Sprint(rnd.Intn(200) - 50)
+		}
+		small := ""
+		if i%400 == 0 && i > 0 {
+			small = fmt.Sprintf("%.2f", rnd.ExpFloat64()*10)
+		} else if i%3 == 0 {
+			small = "n/a"
+		}
+		lines = append(lines, fmt.Sprintf("%d,%s,%s", i, large, small))
+	}
+	return writeCSV(t, t.TempDir(), "baseline.csv", lines...)
+}
+
+func TestStatisticsMatchBaseline(t *testing.T) {
+	// 12 values of 'small' and 5001 of 'large'
+	path := writeBaselineFile(t, 5001)
+	percentiles := []float64{0, 1, 10, 33.3, 75, 99.9, 100}
+	defer func(limit int) { maxBufferedValues = limit }(maxBufferedValues)
+	// with the lower limits the quantiles of 'large' are resolved over several passes while 'small' stays buffered,
+	// whatever the number of workers of CalculateStatistics, none standing for CalculateStatisticsN
+	for _, limit := range []int{maxBufferedValues, 64, 2} {
+		maxBufferedValues = limit
+		for _, workers := range []int{0, 1, 3} {
+			opts := Options{Metrics: []string{"mean", "median", "stddev"}, Percentiles: percentiles, Workers: workers}
+			calculate := CalculateStatistics
+			if workers == 0 {
+				calculate = CalculateStatisticsN
+			}
+			stats, _, err := calculate([]string{"large", "small"}, path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, column := range []string{"large", "small"} {
+				want, got := baselineStatistics(t, path, column), stats[column]
+				if !closeEnough(*got.Mean, want.mean) || !closeEnough(*got.StdDev, want.stddev) || *got.Median != want.median {
+					t.Errorf("limit %d, %d workers, %s: mean %v, stddev %v, median %v, want %v, %v and %v", limit, workers, column,
+						*got.Mean, *got.StdDev, *got.Median, want.mean, want.stddev, want.median)
+				}
+				for _, p := range percentiles {
+					key := percentileKey(p)
+					if !closeEnough(got.Percentiles[key], want.percentile(p)) {
+						t.Errorf("limit %d, %d workers: %s of %s is %v, want %v", limit, workers, key, column, got.Percentiles[key], want.percentile(p))
+					}
+				}
+			}
+		}
+	}
+}
+
+// The reference values of referenceValues were computed from the textbook definitions of each metric
+// in exact rational arithmetic: sample variance, adjusted Fisher-Pearson skewness, bias corrected excess kurtosis,
+// CV as the sample standard deviation over the mean and quantiles interpolated between the closest ranks.
+var referenceValues = []float64{2, 4, 4, 4, 5, 5, 7, 9, 12, 3.5, -1.5, 4}
+
+func TestMetricsReference(t *testing.T) {
+	lines := []string{"id,value"}
+	for i, v := range referenceValues {
+		lines = append(lines, fmt.Sprintf("%d,%v", i, v))
+		if i%4 == 0 {
+			lines = append(lines, fmt.Sprintf("%d,n/a", i), fmt.Sprintf("%d,", i))
+		}
+	}
+	path := writeCSV(t, t.TempDir(), "reference.csv", lines...)
+	want := map[string]interface{}{
+		"count": 12.0, "missing": 6.0, "sum": 58.0, "mean": 4.833333333333333, "median": 4.0,
+		"stddev": 3.2425127430572864, "sample_stddev": 3.3866941063073543,
+		"variance": 11.469696969696969, "population_variance": 10.51388888888889,
+		"min": -1.5, "max": 12.0, "range": 13.5, "q1": 3.875, "q3": 5.5, "iqr": 1.625,
+		"mode": 4.0, "mode_count": 4.0, "skewness": 0.4750114937040852, "kurtosis": 1.5242911164664803, "cv": 0.7006953323394527,
+		"percentiles": map[string]interface{}{"p10": 2.15, "p90": 8.8},
+	}
+	opts := Options{Metrics: []string{"all"}, Percentiles: []float64{10, 90}}
+	for name, calculate := range calculations {
+		stats, _, err := calculate([]string{"value"}, path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := statisticsJSON(t, stats)["value"]
+		if len(got) != len(want) {
+			t.Errorf("%s: %d metrics, want %d", name, len(got), len(want))
+		}
+		for metric, v := range want {
+			if !closeEnough(got[metric], v) {
+				t.Errorf("%s: %s is %v, want %v", name, metric, got[metric], v)
+			}
+		}
+	}
+}
+
+func TestMomentsMerge(t *testing.T) {
+	var all moments
+	for _, v := range referenceValues {
+		all.add(v)
+	}
+	if !closeEnough(all.skewness(), 0.4750114937040852) || !closeEnough(all.kurtosis(), 1.5242911164664803) {
+		t.Errorf("skewness %v and kurtosis %v", all.skewness(), all.kurtosis())
+	}
+	// every split of the values, including the empty ones, merges to the moments of the whole
+	for split := 0; split <= len(referenceValues); split++ {
+		var a, b moments
+		for _, v := range referenceValues[:split] {
+			a.add(v)
+		}
+		for _, v := range referenceValues[split:] {
+			b.add(v)
+		}
+		a.merge(&b)
+		if a.n != all.n || a.sum != all.sum || a.min != all.min || a.max != all.max || !closeEnough(a.mean, all.mean) ||
+			!closeEnough(a.m2, all.m2) || !closeEnough(a.m3, all.m3) || !closeEnough(a.m4, all.m4) {
+			t.Errorf("split at %d: merged %+v, want %+v", split, a, all)
+		}
+	}
+}
+
+// writeTestFile writes a CSV file of `rows` records to a temporary directory and returns its path.
+// Every 7th note is quoted and holds a delimiter, a doubled quote and a line break so that records span lines.
+func writeTestFile(tb testing.TB, rows int) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "data.csv")
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	rnd := rand.New(rand.NewSource(1))
+	fmt.Fprintln(w, "id,price,units,note")
+	for i := 0; i < rows; i++ {
+		note := fmt.Sprintf("n%d", i%100)
+		if i%7 == 0 {
+			note = fmt.Sprintf("\"a, \"\"quoted\"\"\nnote %d\"", i)
+		}
+		units := fmt.Sprint(rnd.Intn(50))
+		if i%11 == 0 {
+			units = "n/a"
+		}
+		fmt.Fprintf(w, "%d,%.2f,%s,%s\n", i, rnd.ExpFloat64()*400, units, note)
+	}
+	if err := w.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func TestCalculateStatisticsMatchesN(t *testing.T) {
+	// fewer distinct ids than maxTrackedValues so that their mode is counted exactly
+	baseline := writeBaselineFile(t, 20000)
+	all := []string{"all"}
+	// the mode is only compared on a column with few distinct values, beyond maxTrackedValues it is an estimate
+	// that depends on the order the values are counted in
+	quoted := writeTestFile(t, 100000)
+	metrics := []string{"count", "missing", "sum", "mean", "median", "stddev", "min", "max", "percentiles"}
+	for _, tc := range []struct {
+		path    string
+		columns []string
+		metrics []string
+		where   string
+	}{
+		{baseline, []string{"id", "large", "small"}, all, ""},
+		{baseline, []string{"id", "large", "small"}, all, "id >= 5000"},
+		{quoted, []string{"price", "units", "id"}, metrics, ""},
+		{quoted, []string{"price", "units", "id"}, metrics, "units > 10"},
+		{quoted, []string{"units"}, []string{"mode"}, ""},
+	} {
+		columns, where := tc.columns, tc.where
+		opts := Options{Metrics: tc.metrics, Percentiles: []float64{1, 5, 50, 95, 99}, Where: where}
+		want, _, err := CalculateStatisticsN(columns, tc.path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := statisticsJSON(t, want)
+		for _, workers := range []int{1, 3, 8} {
+			opts.Workers = workers
+			got, _, err := CalculateStatistics(columns, tc.path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := statisticsJSON(t, got)
+			for _, column := range columns {
+				if len(w[column]) != len(g[column]) {
+					t.Errorf("where %q, %d workers: %s has %d metrics, want %d", where, workers, column, len(g[column]), len(w[column]))
+				}
+				for metric, v := range w[column] {
+					if !closeEnough(g[column][metric], v) {
+						t.Errorf("where %q, %d workers: %s of %s is %v, want %v", where, workers, metric, column, g[column][metric], v)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRecordBoundaries(t *testing.T) {
+	path := writeTestFile(t, 20000)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := int64(len("id,price,units,note\n"))
+	for _, chunks := range []int{1, 2, 7, 64} {
+		bounds, err := recordBoundaries(file, header, info.Size(), chunks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(bounds) < 2 || len(bounds) > chunks+1 || bounds[0] != header || bounds[len(bounds)-1] != info.Size() {
+			t.Fatalf("%d chunks: unexpected bounds %v", chunks, bounds)
+		}
+		records := 0
+		for i := 0; i < len(bounds)-1; i++ {
+			reader := csv.NewReader(io.NewSectionReader(file, bounds[i], bounds[i+1]-bounds[i]))
+			for {
+				record, err := reader.Read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("%d chunks: range %d: %v", chunks, i, err)
+				}
+				if want := fmt.Sprint(records); record[0] != want {
+					t.Fatalf("%d chunks: range %d starts at record %s, want %s", chunks, i, record[0], want)
+				}
+				records++
+			}
+		}
+		if records != 20000 {
+			t.Errorf("%d chunks: read %d records, want 20000", chunks, records)
+		}
+	}
+}
+
+func BenchmarkCalculateStatistics(b *testing.B) {
+	for _, rows := range []int{1000, 1000000} {
+		path := writeTestFile(b, rows)
+		info, err := os.Stat(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		columns := []string{"price", "units"}
+		run := func(name string, calculate func() error) {
+			b.Run(fmt.Sprintf("rows=%d/%s", rows, name), func(b *testing.B) {
+				b.SetBytes(info.Size())
+				for i := 0; i < b.N; i++ {
+					if err := calculate(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+		run("N", func() error {
+			_, _, err := CalculateStatisticsN(columns, path, Options{})
+			return err
+		})
+		workerCounts := []int{1, 2, 4}
+		if n := runtime.GOMAXPROCS(0); !slices.Contains(workerCounts, n) {
+			workerCounts = append(workerCounts, n)
+		}
+		for _, workers := range workerCounts {
+			opts := Options{Workers: workers}
+			run(fmt.Sprintf("workers=%d", workers), func() error {
+				_, _, err := CalculateStatistics(columns, path, opts)
+				return err
+			})
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+//Table
+/*
+The tabular result of an operation, each row holds a value for every column.
+Values are strings, int64s, float64s or nil when they are undefined.
+Total is the number of rows before any limit was applied and Skipped the number of records the operation left out.
+*/
+type Table struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	Total   int             `json:"total"`
+	Skipped int64           `json:"skipped,omitempty"`
+}
+
+//WriteCSV
+/*
+Writes the table to `w` as CSV with a header row, separated by `delimiter` or a comma when it is zero.
+Undefined values are written as empty fields.
+*/
+func (t *Table) WriteCSV(w io.Writer, delimiter rune) error {
+	writer := csv.NewWriter(w)
+	if delimiter != 0 {
+		writer.Comma = delimiter
+	}
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			record[i] = formatValue(v)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+//compareValues
+/*
+Compares two defined values of a table, returning a negative number when `a` is ordered first.
+Numbers are compared numerically and so are strings that both hold numbers, other strings are compared lexically.
+*/
+func compareValues(a, b interface{}) int {
+	x, xNumeric := numericValue(a)
+	y, yNumeric := numericValue(b)
+	if xNumeric && yNumeric {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(formatValue(a), formatValue(b))
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case string:
+		return parseValue(v)
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,168 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// digestCompression bounds the number of centroids of a t-digest, larger values are more accurate and use more memory.
+const digestCompression = 200
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+//tdigest
+/*
+A merging t-digest (Dunning and Ertl) summarising a stream of values in a bounded number of centroids,
+centroids are kept small near the tails so extreme quantiles are estimated more accurately than central ones.
+Digests built over parts of a stream can be merged, which is how the workers of CalculateStatistics are combined.
+*/
+type tdigest struct {
+	centroids []centroid
+	buffer    []centroid
+	total     float64
+	min       float64
+	max       float64
+}
+
+func newDigest() *tdigest {
+	return &tdigest{}
+}
+
+func (d *tdigest) add(v float64) {
+	if d.total == 0 {
+		d.min, d.max = v, v
+	} else {
+		d.min = math.Min(d.min, v)
+		d.max = math.Max(d.max, v)
+	}
+	d.total++
+	d.buffer = append(d.buffer, centroid{mean: v, weight: 1})
+	if len(d.buffer) >= 10*digestCompression {
+		d.compress()
+	}
+}
+
+func (d *tdigest) merge(o *tdigest) {
+	if o.total == 0 {
+		return
+	}
+	if d.total == 0 {
+		d.min, d.max = o.min, o.max
+	} else {
+		d.min = math.Min(d.min, o.min)
+		d.max = math.Max(d.max, o.max)
+	}
+	d.total += o.total
+	d.buffer = append(d.buffer, o.centroids...)
+	d.buffer = append(d.buffer, o.buffer...)
+	d.compress()
+}
+
+// scale is the k1 scale function of the t-digest, mapping a quantile to the index of the centroid holding it.
+func scale(q float64) float64 {
+	return digestCompression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func inverseScale(k float64) float64 {
+	return (math.Sin(k*2*math.Pi/digestCompression) + 1) / 2
+}
+
+//compress
+/*
+Merges the buffered values into the centroids, neighbouring centroids are combined while the quantiles they span
+stay within one unit of the scale function.
+*/
+func (d *tdigest) compress() {
+	if len(d.buffer) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(d.centroids)+len(d.buffer))
+	all = append(all, d.centroids...)
+	all = append(all, d.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(d.centroids)+1)
+	current := all[0]
+	weightSoFar := 0.0
+	limit := d.total * inverseScale(scale(0)+1)
+	for _, c := range all[1:] {
+		if weightSoFar+current.weight+c.weight <= limit {
+			current.weight += c.weight
+			current.mean += (c.mean - current.mean) * c.weight / current.weight
+			continue
+		}
+		weightSoFar += current.weight
+		merged = append(merged, current)
+		limit = d.total * inverseScale(scale(weightSoFar/d.total)+1)
+		current = c
+	}
+	d.centroids = append(merged, current)
+	d.buffer = d.buffer[:0]
+}
+
+//QuantileBound
+/*
+The uncertainty of an approximate quantile: the true value is expected between Lower and Upper,
+the values of the centroids one further out than those either side of the estimate, as the values of neighbouring
+centroids overlap once digests are merged. The rank of the estimate is off by at most RankError as a fraction of the count.
+*/
+type QuantileBound struct {
+	Lower     float64 `json:"lower"`
+	Upper     float64 `json:"upper"`
+	RankError float64 `json:"rank_error"`
+}
+
+//quantile
+/*
+Estimates the quantile `p` in [0, 1] by interpolating linearly between the centres of neighbouring centroids,
+the minimum and maximum anchor both ends. The bound of the estimate is returned along with it.
+A digest of few enough values to keep each in its own centroid answers exactly.
+*/
+func (d *tdigest) quantile(p float64) (float64, QuantileBound) {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return 0, QuantileBound{}
+	}
+	if float64(len(d.centroids)) == d.total {
+		// every value is still its own centroid so the quantile is found exactly, as it would be without a digest
+		lower, upper, weight := quantileRanks(int64(d.total), p)
+		lo, hi := d.centroids[lower].mean, d.centroids[upper].mean
+		return lo + weight*(hi-lo), QuantileBound{Lower: lo, Upper: hi}
+	}
+	// the positions, in rank, and values to interpolate between
+	positions := make([]float64, 0, len(d.centroids)+2)
+	values := make([]float64, 0, len(d.centroids)+2)
+	weights := make([]float64, 0, len(d.centroids)+2)
+	positions, values, weights = append(positions, 0), append(values, d.min), append(weights, 0)
+	cumulative := 0.0
+	for _, c := range d.centroids {
+		positions = append(positions, cumulative+c.weight/2)
+		values = append(values, c.mean)
+		weights = append(weights, c.weight)
+		cumulative += c.weight
+	}
+	positions, values, weights = append(positions, d.total), append(values, d.max), append(weights, 0)
+
+	index := p * d.total
+	i := sort.SearchFloat64s(positions, index)
+	if i == 0 {
+		return d.min, QuantileBound{Lower: d.min, Upper: d.min}
+	}
+	if i >= len(positions) {
+		return d.max, QuantileBound{Lower: d.max, Upper: d.max}
+	}
+	left, right := i-1, i
+	span := positions[right] - positions[left]
+	estimate := values[left]
+	if span > 0 {
+		estimate += (index - positions[left]) / span * (values[right] - values[left])
+	}
+	return estimate, QuantileBound{
+		Lower:     values[max(left-1, 0)],
+		Upper:     values[min(right+1, len(values)-1)],
+		RankError: (weights[left] + weights[right]) / 2 / d.total,
+	}
+}
@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// digestValues returns `n` values with a heavy right tail and many repeated values.
+func digestValues(n int) []float64 {
+	rnd := rand.New(rand.NewSource(7))
+	values := make([]float64, n)
+	for i := range values {
+		switch i % 3 {
+		case 0:
+			values[i] = rnd.ExpFloat64() * 100
+		case 1:
+			values[i] = math.Round(rnd.NormFloat64()*10) + 50
+		default:
+			values[i] = rnd.Float64()
+		}
+	}
+	return values
+}
+
+var digestPercentiles = []float64{0, 0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999, 1}
+
+// checkDigest checks that every quantile estimated by `d` lies within its bound and that the exact quantile
+// of `sorted` does too, with the rank of the estimate off by no more than the rank error of the bound.
+func checkDigest(t *testing.T, name string, d *tdigest, sorted []float64) {
+	t.Helper()
+	n := float64(len(sorted))
+	for _, p := range digestPercentiles {
+		got, bound := d.quantile(p)
+		lower, upper, weight := quantileRanks(int64(len(sorted)), p)
+		exact := sorted[lower] + (sorted[upper]-sorted[lower])*weight
+		if got < bound.Lower || got > bound.Upper || exact < bound.Lower || exact > bound.Upper {
+			t.Errorf("%s: quantile %v is %v, exactly %v, outside of its bound %+v", name, p, got, exact, bound)
+		}
+		// the ranks the estimate could take among equal values
+		first := float64(sort.SearchFloat64s(sorted, got))
+		last := float64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > got }))
+		tolerance := bound.RankError*n + 1
+		if p*n < first-tolerance || p*n > last+tolerance {
+			t.Errorf("%s: quantile %v is %v at ranks %v to %v, more than %v from %v", name, p, got, first, last, tolerance, p*n)
+		}
+	}
+}
+
+func TestDigestQuantiles(t *testing.T) {
+	values := digestValues(100000)
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	d := newDigest()
+	for _, v := range values {
+		d.add(v)
+	}
+	if len(d.centroids) > 2*digestCompression {
+		t.Errorf("%d centroids, want at most %d", len(d.centroids), 2*digestCompression)
+	}
+	checkDigest(t, "single digest", d, sorted)
+
+	// digests over contiguous and interleaved parts of the values merge to the same accuracy
+	for _, parts := range []int{2, 7} {
+		contiguous, interleaved := newDigest(), newDigest()
+		for part := 0; part < parts; part++ {
+			c, i := newDigest(), newDigest()
+			for j := part * len(values) / parts; j < (part+1)*len(values)/parts; j++ {
+				c.add(values[j])
+			}
+			for j := part; j < len(values); j += parts {
+				i.add(values[j])
+			}
+			contiguous.merge(c)
+			interleaved.merge(i)
+		}
+		if contiguous.total != float64(len(values)) || contiguous.min != sorted[0] || contiguous.max != sorted[len(sorted)-1] {
+			t.Errorf("%d parts: merged %v values from %v to %v", parts, contiguous.total, contiguous.min, contiguous.max)
+		}
+		checkDigest(t, "contiguous parts", contiguous, sorted)
+		checkDigest(t, "interleaved parts", interleaved, sorted)
+	}
+}
+
+func TestDigestFewValues(t *testing.T) {
+	empty := newDigest()
+	empty.merge(newDigest())
+	if v, bound := empty.quantile(0.5); v != 0 || bound != (QuantileBound{}) {
+		t.Errorf("empty digest: %v with bound %+v", v, bound)
+	}
+
+	single := newDigest()
+	single.add(4.5)
+	// merging an empty digest either way keeps the value
+	single.merge(newDigest())
+	other := newDigest()
+	other.merge(single)
+	for _, d := range []*tdigest{single, other} {
+		for _, p := range digestPercentiles {
+			if v, bound := d.quantile(p); v != 4.5 || bound.Lower != 4.5 || bound.Upper != 4.5 || bound.RankError != 0 {
+				t.Errorf("single value: quantile %v is %v with bound %+v", p, v, bound)
+			}
+		}
+	}
+
+	// a digest small enough to keep every value in a centroid of its own is exact
+	values := []float64{9, 1, 4, 4, 7, 2}
+	d := newDigest()
+	for _, v := range values {
+		d.add(v)
+	}
+	for p, want := range map[float64]float64{0: 1, 0.25: 2.5, 0.5: 4, 0.9: 8, 1: 9} {
+		if v, _ := d.quantile(p); v != want {
+			t.Errorf("quantile %v of %v is %v, want %v", p, values, v, want)
+		}
+	}
+}
+
+func TestApproximateStatistics(t *testing.T) {
+	path := writeBaselineFile(t, 20000)
+	opts := Options{Metrics: []string{"median", "quartiles"}, Percentiles: []float64{1, 99}, Approximate: true}
+	for name, calculate := range calculations {
+		stats, _, err := calculate([]string{"large"}, path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exact := baselineStatistics(t, path, "large")
+		s := stats["large"]
+		if !s.Approximate {
+			t.Errorf("%s: not reported as approximate", name)
+		}
+		for key, v := range map[string]float64{"median": *s.Median, "q1": *s.Q1, "q3": *s.Q3, "p1": s.Percentiles["p1"], "p99": s.Percentiles["p99"]} {
+			bound, ok := s.ErrorBounds[key]
+			if !ok {
+				t.Errorf("%s: no bound for %s", name, key)
+				continue
+			}
+			want := map[string]float64{"median": 50, "q1": 25, "q3": 75, "p1": 1, "p99": 99}[key]
+			if exact := exact.percentile(want); v < bound.Lower || v > bound.Upper || exact < bound.Lower || exact > bound.Upper {
+				t.Errorf("%s: %s is %v, exactly %v, outside of its bound %+v", name, key, v, exact, bound)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package stats
+
+import (
+	"api-3390/filter"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Periods a series can be resampled to through ResampleOptions.Every.
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// Functions applied over a resampled series through RollingOptions.Function.
+const (
+	RollingMean       = "mean"
+	RollingSum        = "sum"
+	RollingCumulative = "cumsum"
+)
+
+// PeriodColumn is the name of the column holding the start of each period of a resampled series.
+const PeriodColumn = "period"
+
+// periodLayout is the layout the start of each period is written in.
+const periodLayout = "2006-01-02"
+
+//ResampleOptions
+/*
+How a series is resampled: the records are bucketed by the period `Every`, 'day' (the default), 'week' or 'month',
+their date in the column `Date` falls in and the aggregates in `Aggregates` are calculated for each period,
+see GroupBy for the aggregates available.
+The date is parsed with the Go layout `Layout`, or any of filter.DateLayouts when it is empty.
+*/
+type ResampleOptions struct {
+	Date       string
+	Layout     string
+	Every      string
+	Aggregates []string
+}
+
+//RollingOptions
+/*
+A function applied over the aggregates of a resampled series: the 'mean' or 'sum' of the last `Window` periods,
+or the 'cumsum', the cumulative total up to each period.
+*/
+type RollingOptions struct {
+	ResampleOptions
+	Function string
+	Window   int
+}
+
+//periodStart
+/*
+Returns the start of the period `every` that `t` falls in, weeks start on Monday.
+*/
+func periodStart(t time.Time, every string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch every {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func nextPeriod(t time.Time, every string) time.Time {
+	switch every {
+	case PeriodWeek:
+		return t.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+//Resample
+/*
+Resamples the records of the CSV file at `filePath` as described by `rs` in a single pass, returned as a `Table`
+with the start of each period followed by a column for each aggregate, ordered by period.
+Every period between the first and the last is included, periods without records have a count of zero.
+Records whose date cannot be parsed are left out and counted in Skipped.
+*/
+func Resample(filePath string, opts Options, rs ResampleOptions) (*Table, *time.Time, error) {
+	startTime := time.Now()
+
+	every := strings.ToLower(rs.Every)
+	if every == "" {
+		every = PeriodDay
+	}
+	if every != PeriodDay && every != PeriodWeek && every != PeriodMonth {
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("every must be one of: %s, %s, %s", PeriodDay, PeriodWeek, PeriodMonth)}
+	}
+	if rs.Date == "" {
+		return nil, nil, &ParameterError{Message: "the date column must be provided"}
+	}
+	if len(rs.Aggregates) == 0 {
+		rs.Aggregates = []string{"count(*)"}
+	}
+	parse := filter.ParseDate
+	if rs.Layout != "" {
+		parse = func(s string) (time.Time, bool) {
+			t, err := time.Parse(rs.Layout, strings.TrimSpace(s))
+			return t, err == nil
+		}
+	}
+
+	rr, err := OpenRecords(filePath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rr.Close()
+	date := rr.ColumnIndex(rs.Date)
+	if date == -1 {
+		return nil, nil, &ColumnNotFoundError{Columns: []string{rs.Date}}
+	}
+	columns := []string{PeriodColumn}
+	aggregates := make([]*aggregate, len(rs.Aggregates))
+	for i, s := range rs.Aggregates {
+		if aggregates[i], err = parseAggregate(rr, s); err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, aggregates[i].name)
+	}
+
+	gr := newGrouper(aggregates)
+	var first, last time.Time
+	var skipped int64
+	key := make([]string, 1)
+	for {
+		record, err := rr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		var t time.Time
+		ok := date < len(record)
+		if ok {
+			t, ok = parse(record[date])
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+		start := periodStart(t, every)
+		if len(gr.order) == 0 || start.Before(first) {
+			first = start
+		}
+		if len(gr.order) == 0 || start.After(last) {
+			last = start
+		}
+		key[0] = start.Format(periodLayout)
+		g, err := gr.group(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		gr.add(g, record)
+	}
+
+	table := &Table{Columns: columns, Rows: [][]interface{}{}, Skipped: skipped}
+	if len(gr.order) > 0 {
+		for t := first; !t.After(last); t = nextPeriod(t, every) {
+			if len(table.Rows) == maxGroups {
+				return nil, nil, &ParameterError{Message: fmt.Sprintf("more than %d periods", maxGroups)}
+			}
+			// periods without records are aggregated over an empty group
+			key[0] = t.Format(periodLayout)
+			g, ok := gr.groups[strings.Join(key, "\x00")]
+			if !ok {
+				g = &group{keys: []string{key[0]}, values: make([]moments, len(aggregates))}
+			}
+			table.Rows = append(table.Rows, gr.row(g))
+		}
+	}
+	table.Total = len(table.Rows)
+	return table, &startTime, nil
+}
+
+//Rolling
+/*
+Resamples the CSV file at `filePath` as described by `ro`, see Resample, and adds a column for each aggregate
+holding the function of `ro` applied over it, named e.g. 'mean_7(sum(price))' or 'cumsum(sum(price))'.
+Undefined values are skipped, the rolling mean and sum are undefined until the window is full.
+*/
+func Rolling(filePath string, opts Options, ro RollingOptions) (*Table, *time.Time, error) {
+	function := strings.ToLower(ro.Function)
+	switch function {
+	case RollingMean, RollingSum:
+		if ro.Window < 1 {
+			return nil, nil, &ParameterError{Message: "the window must be at least 1 period"}
+		}
+	case RollingCumulative:
+	default:
+		return nil, nil, &ParameterError{Message: fmt.Sprintf("function must be one of: %s, %s, %s",
+			RollingMean, RollingSum, RollingCumulative)}
+	}
+	table, startTime, err := Resample(filePath, opts, ro.ResampleOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aggregates := len(table.Columns) - 1
+	for i := 1; i <= aggregates; i++ {
+		name := fmt.Sprintf("%s(%s)", function, table.Columns[i])
+		if function != RollingCumulative {
+			name = fmt.Sprintf("%s_%d(%s)", function, ro.Window, table.Columns[i])
+		}
+		table.Columns = append(table.Columns, name)
+
+		var total float64
+		var count int
+		for r, row := range table.Rows {
+			if v, ok := numericValue(row[i]); ok {
+				total += v
+				count++
+			}
+			if function != RollingCumulative && r >= ro.Window {
+				// the period leaving the window
+				if v, ok := numericValue(table.Rows[r-ro.Window][i]); ok {
+					total -= v
+					count--
+				}
+			}
+			var value interface{}
+			switch {
+			case function == RollingCumulative:
+				value = total
+			case r+1 < ro.Window || count == 0:
+			case function == RollingSum:
+				value = total
+			default:
+				value = total / float64(count)
+			}
+			table.Rows[r] = append(row, value)
+		}
+	}
+	return table, startTime, nil
+}
@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// seriesFile holds prices over six weeks starting on Monday 2024-01-01, with no record in some of the weeks
+// and a date that cannot be parsed.
+func seriesFile(t *testing.T, dates ...string) string {
+	t.Helper()
+	prices := []string{"10", "5", "1", "4", "2", "3"}
+	lines := []string{"date,price"}
+	for i, d := range dates {
+		lines = append(lines, d+","+prices[i])
+	}
+	return writeCSV(t, t.TempDir(), "series.csv", lines...)
+}
+
+var seriesDates = []string{"2024-01-01", "2024-01-03", "2024-01-07", "2024-01-15", "2024-02-10", "soon"}
+
+func TestResample(t *testing.T) {
+	tests := []struct {
+		name  string
+		dates []string
+		rs    ResampleOptions
+		rows  string
+	}{
+		{
+			// Sunday the 7th ends the first week, the empty weeks have a count and a sum of zero
+			name: "week", dates: seriesDates, rs: ResampleOptions{Every: "Week"},
+			rows: "[[2024-01-01 3 16] [2024-01-08 0 0] [2024-01-15 1 4] [2024-01-22 0 0] [2024-01-29 0 0] [2024-02-05 1 2]]",
+		},
+		{
+			name: "month", dates: seriesDates, rs: ResampleOptions{Every: PeriodMonth},
+			rows: "[[2024-01-01 4 20] [2024-02-01 1 2]]",
+		},
+		{
+			name: "day", dates: []string{"2024-02-28 23:59:59", "2024-03-01", "2024-02-28", "2024-02-28T08:00:00Z", "", "x"},
+			rows: "[[2024-02-28 3 15] [2024-02-29 0 0] [2024-03-01 1 5]]",
+		},
+		{
+			// a custom layout, the ISO dates no longer parse
+			name: "layout", dates: []string{"03/01/2024", "2024-01-04", "01/01/2024", "31/01/2024", "x", "y"},
+			rs:   ResampleOptions{Layout: "02/01/2006", Every: PeriodMonth},
+			rows: "[[2024-01-01 3 15]]",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.rs.Date = "date"
+			tc.rs.Aggregates = []string{"count(*)", "sum(price)"}
+			table, _, err := Resample(seriesFile(t, tc.dates...), Options{}, tc.rs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(table.Columns) != "[period count(*) sum(price)]" || table.Total != len(table.Rows) {
+				t.Errorf("columns %v and a total of %d", table.Columns, table.Total)
+			}
+			if got := fmt.Sprint(table.Rows); got != tc.rows {
+				t.Errorf("rows %s, want %s", got, tc.rows)
+			}
+			var counted int64
+			for _, row := range table.Rows {
+				counted += row[1].(int64)
+			}
+			if counted+table.Skipped != int64(len(tc.dates)) {
+				t.Errorf("%d records counted and %d skipped out of %d", counted, table.Skipped, len(tc.dates))
+			}
+		})
+	}
+}
+
+func TestRolling(t *testing.T) {
+	path := seriesFile(t, seriesDates...)
+	rs := ResampleOptions{Date: "date", Every: PeriodWeek, Aggregates: []string{"sum(price)"}}
+	tests := []struct {
+		function string
+		window   int
+		column   string
+		values   string
+	}{
+		// undefined until the window is full
+		{RollingMean, 2, "mean_2(sum(price))", "[<nil> 8 2 2 0 1]"},
+		{RollingSum, 3, "sum_3(sum(price))", "[<nil> <nil> 20 4 4 2]"},
+		{RollingSum, 1, "sum_1(sum(price))", "[16 0 4 0 0 2]"},
+		{"CumSum", 0, "cumsum(sum(price))", "[16 16 20 20 20 22]"},
+	}
+	for _, tc := range tests {
+		table, _, err := Rolling(path, Options{}, RollingOptions{ResampleOptions: rs, Function: tc.function, Window: tc.window})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rollingValues(table); table.Columns[2] != tc.column || got != tc.values {
+			t.Errorf("%s over %d: %s %s, want %s %s", tc.function, tc.window, table.Columns[2], got, tc.column, tc.values)
+		}
+	}
+
+	// the mean of an empty week is undefined and left out of the window
+	rs.Aggregates = []string{"mean(price)"}
+	table, _, err := Rolling(path, Options{}, RollingOptions{ResampleOptions: rs, Function: RollingMean, Window: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rollingValues(table); got != "[<nil> 5.333333333333334 4 4 <nil> 2]" {
+		t.Errorf("rolling mean of the means %s", got)
+	}
+}
+
+// rollingValues returns the rolling column of a table resampled to a single aggregate.
+func rollingValues(table *Table) string {
+	var values []interface{}
+	for _, row := range table.Rows {
+		values = append(values, row[2])
+	}
+	return fmt.Sprint(values)
+}
+
+func TestTimeSeriesOptions(t *testing.T) {
+	path := seriesFile(t, seriesDates...)
+	for _, ro := range []RollingOptions{
+		{ResampleOptions: ResampleOptions{Date: "date"}, Function: RollingMean},
+		{ResampleOptions: ResampleOptions{Date: "date"}, Function: "median", Window: 2},
+		{ResampleOptions: ResampleOptions{Date: "date", Every: "year"}, Function: RollingCumulative},
+		{ResampleOptions: ResampleOptions{}, Function: RollingCumulative},
+	} {
+		var paramErr *ParameterError
+		if _, _, err := Rolling(path, Options{}, ro); !errors.As(err, &paramErr) {
+			t.Errorf("%+v: error %v, want a ParameterError", ro, err)
+		}
+	}
+	var notFound *ColumnNotFoundError
+	if _, _, err := Resample(path, Options{}, ResampleOptions{Date: "day"}); !errors.As(err, &notFound) {
+		t.Errorf("error %v, want a ColumnNotFoundError", err)
+	}
+}
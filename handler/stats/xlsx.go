@@ -0,0 +1,115 @@
+package stats
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// xlsxParts are the parts of a workbook holding a single worksheet, other than the worksheet itself.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`},
+}
+
+//WriteXLSX
+/*
+Writes the table to `w` as an Excel workbook with a single worksheet, the header in the first row.
+Numbers are written as numeric cells, strings as inline strings and undefined values as empty cells.
+*/
+func (t *Table) WriteXLSX(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		f, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	f, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if err := t.writeSheet(f); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (t *Table) writeSheet(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	header := make([]interface{}, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = c
+	}
+	for i, row := range append([][]interface{}{header}, t.Rows...) {
+		sb.WriteString(`<row r="` + strconv.Itoa(i+1) + `">`)
+		for j, v := range row {
+			ref := columnName(j) + strconv.Itoa(i+1)
+			switch v := v.(type) {
+			case string:
+				sb.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+				xml.EscapeText(&sb, []byte(strings.Map(xmlChar, v)))
+				sb.WriteString(`</t></is></c>`)
+			case int64:
+				sb.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+			case float64:
+				if !math.IsNaN(v) && !math.IsInf(v, 0) {
+					sb.WriteString(`<c r="` + ref + `"><v>` + strconv.FormatFloat(v, 'g', -1, 64) + `</v></c>`)
+				}
+			}
+		}
+		sb.WriteString(`</row>`)
+		// flush every so often so large tables are not held in memory twice
+		if sb.Len() > 1<<16 {
+			if _, err := io.WriteString(w, sb.String()); err != nil {
+				return err
+			}
+			sb.Reset()
+		}
+	}
+	sb.WriteString(`</sheetData></worksheet>`)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// columnName returns the name of the column at the zero based index `i` of a worksheet, e.g. 'A', 'Z' or 'AA'.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xmlChar drops the characters that cannot appear in an XML document.
+func xmlChar(r rune) rune {
+	if r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r != 0xFFFE && r != 0xFFFF {
+		return r
+	}
+	return -1
+}
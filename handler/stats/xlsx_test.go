@@ -0,0 +1,106 @@
+package stats
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 1: "B", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA", 16383: "XFD"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+// sheetCell is a cell of a worksheet as read back from its XML.
+type sheetCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline string `xml:"is>t"`
+}
+
+func TestWriteXLSX(t *testing.T) {
+	table := &Table{
+		Columns: []string{"payer", "count", "mean"},
+		Rows: [][]interface{}{
+			{"A & <B>", int64(3), 2.5},
+			{"tab\tand\x01control", int64(0), nil},
+			{"C", int64(-1), math.NaN()},
+		},
+	}
+	var buf bytes.Buffer
+	if err := table.WriteXLSX(&buf); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string][]byte)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = b
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		b, ok := parts[name]
+		if !ok {
+			t.Errorf("no part %s", name)
+			continue
+		}
+		// every part is well formed
+		d := xml.NewDecoder(bytes.NewReader(b))
+		for {
+			if _, err := d.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Ref   string      `xml:"r,attr"`
+			Cells []sheetCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]sheetCell{
+		{{"A1", "inlineStr", "", "payer"}, {"B1", "inlineStr", "", "count"}, {"C1", "inlineStr", "", "mean"}},
+		{{"A2", "inlineStr", "", "A & <B>"}, {"B2", "", "3", ""}, {"C2", "", "2.5", ""}},
+		// the character that cannot appear in XML is dropped, undefined values are left out
+		{{"A3", "inlineStr", "", "tab\tandcontrol"}, {"B3", "", "0", ""}},
+		{{"A4", "inlineStr", "", "C"}, {"B4", "", "-1", ""}},
+	}
+	if len(sheet.Rows) != len(want) {
+		t.Fatalf("%d rows, want %d", len(sheet.Rows), len(want))
+	}
+	for i, row := range sheet.Rows {
+		if len(row.Cells) != len(want[i]) {
+			t.Errorf("row %s: cells %+v, want %+v", row.Ref, row.Cells, want[i])
+			continue
+		}
+		for j, c := range row.Cells {
+			if c != want[i][j] {
+				t.Errorf("row %s: cell %+v, want %+v", row.Ref, c, want[i][j])
+			}
+		}
+	}
+}
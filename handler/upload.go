@@ -0,0 +1,488 @@
+package handler
+
+import (
+	"api-3390/container"
+	"api-3390/container/predicate"
+	"api-3390/csvutil"
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const userIdFormKey = "userid"
+const fileFormKey = "file"
+const modeFormKey = "mode"
+const maxMemory = 10 << 20
+
+// maxFileSize is the largest number of bytes accepted for a single file, including files extracted from an archive.
+const maxFileSize = 1 << 30
+
+const archiveExt = ".zip"
+
+// maxArchiveEntries is the largest number of files unpacked from the archives of a single upload.
+const maxArchiveEntries = 1000
+
+// maxUnpackedSize is the largest number of bytes unpacked from the archives of a single upload,
+// a variable rather than a constant so that tests can lower it.
+var maxUnpackedSize int64 = 4 << 30
+
+const (
+	// UploadModeAtomic stores every uploaded file or none of them.
+	UploadModeAtomic = "atomic"
+	// UploadModeBestEffort stores every uploaded file that is valid and reports the ones that are not.
+	UploadModeBestEffort = "best-effort"
+)
+
+const (
+	uploadStatusUploaded = "uploaded"
+	uploadStatusFailed   = "failed"
+	uploadStatusSkipped  = "skipped"
+)
+
+type UploadResult struct {
+	Name   string          `json:"name"`
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	File   *container.File `json:"file,omitempty"`
+}
+
+type UploadResponse struct {
+	Mode     string          `json:"mode"`
+	Uploaded int             `json:"uploaded"`
+	Failed   int             `json:"failed"`
+	Results  []*UploadResult `json:"results"`
+}
+
+//stagedFile
+/*
+A file received in an upload that has been written to a temporary file next to its destination,
+`result` is reported back to the client.
+*/
+type stagedFile struct {
+	result  *UploadResult
+	file    *container.File
+	temp    string
+	dst     string
+	backup  string
+	stored  bool
+	invalid bool
+}
+
+//HandleCreateFile
+/*
+This function creates file entries in the database assigned to the 'userid' passed in the form value.
+
+The function uses a multipart form to upload files and requires:
+
+a form value for the '<userIdFormKey>' must be provided as a string. e.g. <userIdFormKey>="2"
+one or more form values for the '<fileFormKey>' must be provided as content-disposition,
+a sample powershell script is provided under resources/file-script.txt
+
+A '.zip' archive may be uploaded in place of a file, every file in the archive is extracted and uploaded on its own,
+directories inside the archive are ignored so entries are named after their base name.
+
+The form value '<modeFormKey>' selects how failures are handled:
+'atomic' (the default) stores every file or none of them, 'best-effort' stores every file that is valid.
+The response is a JSON `UploadResponse` reporting the outcome of each file.
+
+The limit for holding a file in memory is specified in bytes under <maxMemory>.
+
+The 'userid' retrieved from the form is tested using the predicates provided in 'idPredicates',
+
+Each file has it's file extension tested against keys in the map,
+so e.g. if only '.csv' is present in the map, only '.csv' files can be uploaded.
+predicates under that file extension are used to test the file provided. e.g. '.csv: { somePredicate },
+where somePredicate is used to test the file provided.
+
+Once a file passes its predicates, its size, sniffed MIME type, SHA-256, delimiter, header row,
+row count and column count are recorded with the file entry, see csvutil.Inspect.
+*/
+func (a *API) HandleCreateFile(fileTypeMap map[string][]predicate.Predicate[io.Reader], idPredicates []predicate.Predicate[string]) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseMultipartForm(maxMemory)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		userid := r.FormValue(userIdFormKey)
+		for _, p := range idPredicates {
+			if !p.Test(userid) {
+				http.Error(w, p.ErrorMessage(userid), http.StatusBadRequest)
+				return
+			}
+		}
+		parsedId, err := strconv.ParseUint(userid, 10, 32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mode := r.FormValue(modeFormKey)
+		if mode == "" {
+			mode = UploadModeAtomic
+		}
+		if mode != UploadModeAtomic && mode != UploadModeBestEffort {
+			http.Error(w, fmt.Sprintf("mode must be '%s' or '%s'", UploadModeAtomic, UploadModeBestEffort), http.StatusBadRequest)
+			return
+		}
+		headers := r.MultipartForm.File[fileFormKey]
+		if len(headers) == 0 {
+			http.Error(w, "unable to create file", http.StatusBadRequest)
+			return
+		}
+		dir := filepath.Dir(userFilePath(uint32(parsedId), "_"))
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		u := &uploader{
+			userId:      uint32(parsedId),
+			dir:         dir,
+			fileTypeMap: fileTypeMap,
+			names:       make(map[string]bool),
+		}
+		defer u.cleanup()
+		for _, h := range headers {
+			if strings.EqualFold(filepath.Ext(h.Filename), archiveExt) {
+				u.stageArchive(h)
+			} else {
+				u.stageFormFile(h)
+			}
+		}
+
+		if mode == UploadModeAtomic {
+			a.storeAll(u.staged)
+		} else {
+			for _, s := range u.staged {
+				if !s.invalid {
+					a.storeAll([]*stagedFile{s})
+				}
+			}
+		}
+
+		response := UploadResponse{Mode: mode, Results: make([]*UploadResult, 0, len(u.staged))}
+		for _, s := range u.staged {
+			switch s.result.Status {
+			case uploadStatusUploaded:
+				response.Uploaded++
+			case uploadStatusFailed:
+				response.Failed++
+			}
+			response.Results = append(response.Results, s.result)
+		}
+		status := http.StatusOK
+		if response.Failed > 0 {
+			if response.Uploaded == 0 {
+				status = http.StatusBadRequest
+			} else {
+				status = http.StatusMultiStatus
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+//storeAll
+/*
+Stores every file in `staged` or none of them: the file entries are written in a single transaction
+and the staged data is renamed into place, backing up files that are replaced.
+Any file that failed validation fails the whole batch and the other files are reported as skipped.
+*/
+func (a *API) storeAll(staged []*stagedFile) {
+	for _, s := range staged {
+		if s.invalid {
+			for _, other := range staged {
+				if !other.invalid {
+					other.result.Status = uploadStatusSkipped
+					other.result.Error = fmt.Sprintf("not stored because '%s' failed", s.result.Name)
+				}
+			}
+			return
+		}
+	}
+	files := make([]*container.File, 0, len(staged))
+	var replaced []*container.File
+	for _, s := range staged {
+		files = append(files, s.file)
+		if f, err := a.Services.FileService.GetUserFileByName(s.file.UserID, s.file.Name); err == nil && f != nil {
+			replaced = append(replaced, f)
+		}
+	}
+	store, undo := stagedMoves(staged)
+	if err := a.Services.FileService.SaveFileEntries(files, store, undo); err != nil {
+		for _, s := range staged {
+			s.result.Status = uploadStatusFailed
+			s.result.Error = err.Error()
+		}
+		return
+	}
+	removeBackups(staged)
+	a.invalidateResults(replaced...)
+	for _, s := range staged {
+		s.result.Status = uploadStatusUploaded
+		s.result.File = s.file
+		if f, err := a.Services.FileService.GetFileById(s.file.ID); err == nil && f != nil {
+			s.result.File = f
+		}
+	}
+}
+
+//stagedMoves
+/*
+Returns the functions moving every file in `staged` into place, backing up files that are replaced,
+and moving them back to where they were staged, restoring the backups.
+*/
+func stagedMoves(staged []*stagedFile) (store func() error, undo func() error) {
+	store = func() error {
+		for _, s := range staged {
+			if _, err := os.Stat(s.dst); err == nil {
+				s.backup = s.temp + ".bak"
+				if err := os.Rename(s.dst, s.backup); err != nil {
+					s.backup = ""
+					return err
+				}
+			}
+			if err := os.Rename(s.temp, s.dst); err != nil {
+				return err
+			}
+			s.stored = true
+		}
+		return nil
+	}
+	undo = func() error {
+		var errs []error
+		for _, s := range staged {
+			if s.stored {
+				errs = append(errs, os.Rename(s.dst, s.temp))
+				s.stored = false
+			}
+			if s.backup != "" {
+				errs = append(errs, os.Rename(s.backup, s.dst))
+				s.backup = ""
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return store, undo
+}
+
+//removeBackups
+/*
+Removes the backups of the files that were replaced once every file in `staged` is stored.
+*/
+func removeBackups(staged []*stagedFile) {
+	for _, s := range staged {
+		if s.backup != "" {
+			os.Remove(s.backup)
+			s.backup = ""
+		}
+	}
+}
+
+type uploader struct {
+	userId      uint32
+	dir         string
+	fileTypeMap map[string][]predicate.Predicate[io.Reader]
+	names       map[string]bool
+	staged      []*stagedFile
+	// entries and unpacked count the files and bytes unpacked from archives so far
+	entries  int
+	unpacked int64
+}
+
+func (u *uploader) stageFormFile(h *multipart.FileHeader) {
+	f, err := h.Open()
+	if err != nil {
+		u.fail(h.Filename, err)
+		return
+	}
+	defer f.Close()
+	u.stage(h.Filename, h.Filename, f)
+}
+
+//stageArchive
+/*
+Stages every regular file in the '.zip' archive `h`, reporting each entry as '<archive>/<entry>'.
+An archive is failed as a whole, and the files staged from it removed, once the archives of the upload hold more than
+<maxArchiveEntries> files or unpack to more than <maxUnpackedSize> bytes, so that an archive compressed to a fraction
+of its size cannot fill the user's directory.
+*/
+func (u *uploader) stageArchive(h *multipart.FileHeader) {
+	f, err := h.Open()
+	if err != nil {
+		u.fail(h.Filename, err)
+		return
+	}
+	defer f.Close()
+	archive, err := zip.NewReader(f, h.Size)
+	if err != nil {
+		u.fail(h.Filename, fmt.Errorf("unable to read archive: %w", err))
+		return
+	}
+	var entries []*zip.File
+	for _, entry := range archive.File {
+		if !entry.FileInfo().IsDir() && !strings.HasPrefix(entry.Name, "__MACOSX/") {
+			entries = append(entries, entry)
+		}
+	}
+	if u.entries += len(entries); u.entries > maxArchiveEntries {
+		u.fail(h.Filename, fmt.Errorf("archives hold more than %d files", maxArchiveEntries))
+		return
+	}
+	first := len(u.staged)
+	for _, entry := range entries {
+		display := h.Filename + "/" + entry.Name
+		rc, err := entry.Open()
+		if err != nil {
+			u.fail(display, err)
+			continue
+		}
+		// the sizes recorded in the archive are not trusted, the bytes actually unpacked are counted
+		budget := &io.LimitedReader{R: rc, N: maxUnpackedSize - u.unpacked + 1}
+		n := budget.N
+		u.stage(display, path.Base(entry.Name), budget)
+		rc.Close()
+		if u.unpacked += n - budget.N; u.unpacked > maxUnpackedSize {
+			u.failStaged(u.staged[first:], fmt.Errorf("archives unpack to more than %d bytes", maxUnpackedSize))
+			return
+		}
+	}
+}
+
+//stage
+/*
+Validates the file `name` read from `r` and writes it to a temporary file in the user's directory,
+the file is recorded as failed if its name, type or content is not accepted.
+*/
+func (u *uploader) stage(display, name string, r io.Reader) {
+	if !predicate.AllowedCharacters.Test(name) {
+		u.fail(display, errors.New(predicate.AllowedCharacters.ErrorMessage(name)))
+		return
+	}
+	predicates, exists := u.fileTypeMap[filepath.Ext(name)]
+	if !exists {
+		u.fail(display, errors.New("file type not supported"))
+		return
+	}
+	if u.names[name] {
+		u.fail(display, fmt.Errorf("'%s' is uploaded more than once", name))
+		return
+	}
+	u.names[name] = true
+
+	temp, err := os.CreateTemp(u.dir, ".upload-*")
+	if err != nil {
+		u.fail(display, err)
+		return
+	}
+	defer temp.Close()
+	// temporary files are private by default, match the permissions of files created with os.Create
+	temp.Chmod(0644)
+	s := &stagedFile{
+		result: &UploadResult{Name: display},
+		temp:   temp.Name(),
+		dst:    userFilePath(u.userId, name),
+	}
+	u.staged = append(u.staged, s)
+	written, err := io.Copy(temp, io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		u.invalidate(s, err)
+		return
+	}
+	if written > maxFileSize {
+		u.invalidate(s, errors.New("file is too large"))
+		return
+	}
+	for _, p := range predicates {
+		if _, err := temp.Seek(0, io.SeekStart); err != nil {
+			u.invalidate(s, err)
+			return
+		}
+		if !p.Test(temp) {
+			u.invalidate(s, errors.New(p.ErrorMessage(temp)))
+			return
+		}
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		u.invalidate(s, err)
+		return
+	}
+	meta, err := csvutil.Inspect(temp)
+	if err != nil {
+		u.invalidate(s, err)
+		return
+	}
+	s.file = &container.File{
+		UserID: u.userId,
+		Name:   name,
+	}
+	setFileMetadata(s.file, meta)
+}
+
+func (u *uploader) fail(display string, err error) {
+	u.staged = append(u.staged, &stagedFile{
+		result:  &UploadResult{Name: display, Status: uploadStatusFailed, Error: err.Error()},
+		invalid: true,
+	})
+}
+
+//failStaged
+/*
+Fails every file in `staged` with `err` and removes the data staged for them.
+*/
+func (u *uploader) failStaged(staged []*stagedFile, err error) {
+	for _, s := range staged {
+		u.invalidate(s, err)
+		if s.temp != "" {
+			os.Remove(s.temp)
+			s.temp = ""
+		}
+	}
+}
+
+func (u *uploader) invalidate(s *stagedFile, err error) {
+	s.invalid = true
+	s.result.Status = uploadStatusFailed
+	s.result.Error = err.Error()
+}
+
+//cleanup
+/*
+Removes the temporary files of every staged file that was not moved into place.
+*/
+func (u *uploader) cleanup() {
+	for _, s := range u.staged {
+		if s.temp != "" && !s.stored {
+			os.Remove(s.temp)
+		}
+	}
+}
+
+//setFileMetadata
+/*
+Copies the metadata captured by csvutil.Inspect onto the file entry `f`.
+*/
+func setFileMetadata(f *container.File, m *csvutil.Metadata) {
+	f.Size = m.Size
+	f.MimeType = m.MimeType
+	f.SHA256 = m.SHA256
+	f.Delimiter = string(m.Delimiter)
+	f.Header = m.Header
+	f.RowCount = m.RowCount
+	f.ColumnCount = m.ColumnCount
+}
@@ -0,0 +1,270 @@
+package handler
+
+import (
+	"api-3390/const"
+	"api-3390/container/predicate"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type formFile struct {
+	name    string
+	content []byte
+}
+
+func csvFile(name, content string) formFile {
+	return formFile{name: name, content: []byte(content)}
+}
+
+// zipFile returns an archive named `name` holding `entries`, alternately the name and the content of each entry,
+// names ending with a slash are added as directories.
+func zipFile(t *testing.T, name string, entries ...string) formFile {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return formFile{name: name, content: buf.Bytes()}
+}
+
+// upload posts `files` for the user `userId` with the upload `mode` and decodes the response.
+func upload(t *testing.T, api *API, userId, mode string, files ...formFile) (int, *UploadResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(userIdFormKey, userId)
+	if mode != "" {
+		mw.WriteField(modeFormKey, mode)
+	}
+	for _, f := range files {
+		w, err := mw.CreateFormFile(fileFormKey, f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.content)
+	}
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/files", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	api.HandleCreateFile(constants.FileMap, []predicate.Predicate[string]{predicate.NonNegative, predicate.AllowedCharacters})(w, r)
+	if w.Header().Get("Content-Type") != "application/json" {
+		return w.Code, nil
+	}
+	var response UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response %s: %v", w.Body.String(), err)
+	}
+	return w.Code, &response
+}
+
+// statuses returns the status of each result of `response` by name.
+func statuses(response *UploadResponse) map[string]string {
+	m := make(map[string]string)
+	for _, r := range response.Results {
+		m[r.Name] = r.Status
+	}
+	return m
+}
+
+// storedNames returns the names of the files stored for the user `userId`, failing on staging leftovers.
+func storedNames(t *testing.T, userId uint32) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(userFilePath(userId, "_")))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".upload-") {
+			t.Errorf("staged data %s was left behind", e.Name())
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkStatuses(t *testing.T, response *UploadResponse, want map[string]string) {
+	t.Helper()
+	if response == nil {
+		t.Fatal("no upload response")
+	}
+	if got := statuses(response); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("statuses %v, want %v", got, want)
+	}
+}
+
+func checkNames(t *testing.T, userId uint32, want ...string) {
+	t.Helper()
+	if got := storedNames(t, userId); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("stored files %v, want %v", got, want)
+	}
+}
+
+func TestUploadAtomic(t *testing.T) {
+	api, db := newTestAPI(t)
+	addFile(t, db, 1, "a.csv", "old\n1\n")
+
+	status, response := upload(t, api, "1", "", csvFile("a.csv", "x,y\n1,2\n"), csvFile("b.csv", "z\n3\n"))
+	if status != http.StatusOK || response.Mode != UploadModeAtomic || response.Uploaded != 2 {
+		t.Fatalf("status %d, response %+v", status, response)
+	}
+	checkNames(t, 1, "a.csv", "b.csv")
+	if got, _ := storedFile(t, 1, "a.csv"); got != "x,y\n1,2\n" {
+		t.Errorf("a.csv holds %q after being replaced", got)
+	}
+	// a replaced file keeps its entry
+	if f := response.Results[0].File; f == nil || f.ID != 1 || f.RowCount != 1 || f.ColumnCount != 2 {
+		t.Errorf("replaced entry %+v", f)
+	}
+
+	// a file without a number fails the batch
+	status, response = upload(t, api, "1", UploadModeAtomic, csvFile("c.csv", "c\n4\n"), csvFile("d.csv", "d\nx\n"))
+	if status != http.StatusBadRequest {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{"c.csv": uploadStatusSkipped, "d.csv": uploadStatusFailed})
+	checkNames(t, 1, "a.csv", "b.csv")
+	if files, _ := api.Services.FileService.GetUserFiles(1); len(files) != 2 {
+		t.Errorf("%d entries after a failed upload", len(files))
+	}
+}
+
+func TestUploadUndo(t *testing.T) {
+	api, db := newTestAPI(t)
+	// the owner is checked on commit, so uploading for a user that does not exist fails after the data was stored
+	for _, stmt := range []string{"DROP TABLE user_files", strings.Replace(constants.UserFileTable, "ON DELETE CASCADE",
+		"ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED", 1)} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := userFilePath(9, "a.csv")
+	os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err := os.WriteFile(path, []byte("old\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	status, response := upload(t, api, "9", "", csvFile("a.csv", "new\n2\n"), csvFile("b.csv", "b\n3\n"))
+	if status != http.StatusBadRequest {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{"a.csv": uploadStatusFailed, "b.csv": uploadStatusFailed})
+	// the replaced file is restored from its backup and the new one removed
+	checkNames(t, 9, "a.csv")
+	if got, _ := storedFile(t, 9, "a.csv"); got != "old\n1\n" {
+		t.Errorf("a.csv holds %q, want its old content", got)
+	}
+}
+
+func TestUploadBestEffort(t *testing.T) {
+	api, _ := newTestAPI(t)
+	status, response := upload(t, api, "1", UploadModeBestEffort,
+		csvFile("a.csv", "a\n1\n"), csvFile("b.txt", "b\n2\n"), csvFile("a.csv", "a\n3\n"), csvFile("c.csv", "c\nx\n"))
+	if status != http.StatusMultiStatus || response.Uploaded != 1 || response.Failed != 3 {
+		t.Fatalf("status %d, response %+v", status, response)
+	}
+	for _, r := range response.Results[1:] {
+		if r.Status != uploadStatusFailed || r.Error == "" {
+			t.Errorf("result %+v, want a failure", r)
+		}
+	}
+	checkNames(t, 1, "a.csv")
+	if got, _ := storedFile(t, 1, "a.csv"); got != "a\n1\n" {
+		t.Errorf("a.csv holds %q", got)
+	}
+}
+
+func TestUploadParameters(t *testing.T) {
+	api, _ := newTestAPI(t)
+	for _, tc := range []struct{ userId, mode string }{{"-1", ""}, {"x", ""}, {"1", "some"}} {
+		if status, _ := upload(t, api, tc.userId, tc.mode, csvFile("a.csv", "a\n1\n")); status != http.StatusBadRequest {
+			t.Errorf("user %q mode %q: status %d", tc.userId, tc.mode, status)
+		}
+	}
+	if status, _ := upload(t, api, "1", ""); status != http.StatusBadRequest {
+		t.Errorf("no files: status %d", status)
+	}
+	checkNames(t, 1)
+}
+
+func TestUploadArchive(t *testing.T) {
+	api, _ := newTestAPI(t)
+	archive := zipFile(t, "data.zip",
+		"dir/", "",
+		"dir/a.csv", "a\n1\n",
+		"b.csv", "b\n2\n",
+		"__MACOSX/dir/._a.csv", "resource fork")
+	status, response := upload(t, api, "1", "", archive, csvFile("c.csv", "c\n3\n"))
+	if status != http.StatusOK {
+		t.Fatalf("status %d, response %+v", status, response)
+	}
+	checkStatuses(t, response, map[string]string{
+		"data.zip/dir/a.csv": uploadStatusUploaded, "data.zip/b.csv": uploadStatusUploaded, "c.csv": uploadStatusUploaded})
+	checkNames(t, 1, "a.csv", "b.csv", "c.csv")
+
+	// entries are named after their base name, so two of the same name conflict
+	status, response = upload(t, api, "1", "", zipFile(t, "twice.zip", "x/d.csv", "d\n1\n", "y/d.csv", "d\n2\n"))
+	if status != http.StatusBadRequest {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{"twice.zip/x/d.csv": uploadStatusSkipped, "twice.zip/y/d.csv": uploadStatusFailed})
+
+	if status, response = upload(t, api, "1", "", formFile{name: "broken.zip", content: []byte("not an archive")}); status != http.StatusBadRequest {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{"broken.zip": uploadStatusFailed})
+	checkNames(t, 1, "a.csv", "b.csv", "c.csv")
+}
+
+func TestUploadArchiveLimits(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	entries := make([]string, 0, 2*(maxArchiveEntries+1))
+	for i := 0; i <= maxArchiveEntries; i++ {
+		entries = append(entries, fmt.Sprintf("f%d.csv", i), "v\n1\n")
+	}
+	status, response := upload(t, api, "1", UploadModeBestEffort, zipFile(t, "many.zip", entries...), csvFile("a.csv", "a\n1\n"))
+	if status != http.StatusMultiStatus {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{"many.zip": uploadStatusFailed, "a.csv": uploadStatusUploaded})
+	checkNames(t, 1, "a.csv")
+
+	defer func(limit int64) { maxUnpackedSize = limit }(maxUnpackedSize)
+	maxUnpackedSize = 350
+	content := "v\n" + strings.Repeat("1", 98)
+	status, response = upload(t, api, "1", UploadModeBestEffort,
+		zipFile(t, "small.zip", "b.csv", content, "c.csv", content),
+		// the second entry goes over the budget, failing the entries of its archive that were already staged
+		zipFile(t, "large.zip", "d.csv", content, "e.csv", content, "f.csv", content))
+	if status != http.StatusMultiStatus {
+		t.Errorf("status %d", status)
+	}
+	checkStatuses(t, response, map[string]string{
+		"small.zip/b.csv": uploadStatusUploaded, "small.zip/c.csv": uploadStatusUploaded,
+		"large.zip/d.csv": uploadStatusFailed, "large.zip/e.csv": uploadStatusFailed})
+	checkNames(t, 1, "a.csv", "b.csv", "c.csv")
+}
@@ -6,7 +6,9 @@ import (
 	"api-3390/container/predicate"
 	"api-3390/handler"
 	"api-3390/handler/middleware"
+	"api-3390/resultcache"
 	"api-3390/service"
+	"database/sql"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -40,13 +42,42 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	services := handler.NewServices(service.NewAuthService(db), service.NewFileService(db), service.NewUserService(db))
-	api := handler.API{Services: services}
+	if err := addMissingColumns(db, "user_files", constants.UserFileMetadataColumns); err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(constants.FileLineageTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = db.Exec(constants.JobTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cacheDB *sql.DB
+	if cfg.ResultCachePersist {
+		_, err = db.Exec(constants.ResultCacheTable)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cacheDB = db
+	}
+	cache, err := resultcache.New(cfg.ResultCacheSize, cacheDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	services := handler.NewServices(service.NewAuthService(db), service.NewFileService(db), service.NewUserService(db),
+		service.NewJobService(db))
+	api := handler.API{Services: services, Cache: cache}
+	api.Jobs = handler.NewJobRunner(&api, cfg.JobWorkers)
+	if err := api.Jobs.Start(); err != nil {
+		log.Fatal(err)
+	}
 	r := chi.NewRouter()
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:5173"}, // Frontend origin
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-KEY"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-KEY", "Range", "If-Range", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"Content-Disposition", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified", "X-Total-Count", "X-Cache"},
 		AllowCredentials: false,
 		MaxAge:           300, // Max cache age in seconds ??
 	}))
@@ -75,6 +106,13 @@ func main() {
 			})).Put("/", api.HandleUpdateUserById)
 			r.Route("/files", func(r chi.Router) {
 				r.Get("/", api.HandleGetUserFiles)
+				r.Get("/archive", api.HandleGetUserFilesArchive)
+				r.With(middleware.InterceptJson(map[string][]predicate.Predicate[string]{
+					"query": {predicate.IsNotEmpty},
+				})).Post("/sql", api.HandleQueryUserFiles)
+				r.With(middleware.InterceptJson(map[string][]predicate.Predicate[string]{
+					"name": {predicate.IsNotEmpty, predicate.AllowedCharacters},
+				})).Post("/join", api.HandleJoinUserFiles)
 				r.Route("/{file_name}", func(r chi.Router) {
 					r.Use(middleware.URLParam("file_name", predicate.AllowedCharacters))
 					r.Delete("/", api.HandleDeleteUserFileByName)
@@ -94,9 +132,25 @@ func main() {
 		r.Route("/{file_id}", func(r chi.Router) {
 			r.Use(middleware.URLParam("file_id", predicate.AllowedCharacters, predicate.NonNegative))
 			r.Get("/", api.HandleGetFileById)
-			r.Put("/", api.HandleUpdateFileById)
+			r.Get("/lineage", api.HandleGetFileLineage)
+			r.Post("/jobs", api.HandleCreateFileJob)
+			r.With(middleware.InterceptJson(map[string][]predicate.Predicate[string]{
+				"name": {predicate.IsNotEmpty, predicate.AllowedCharacters},
+			})).Put("/", api.HandleUpdateFileById)
+			r.With(middleware.InterceptJson(map[string][]predicate.Predicate[string]{
+				"name": {predicate.IsNotEmpty, predicate.AllowedCharacters},
+			})).Post("/rename", api.HandleRenameFileById)
+			r.Post("/transfer", api.HandleTransferFileById)
 		})
 	})
+
+	// Job Routes
+	r.Route("/jobs/{job_id}", func(r chi.Router) {
+		r.Use(middleware.URLParam("job_id", predicate.AllowedCharacters, predicate.NonNegative))
+		r.Get("/", api.HandleGetJobById)
+		r.Get("/result", api.HandleGetJobResult)
+		r.Post("/cancel", api.HandleCancelJob)
+	})
 	log.Println(fmt.Sprintf("Starting server on: '%s'", cfg.Address))
 	if err := http.ListenAndServe(cfg.Address, r); err != nil {
 		log.Fatal(err)
@@ -118,3 +172,41 @@ func applicationMiddleWare(cfg *config.Config) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+//addMissingColumns
+/*
+Adds each column definition in `columns` e.g. "size INTEGER NOT NULL DEFAULT 0" to `table`
+if the table does not already have a column of that name.
+*/
+func addMissingColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if existing[strings.Fields(column)[0]] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
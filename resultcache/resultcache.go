@@ -0,0 +1,183 @@
+package resultcache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultSize is the number of results held in memory when no size is given.
+const DefaultSize = 1024
+
+// MaxEntrySize bounds the size in bytes of a result that is cached, larger results are always recalculated.
+const MaxEntrySize = 4 << 20
+
+// persistedFactor is how many more results are kept in the database than in memory.
+const persistedFactor = 4
+
+//Entry
+/*
+A cached response: the headers it was written with and its body.
+*/
+type Entry struct {
+	Header http.Header
+	Body   []byte
+}
+
+//Cache
+/*
+Holds the responses of operations on files in memory, evicting the least recently used once it holds `size` of them.
+When it is backed by a database, results are also written to the result_cache table so they survive a restart,
+and results evicted from memory are read back from it.
+
+Results are keyed by the content hash of the file they were calculated from, see Key,
+so a file whose content changes never reads a stale result, Invalidate drops the results of a hash that is gone.
+*/
+type Cache struct {
+	entries *lru.Cache[string, *Entry]
+	db      *sql.DB
+	size    int
+}
+
+//New
+/*
+Returns a cache holding `size` results in memory, DefaultSize when it is zero,
+backed by the result_cache table of `db` unless it is nil.
+*/
+func New(size int, db *sql.DB) (*Cache, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	entries, err := lru.New[string, *Entry](size)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{entries: entries, db: db, size: size}, nil
+}
+
+// listParams are the parameters read as comma delimited lists whose entries are trimmed, see Key.
+var listParams = map[string]bool{
+	"columns": true, "metrics": true, "percentiles": true, "rows": true, "cols": true, "totals": true, "edges": true,
+	"by": true, "sort": true, "x": true, "categorical": true,
+}
+
+//Key
+/*
+Returns the key of the result of `operation` on the content with the hash `version`, requested with `params`.
+The parameters are normalised so that requests differing only in the order of their parameters
+or empty parameters share a key. Whitespace around the entries of the lists in <listParams> is trimmed as well,
+the order of the entries is kept, every other value is kept exactly as it was sent as it may be free text,
+e.g. a filter expression where "a, b" and "a,b" differ.
+*/
+func Key(version, operation string, params url.Values) string {
+	normalized := url.Values{}
+	for name, values := range params {
+		for _, v := range values {
+			if listParams[name] {
+				fields := strings.Split(v, ",")
+				for i := range fields {
+					fields[i] = strings.TrimSpace(fields[i])
+				}
+				v = strings.Join(fields, ",")
+			}
+			if v != "" {
+				normalized.Add(name, v)
+			}
+		}
+	}
+	normalized.Set("operation", strings.TrimSpace(operation))
+	return version + ":" + normalized.Encode()
+}
+
+// keyVersion returns the content hash a key was made with.
+func keyVersion(key string) string {
+	v, _, _ := strings.Cut(key, ":")
+	return v
+}
+
+//Get
+/*
+Returns the result cached under `key`, looking it up in the database when it is no longer held in memory.
+*/
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if e, ok := c.entries.Get(key); ok {
+		return e, true
+	}
+	if c.db == nil {
+		return nil, false
+	}
+	var header, body []byte
+	err := c.db.QueryRow("SELECT header, body FROM result_cache WHERE key = ?", key).Scan(&header, &body)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("result cache: %v", err)
+		}
+		return nil, false
+	}
+	e := &Entry{Body: body}
+	if err := json.Unmarshal(header, &e.Header); err != nil {
+		log.Printf("result cache: %v", err)
+		return nil, false
+	}
+	if _, err := c.db.Exec("UPDATE result_cache SET accessed_at = CURRENT_TIMESTAMP WHERE key = ?", key); err != nil {
+		log.Printf("result cache: %v", err)
+	}
+	c.entries.Add(key, e)
+	return e, true
+}
+
+//Add
+/*
+Caches `e` under `key` unless it is larger than MaxEntrySize. Failing to write it to the database is logged,
+the result is still held in memory.
+*/
+func (c *Cache) Add(key string, e *Entry) {
+	if len(e.Body) > MaxEntrySize {
+		return
+	}
+	c.entries.Add(key, e)
+	if c.db == nil {
+		return
+	}
+	header, err := json.Marshal(e.Header)
+	if err != nil {
+		log.Printf("result cache: %v", err)
+		return
+	}
+	if _, err := c.db.Exec(`INSERT OR REPLACE INTO result_cache (key, sha256, header, body) VALUES (?,?,?,?)`,
+		key, keyVersion(key), header, e.Body); err != nil {
+		log.Printf("result cache: %v", err)
+		return
+	}
+	if _, err := c.db.Exec(`DELETE FROM result_cache WHERE key NOT IN
+                      (SELECT key FROM result_cache ORDER BY accessed_at DESC LIMIT ?)`, c.size*persistedFactor); err != nil {
+		log.Printf("result cache: %v", err)
+	}
+}
+
+//Invalidate
+/*
+Drops every result calculated from the content with the hash `version`.
+*/
+func (c *Cache) Invalidate(version string) {
+	if version == "" {
+		return
+	}
+	prefix := version + ":"
+	for _, key := range c.entries.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.entries.Remove(key)
+		}
+	}
+	if c.db == nil {
+		return
+	}
+	if _, err := c.db.Exec("DELETE FROM result_cache WHERE sha256 = ?", version); err != nil {
+		log.Printf("result cache: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package resultcache
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"parameter order", "columns=price&where=price > 0", "where=price > 0&columns=price", true},
+		{"empty parameters", "columns=price&where=", "columns=price", true},
+		{"spaces in lists", "columns=price, units&percentiles= 5,95", "columns=price,units&percentiles=5,95", true},
+		{"order within lists", "columns=price,units", "columns=units,price", false},
+		{"spaces in a filter", "where=name = 'a, b'", "where=name = 'a,b'", false},
+		{"spaces in groups", "group1=payer = 'A, B'&group2=payer = 'C'", "group1=payer = 'A,B'&group2=payer = 'C'", false},
+		{"spaces in a file name", "other=a, b.csv", "other=a,b.csv", false},
+	} {
+		a, err := url.ParseQuery(url.PathEscape(tc.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := url.ParseQuery(url.PathEscape(tc.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ka, kb := Key("sha", "stats", a), Key("sha", "stats", b)
+		if (ka == kb) != tc.same {
+			t.Errorf("%s: keys %q and %q, want same=%v", tc.name, ka, kb, tc.same)
+		}
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	key := Key("abc", "stats", url.Values{"where": {"a = 'x:y'"}})
+	if v := keyVersion(key); v != "abc" {
+		t.Errorf("version of %q is %q, want abc", key, v)
+	}
+}
@@ -3,8 +3,14 @@ package service
 import (
 	"api-3390/container"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 )
 
+// ErrFileExists is returned when a user already owns a file with the requested name.
+var ErrFileExists = errors.New("a file with that name already exists")
+
 type FileService struct {
 	*genericService[container.File, uint32]
 }
@@ -16,46 +22,233 @@ func NewFileService(db *sql.DB) *FileService {
 		},
 	}
 }
-func (fs *FileService) UserHasFileEntry(f *container.File) (bool, error) {
-	return fs.itemExists("SELECT EXISTS (SELECT 1 FROM user_files WHERE user_id = ? AND name = ?)", []interface{}{f.UserID, f.Name})
+
+// fileColumns are the user_files columns read by scanFile, in order.
+const fileColumns = "id, user_id, name, upload_time, size, mime_type, sha256, delimiter, header, row_count, column_count"
+
+//scanFile
+/*
+Scans a row selected with fileColumns into `f`, decoding the JSON encoded header row.
+*/
+func scanFile(f *container.File, rows *sql.Rows) error {
+	var header string
+	if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.UploadTime, &f.Size, &f.MimeType, &f.SHA256,
+		&f.Delimiter, &header, &f.RowCount, &f.ColumnCount); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(header), &f.Header)
+}
+
+func encodeHeader(f *container.File) (string, error) {
+	header := f.Header
+	if header == nil {
+		header = []string{}
+	}
+	b, err := json.Marshal(header)
+	return string(b), err
 }
+
 func (fs *FileService) GetUserFiles(userId uint32) ([]*container.File, error) {
-	return fs.getAllItems("SELECT id,name,upload_time FROM user_files WHERE user_id = ?", []interface{}{userId}, func(t *container.File, rows *sql.Rows) error {
-		t.UserID = userId
-		return rows.Scan(&t.ID, &t.Name, &t.UploadTime)
-	})
+	return fs.getAllItems("SELECT "+fileColumns+" FROM user_files WHERE user_id = ?", []interface{}{userId}, scanFile)
 }
-func (fs *FileService) UpdateFileEntry(f *container.File) error {
-	return fs.updateItem("UPDATE user_files SET user_id = ?, name = ? WHERE id = ?",
-		[]interface{}{f.UserID, f.Name, f.ID})
+
+//UpdateFileChecksum
+/*
+Records the size and SHA-256 of the stored data of `f`.
+*/
+func (fs *FileService) UpdateFileChecksum(f *container.File) error {
+	return fs.updateItem("UPDATE user_files SET size = ?, sha256 = ? WHERE id = ?", []interface{}{f.Size, f.SHA256, f.ID})
 }
 
+//DeleteFileById
+/*
+Deletes the file entry `k` along with its lineage, files derived from it keep their lineage without the source ID.
+*/
 func (fs *FileService) DeleteFileById(k uint32) error {
-	return fs.deleteItems("DELETE FROM user_files WHERE id = ?", []interface{}{k})
+	return fs.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM file_lineage WHERE file_id = ?", k); err != nil {
+			return fmt.Errorf("failed to execute delete: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE file_lineage SET source_id = NULL WHERE source_id = ?", k); err != nil {
+			return fmt.Errorf("failed to execute update: %w", err)
+		}
+		if _, err := tx.Exec("DELETE FROM user_files WHERE id = ?", k); err != nil {
+			return fmt.Errorf("failed to execute delete: %w", err)
+		}
+		return nil
+	})
 }
 func (fs *FileService) GetFileById(k uint32) (*container.File, error) {
-	return fs.getItem("SELECT user_id,name,upload_time FROM user_files WHERE id = ?", []interface{}{k},
-		func(f *container.File, rows *sql.Rows) error {
-			f.ID = k
-			return rows.Scan(&f.UserID, &f.Name, &f.UploadTime)
-		})
+	return fs.getItem("SELECT "+fileColumns+" FROM user_files WHERE id = ?", []interface{}{k}, scanFile)
 }
 
 func (fs *FileService) GetUserFileByName(k uint32, fileName string) (*container.File, error) {
-	return fs.getItem("SELECT id,upload_time FROM user_files WHERE user_id=? AND name=? ", []interface{}{k, fileName},
-		func(f *container.File, rows *sql.Rows) error {
-			f.UserID = k
-			f.Name = fileName
-			return rows.Scan(&f.ID, &f.UploadTime)
-		})
+	return fs.getItem("SELECT "+fileColumns+" FROM user_files WHERE user_id = ? AND name = ?", []interface{}{k, fileName}, scanFile)
 }
 func (fs *FileService) GetAllFiles() ([]*container.File, error) {
-	return fs.getAllItems("SELECT * FROM user_files", []interface{}{}, func(t *container.File, rows *sql.Rows) error {
-		return rows.Scan(&t.ID, &t.UserID, &t.Name, &t.UploadTime)
+	return fs.getAllItems("SELECT "+fileColumns+" FROM user_files", []interface{}{}, scanFile)
+}
+
+//MoveFileEntry
+/*
+Reassigns the file entry `f` to the user ID and name held by `dst` inside a single transaction.
+
+The destination is checked for a name conflict first, returning ErrFileExists if the user already has a file with that name.
+`move` is invoked after the row has been updated and is expected to relocate the stored data,
+if `move` fails the transaction is rolled back. If the transaction fails after `move` succeeded,
+`undo` is invoked to put the stored data back where it was.
+*/
+func (fs *FileService) MoveFileEntry(f *container.File, dst *container.File, move func() error, undo func() error) error {
+	moved := false
+	err := fs.transaction(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM user_files WHERE user_id = ? AND name = ? AND id != ?)",
+			dst.UserID, dst.Name, f.ID).Scan(&exists); err != nil {
+			return fmt.Errorf("error whilst checking existence: %w", err)
+		}
+		if exists {
+			return ErrFileExists
+		}
+		if _, err := tx.Exec("UPDATE user_files SET user_id = ?, name = ? WHERE id = ?", dst.UserID, dst.Name, f.ID); err != nil {
+			return fmt.Errorf("failed to execute update: %w", err)
+		}
+		if err := move(); err != nil {
+			return err
+		}
+		moved = true
+		return nil
 	})
+	if err != nil && moved {
+		if undoErr := undo(); undoErr != nil {
+			return fmt.Errorf("%w (unable to restore file: %v)", err, undoErr)
+		}
+	}
+	return err
+}
+
+//SaveFileEntries
+/*
+Creates or updates the file entries in `files` inside a single transaction,
+an entry replaces the existing entry of the same user and name if there is one, and the ID of each entry is set.
+
+`store` is invoked once every row has been written and is expected to put the stored data in place,
+if `store` fails the transaction is rolled back. Whenever the transaction fails after `store` was invoked,
+`undo` is invoked to restore whatever `store` managed to put in place.
+*/
+func (fs *FileService) SaveFileEntries(files []*container.File, store func() error, undo func() error) error {
+	storing := false
+	err := fs.transaction(func(tx *sql.Tx) error {
+		for _, f := range files {
+			if err := saveFileEntry(tx, f); err != nil {
+				return err
+			}
+		}
+		storing = true
+		return store()
+	})
+	if err != nil && storing {
+		if undoErr := undo(); undoErr != nil {
+			return fmt.Errorf("%w (unable to restore files: %v)", err, undoErr)
+		}
+	}
+	return err
 }
 
-func (fs *FileService) CreateFileEntry(f *container.File) error {
-	return fs.insertItem("INSERT INTO user_files (user_id, name) VALUES (?,?)",
-		[]interface{}{f.UserID, f.Name})
+//saveFileEntry
+/*
+Creates or updates the file entry `f` within `tx`, replacing the entry of the same user and name if there is one,
+and sets the ID of `f`.
+*/
+func saveFileEntry(tx *sql.Tx, f *container.File) error {
+	header, err := encodeHeader(f)
+	if err != nil {
+		return err
+	}
+	var id uint32
+	err = tx.QueryRow("SELECT id FROM user_files WHERE user_id = ? AND name = ?", f.UserID, f.Name).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		res, err := tx.Exec(`INSERT INTO user_files (user_id, name, size, mime_type, sha256, delimiter, header, row_count, column_count)
+                      VALUES (?,?,?,?,?,?,?,?,?)`,
+			f.UserID, f.Name, f.Size, f.MimeType, f.SHA256, f.Delimiter, header, f.RowCount, f.ColumnCount)
+		if err != nil {
+			return fmt.Errorf("failed to execute insert: %w", err)
+		}
+		inserted, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		f.ID = uint32(inserted)
+	case err != nil:
+		return fmt.Errorf("error whilst checking existence: %w", err)
+	default:
+		f.ID = id
+		if _, err := tx.Exec(`UPDATE user_files SET upload_time = CURRENT_TIMESTAMP, size = ?, mime_type = ?, sha256 = ?,
+                      delimiter = ?, header = ?, row_count = ?, column_count = ? WHERE id = ?`,
+			f.Size, f.MimeType, f.SHA256, f.Delimiter, header, f.RowCount, f.ColumnCount, f.ID); err != nil {
+			return fmt.Errorf("failed to execute update: %w", err)
+		}
+	}
+	return nil
+}
+
+//SaveDerivedFileEntry
+/*
+Creates or updates the entry of the file `f` derived from other files, as SaveFileEntries does,
+and replaces its lineage with `lineage` in the same transaction. The file ID of each lineage entry is set to the ID of `f`.
+*/
+func (fs *FileService) SaveDerivedFileEntry(f *container.File, lineage []*container.Lineage, store func() error, undo func() error) error {
+	storing := false
+	err := fs.transaction(func(tx *sql.Tx) error {
+		if err := saveFileEntry(tx, f); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM file_lineage WHERE file_id = ?", f.ID); err != nil {
+			return fmt.Errorf("failed to execute delete: %w", err)
+		}
+		for _, l := range lineage {
+			l.FileID = f.ID
+			parameters := string(l.Parameters)
+			if parameters == "" {
+				parameters = "{}"
+			}
+			if _, err := tx.Exec(`INSERT INTO file_lineage (file_id, source_id, source_name, source_sha256, operation, parameters)
+                      VALUES (?,?,?,?,?,?)`,
+				l.FileID, l.SourceID, l.SourceName, l.SourceSHA256, l.Operation, parameters); err != nil {
+				return fmt.Errorf("failed to execute insert: %w", err)
+			}
+		}
+		storing = true
+		return store()
+	})
+	if err != nil && storing {
+		if undoErr := undo(); undoErr != nil {
+			return fmt.Errorf("%w (unable to restore files: %v)", err, undoErr)
+		}
+	}
+	return err
+}
+
+//GetFileLineage
+/*
+Returns the files the file `fileId` was derived from, empty if it was uploaded.
+*/
+func (fs *FileService) GetFileLineage(fileId uint32) ([]*container.Lineage, error) {
+	lineage := &genericService[container.Lineage, uint32]{db: fs.db}
+	return lineage.getAllItems(`SELECT id, file_id, source_id, source_name, source_sha256, operation, parameters, created_at
+                      FROM file_lineage WHERE file_id = ? ORDER BY id`, []interface{}{fileId},
+		func(l *container.Lineage, rows *sql.Rows) error {
+			var sourceId sql.NullInt64
+			var parameters string
+			if err := rows.Scan(&l.ID, &l.FileID, &sourceId, &l.SourceName, &l.SourceSHA256, &l.Operation,
+				&parameters, &l.CreatedAt); err != nil {
+				return err
+			}
+			if sourceId.Valid {
+				id := uint32(sourceId.Int64)
+				l.SourceID = &id
+			}
+			l.Parameters = json.RawMessage(parameters)
+			return nil
+		})
 }
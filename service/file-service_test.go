@@ -0,0 +1,186 @@
+package service
+
+import (
+	constants "api-3390/const"
+	"api-3390/container"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestDB opens an in-memory database with the tables created by `tables`.
+func newTestDB(t *testing.T, tables ...string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to ':memory:' opens a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	for _, table := range tables {
+		if _, err := db.Exec(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// deferredFileTable is user_files with its owner checked on commit, so that moving a file to a user
+// that does not exist fails only once the transaction commits.
+var deferredFileTable = strings.Replace(constants.UserFileTable, "ON DELETE CASCADE",
+	"ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED", 1)
+
+func newTestFileService(t *testing.T, fileTable string) *FileService {
+	t.Helper()
+	db := newTestDB(t, "PRAGMA foreign_keys = ON", constants.UserTable, fileTable)
+	for _, name := range []string{"a", "b"} {
+		if _, err := db.Exec("INSERT INTO users (name, email, password) VALUES (?, ?, '')", name, name+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []container.File{{UserID: 1, Name: "a.csv"}, {UserID: 1, Name: "b.csv"}} {
+		if _, err := db.Exec("INSERT INTO user_files (user_id, name) VALUES (?, ?)", f.UserID, f.Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewFileService(db)
+}
+
+func fileEntry(t *testing.T, fs *FileService, id uint32) *container.File {
+	t.Helper()
+	f, err := fs.GetFileById(id)
+	if err != nil || f == nil {
+		t.Fatalf("file %d: %v", id, err)
+	}
+	return f
+}
+
+func TestMoveFileEntry(t *testing.T) {
+	errMove := errors.New("move failed")
+	tests := []struct {
+		name      string
+		fileTable string
+		dst       container.File
+		move      error
+		err       error
+		moved     bool
+		undone    bool
+	}{
+		{name: "rename", dst: container.File{UserID: 1, Name: "c.csv"}, moved: true},
+		{name: "transfer", dst: container.File{UserID: 2, Name: "a.csv"}, moved: true},
+		{name: "name taken", dst: container.File{UserID: 1, Name: "b.csv"}, err: ErrFileExists},
+		{name: "move fails", dst: container.File{UserID: 1, Name: "c.csv"}, move: errMove, err: errMove},
+		// the move succeeds but the commit fails, the stored data has to be put back
+		{name: "commit fails", fileTable: deferredFileTable, dst: container.File{UserID: 3, Name: "a.csv"}, undone: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			table := constants.UserFileTable
+			if tc.fileTable != "" {
+				table = tc.fileTable
+			}
+			fs := newTestFileService(t, table)
+			f := fileEntry(t, fs, 1)
+			moves, undos := 0, 0
+			err := fs.MoveFileEntry(f, &tc.dst, func() error {
+				moves++
+				return tc.move
+			}, func() error {
+				undos++
+				return nil
+			})
+			switch {
+			case tc.err != nil && !errors.Is(err, tc.err):
+				t.Fatalf("error %v, want %v", err, tc.err)
+			case tc.err == nil && tc.undone && err == nil:
+				t.Fatal("the commit did not fail")
+			case tc.err == nil && !tc.undone && err != nil:
+				t.Fatal(err)
+			}
+			if tc.err == ErrFileExists && moves != 0 {
+				t.Error("the data was moved despite the name conflict")
+			}
+			if (undos == 1) != tc.undone || undos > 1 {
+				t.Errorf("undo called %d times", undos)
+			}
+			got := fileEntry(t, fs, 1)
+			want := container.File{UserID: f.UserID, Name: f.Name}
+			if tc.moved {
+				want = tc.dst
+			}
+			if got.UserID != want.UserID || got.Name != want.Name {
+				t.Errorf("entry owned by %d named %q, want %d and %q", got.UserID, got.Name, want.UserID, want.Name)
+			}
+		})
+	}
+}
+
+func TestSaveFileEntries(t *testing.T) {
+	errStore := errors.New("store failed")
+	tests := []struct {
+		name      string
+		fileTable string
+		files     []container.File
+		store     error
+		saved     bool
+	}{
+		{name: "create and replace", files: []container.File{{UserID: 1, Name: "c.csv"}, {UserID: 1, Name: "a.csv", Size: 7}}, saved: true},
+		{name: "store fails", files: []container.File{{UserID: 1, Name: "c.csv"}, {UserID: 1, Name: "a.csv", Size: 7}}, store: errStore},
+		// every row is written and stored but the commit fails on the unknown owner of the last file
+		{name: "commit fails", fileTable: deferredFileTable, files: []container.File{{UserID: 1, Name: "c.csv"}, {UserID: 3, Name: "a.csv"}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			table := constants.UserFileTable
+			if tc.fileTable != "" {
+				table = tc.fileTable
+			}
+			fs := newTestFileService(t, table)
+			files := make([]*container.File, len(tc.files))
+			for i := range tc.files {
+				files[i] = &tc.files[i]
+			}
+			stores, undos := 0, 0
+			err := fs.SaveFileEntries(files, func() error {
+				stores++
+				return tc.store
+			}, func() error {
+				undos++
+				return nil
+			})
+			if tc.saved != (err == nil) {
+				t.Fatalf("error %v", err)
+			}
+			if tc.store != nil && !errors.Is(err, tc.store) {
+				t.Errorf("error %v, want %v", err, tc.store)
+			}
+			if stores != 1 {
+				t.Errorf("store called %d times", stores)
+			}
+			wantUndos := 1
+			if tc.saved {
+				wantUndos = 0
+			}
+			if undos != wantUndos {
+				t.Errorf("undo called %d times, want %d", undos, wantUndos)
+			}
+			entries, err := fs.GetUserFiles(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.saved {
+				if len(entries) != 2 || fileEntry(t, fs, 1).Size != 0 {
+					t.Errorf("entries changed by a failed save: %+v", entries)
+				}
+				return
+			}
+			if len(entries) != 3 || files[0].ID != 3 || files[1].ID != 1 || fileEntry(t, fs, 1).Size != 7 {
+				t.Errorf("entries %+v, saved as %d and %d", entries, files[0].ID, files[1].ID)
+			}
+		})
+	}
+}
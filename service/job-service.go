@@ -0,0 +1,181 @@
+package service
+
+import (
+	"api-3390/container"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The statuses of a job, a job is finished once it has succeeded, failed or been cancelled.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+type JobService struct {
+	*genericService[container.Job, uint32]
+}
+
+func NewJobService(db *sql.DB) *JobService {
+	return &JobService{
+		&genericService[container.Job, uint32]{
+			db: db,
+		},
+	}
+}
+
+// jobColumns are the jobs columns read by scanJob, in order.
+const jobColumns = "id, file_id, operation, query, status, progress, attempts, error, created_at, started_at, finished_at"
+
+func scanJob(j *container.Job, rows *sql.Rows) error {
+	var startedAt, finishedAt sql.NullTime
+	if err := rows.Scan(&j.ID, &j.FileID, &j.Operation, &j.Query, &j.Status, &j.Progress, &j.Attempts, &j.Error,
+		&j.CreatedAt, &startedAt, &finishedAt); err != nil {
+		return err
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return nil
+}
+
+//CreateJob
+/*
+Queues the job `j` and sets its ID, status and creation time.
+*/
+func (js *JobService) CreateJob(j *container.Job) error {
+	res, err := js.db.Exec("INSERT INTO jobs (file_id, operation, query) VALUES (?,?,?)", j.FileID, j.Operation, j.Query)
+	if err != nil {
+		return fmt.Errorf("failed to execute insert: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	created, err := js.GetJobById(uint32(id))
+	if err != nil {
+		return err
+	}
+	*j = *created
+	return nil
+}
+
+func (js *JobService) GetJobById(k uint32) (*container.Job, error) {
+	return js.getItem("SELECT "+jobColumns+" FROM jobs WHERE id = ?", []interface{}{k}, scanJob)
+}
+
+//ClaimJob
+/*
+Marks the oldest queued job as running and returns it, or nil when no job is queued.
+The job is claimed in a single statement so that each job is only ever claimed by one worker.
+*/
+func (js *JobService) ClaimJob() (*container.Job, error) {
+	var id uint32
+	err := js.db.QueryRow(`UPDATE jobs SET status = ?, attempts = attempts + 1, progress = 0, started_at = CURRENT_TIMESTAMP
+                      WHERE id = (SELECT id FROM jobs WHERE status = ? ORDER BY id LIMIT 1) RETURNING id`,
+		JobRunning, JobQueued).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute update: %w", err)
+	}
+	return js.GetJobById(id)
+}
+
+// UpdateJobProgress records the progress of the job `k` while it is running.
+func (js *JobService) UpdateJobProgress(k uint32, progress float64) error {
+	return js.updateItem("UPDATE jobs SET progress = ? WHERE id = ? AND status = ?", []interface{}{progress, k, JobRunning})
+}
+
+//FinishJob
+/*
+Records the outcome of the running job `k`: `status` is JobSucceeded along with its `result`,
+or JobFailed along with `message`. A job cancelled while it was running stays cancelled.
+*/
+func (js *JobService) FinishJob(k uint32, status string, message string, result *container.JobResult) error {
+	if result == nil {
+		result = &container.JobResult{Header: []byte("{}")}
+	}
+	progress := 0.0
+	if status == JobSucceeded {
+		progress = 1
+	}
+	return js.updateItem(`UPDATE jobs SET status = ?, error = ?, progress = ?, result_status = ?, result_header = ?, result = ?,
+                      finished_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		[]interface{}{status, message, progress, result.Status, string(result.Header), result.Body, k, JobRunning})
+}
+
+//RetryJob
+/*
+Queues the running job `k` again after it failed with `message`, unless it was cancelled in the meantime.
+*/
+func (js *JobService) RetryJob(k uint32, message string) error {
+	return js.updateItem("UPDATE jobs SET status = ?, error = ?, progress = 0 WHERE id = ? AND status = ?",
+		[]interface{}{JobQueued, message, k, JobRunning})
+}
+
+//CancelJob
+/*
+Cancels the job `k` if it is queued or running, returning false when it had already finished.
+*/
+func (js *JobService) CancelJob(k uint32) (bool, error) {
+	res, err := js.db.Exec(`UPDATE jobs SET status = ?, finished_at = CURRENT_TIMESTAMP WHERE id = ? AND status IN (?, ?)`,
+		JobCancelled, k, JobQueued, JobRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to execute update: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+//RecoverJobs
+/*
+Queues again the jobs left running when the application stopped, failing those already started `maxAttempts` times.
+*/
+func (js *JobService) RecoverJobs(maxAttempts int) error {
+	return js.transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE jobs SET status = ?, error = 'interrupted too many times', finished_at = CURRENT_TIMESTAMP
+                      WHERE status = ? AND attempts >= ?`, JobFailed, JobRunning, maxAttempts); err != nil {
+			return fmt.Errorf("failed to execute update: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE jobs SET status = ?, error = 'interrupted', progress = 0 WHERE status = ?",
+			JobQueued, JobRunning); err != nil {
+			return fmt.Errorf("failed to execute update: %w", err)
+		}
+		return nil
+	})
+}
+
+//GetJobResult
+/*
+Returns the result recorded for the job `k`, or nil when there is no such job.
+*/
+func (js *JobService) GetJobResult(k uint32) (*container.JobResult, error) {
+	result := &container.JobResult{}
+	var header string
+	err := js.db.QueryRow("SELECT result_status, result_header, result FROM jobs WHERE id = ?", k).
+		Scan(&result.Status, &header, &result.Body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Header = []byte(header)
+	return result, nil
+}
+
+// DeleteFinishedJobs deletes the jobs that finished before `t` along with their results.
+func (js *JobService) DeleteFinishedJobs(t time.Time) error {
+	return js.deleteItems("DELETE FROM jobs WHERE finished_at IS NOT NULL AND finished_at < ?",
+		[]interface{}{t.UTC().Format(time.DateTime)})
+}
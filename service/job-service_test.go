@@ -0,0 +1,242 @@
+package service
+
+import (
+	constants "api-3390/const"
+	"api-3390/container"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestJobService(t *testing.T) *JobService {
+	t.Helper()
+	return NewJobService(newTestDB(t, constants.JobTable))
+}
+
+func createJobs(t *testing.T, js *JobService, n int) []uint32 {
+	t.Helper()
+	ids := make([]uint32, n)
+	for i := range ids {
+		job := &container.Job{FileID: 1, Operation: "stats", Query: "operation=stats&columns=price"}
+		if err := js.CreateJob(job); err != nil {
+			t.Fatal(err)
+		}
+		if job.ID == 0 || job.Status != JobQueued {
+			t.Fatalf("created job %+v, want a queued job with an id", job)
+		}
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func claim(t *testing.T, js *JobService) *container.Job {
+	t.Helper()
+	job, err := js.ClaimJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func status(t *testing.T, js *JobService, id uint32) *container.Job {
+	t.Helper()
+	job, err := js.GetJobById(id)
+	if err != nil || job == nil {
+		t.Fatalf("job %d: %v", id, err)
+	}
+	return job
+}
+
+func TestClaimJob(t *testing.T) {
+	js := newTestJobService(t)
+	ids := createJobs(t, js, 2)
+	for _, id := range ids {
+		job := claim(t, js)
+		if job == nil || job.ID != id {
+			t.Fatalf("claimed %+v, want job %d", job, id)
+		}
+		if job.Status != JobRunning || job.Attempts != 1 || job.StartedAt == nil {
+			t.Errorf("claimed job is %+v, want it running on its first attempt", job)
+		}
+	}
+	if job := claim(t, js); job != nil {
+		t.Errorf("claimed %+v, want no job left", job)
+	}
+}
+
+func TestClaimJobOnce(t *testing.T) {
+	js := newTestJobService(t)
+	createJobs(t, js, 50)
+	var mu sync.Mutex
+	claimed := make(map[uint32]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := js.ClaimJob()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(claimed) != 50 {
+		t.Errorf("claimed %d jobs, want 50", len(claimed))
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("job %d was claimed %d times", id, n)
+		}
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	js := newTestJobService(t)
+	id := createJobs(t, js, 1)[0]
+	claim(t, js)
+	if err := js.UpdateJobProgress(id, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.RetryJob(id, "disk error"); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, id); job.Status != JobQueued || job.Error != "disk error" || job.Progress != 0 {
+		t.Errorf("retried job is %+v, want it queued again with its error", job)
+	}
+	if job := claim(t, js); job == nil || job.ID != id || job.Attempts != 2 {
+		t.Errorf("claimed %+v, want job %d on its second attempt", job, id)
+	}
+	// a job cancelled while it runs is not queued again
+	if _, err := js.CancelJob(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.RetryJob(id, "disk error"); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, id); job.Status != JobCancelled {
+		t.Errorf("job is %s after a retry once cancelled, want %s", job.Status, JobCancelled)
+	}
+}
+
+func TestFinishJob(t *testing.T) {
+	js := newTestJobService(t)
+	ids := createJobs(t, js, 2)
+	claim(t, js)
+	result := &container.JobResult{Status: 200, Header: []byte(`{"Content-Type":["application/json"]}`), Body: []byte(`{"n":1}`)}
+	if err := js.FinishJob(ids[0], JobSucceeded, "", result); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, ids[0]); job.Status != JobSucceeded || job.Progress != 1 || job.FinishedAt == nil {
+		t.Errorf("finished job is %+v, want it succeeded", job)
+	}
+	got, err := js.GetJobResult(ids[0])
+	if err != nil || got == nil {
+		t.Fatalf("result: %v", err)
+	}
+	if got.Status != 200 || string(got.Header) != string(result.Header) || string(got.Body) != string(result.Body) {
+		t.Errorf("result is %+v, want %+v", got, result)
+	}
+
+	// the outcome of a job cancelled while it runs is dropped
+	claim(t, js)
+	if _, err := js.CancelJob(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.FinishJob(ids[1], JobSucceeded, "", result); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, ids[1]); job.Status != JobCancelled {
+		t.Errorf("job is %s after finishing once cancelled, want %s", job.Status, JobCancelled)
+	}
+	if result, err := js.GetJobResult(12345); err != nil || result != nil {
+		t.Errorf("result of a missing job is %+v, %v, want none", result, err)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	js := newTestJobService(t)
+	ids := createJobs(t, js, 2)
+	cancelled, err := js.CancelJob(ids[0])
+	if err != nil || !cancelled {
+		t.Fatalf("cancelling a queued job: %v, %v", cancelled, err)
+	}
+	if job := claim(t, js); job == nil || job.ID != ids[1] {
+		t.Errorf("claimed %+v, want the job that was not cancelled", job)
+	}
+	if err := js.FinishJob(ids[1], JobFailed, "bad column", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if cancelled, err := js.CancelJob(id); err != nil || cancelled {
+			t.Errorf("cancelling finished job %d: %v, %v, want it left as is", id, cancelled, err)
+		}
+	}
+	if job := status(t, js, ids[1]); job.Status != JobFailed || job.Error != "bad column" {
+		t.Errorf("failed job is %+v", job)
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	js := newTestJobService(t)
+	ids := createJobs(t, js, 3)
+	// the first job is interrupted on its last attempt, the second on its first, the third is still queued
+	for attempt := 0; attempt < 3; attempt++ {
+		if job := claim(t, js); job == nil || job.ID != ids[0] {
+			t.Fatalf("claimed %+v, want job %d", job, ids[0])
+		}
+		if attempt < 2 {
+			if err := js.RetryJob(ids[0], "interrupted"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if job := claim(t, js); job == nil || job.ID != ids[1] {
+		t.Fatalf("claimed %+v, want job %d", job, ids[1])
+	}
+	if err := js.RecoverJobs(3); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, ids[0]); job.Status != JobFailed || job.FinishedAt == nil {
+		t.Errorf("job interrupted on its last attempt is %+v, want it failed", job)
+	}
+	if job := status(t, js, ids[1]); job.Status != JobQueued || job.Attempts != 1 {
+		t.Errorf("interrupted job is %+v, want it queued again", job)
+	}
+	if job := status(t, js, ids[2]); job.Status != JobQueued || job.Attempts != 0 {
+		t.Errorf("queued job is %+v, want it left as is", job)
+	}
+}
+
+func TestDeleteFinishedJobs(t *testing.T) {
+	js := newTestJobService(t)
+	ids := createJobs(t, js, 2)
+	claim(t, js)
+	if err := js.FinishJob(ids[0], JobFailed, "bad column", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.DeleteFinishedJobs(time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if job := status(t, js, ids[0]); job == nil {
+		t.Fatal("a job finished within the retention was deleted")
+	}
+	if err := js.DeleteFinishedJobs(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := js.GetJobById(ids[0]); err != nil || job != nil {
+		t.Errorf("finished job is %+v, %v, want it deleted", job, err)
+	}
+	if job := status(t, js, ids[1]); job.Status != JobQueued {
+		t.Errorf("queued job is %+v, want it kept", job)
+	}
+}
@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 )
 
 type service[T any, K comparable] interface {
@@ -12,6 +14,7 @@ type service[T any, K comparable] interface {
 	insertItem(query string, args []interface{}) error
 	getItem(query string, args []interface{}, scan func(t *T, rows *sql.Rows) error) (*T, error)
 	getAllItems(query string, args []interface{}, scan func(t *T, rows *sql.Rows) error) ([]*T, error)
+	transaction(fn func(tx *sql.Tx) error) error
 }
 type genericService[T any, K comparable] struct {
 	db *sql.DB
@@ -108,3 +111,37 @@ func (s *genericService[T, K]) getAllItems(query string, args []interface{}, sca
 	}
 	return items, nil
 }
+
+//transaction
+/*
+Runs `fn` inside a database transaction, the transaction is committed if `fn` returns nil,
+otherwise it is rolled back and the error returned by `fn` is propagated.
+
+SQLite keeps a transaction open when its commit fails, e.g. on a deferred constraint or a busy database,
+so it is rolled back on its connection before the connection is handed back to the pool.
+*/
+func (s *genericService[T, K]) transaction(fn func(tx *sql.Tx) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		if _, rbErr := conn.ExecContext(ctx, "ROLLBACK"); rbErr != nil && !strings.Contains(rbErr.Error(), "no transaction") {
+			return fmt.Errorf("failed to commit transaction: %w (rollback failed: %v)", err, rbErr)
+		}
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
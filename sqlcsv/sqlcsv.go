@@ -0,0 +1,579 @@
+package sqlcsv
+
+import (
+	"api-3390/csvutil"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// CacheDir holds the SQLite databases CSV files are loaded into, named after the content of the file they hold.
+var CacheDir = filepath.Join(os.TempDir(), "api-3390-sql")
+
+// maxCachedFiles is the number of loaded files kept in CacheDir, the least recently used are removed beyond it.
+const maxCachedFiles = 32
+
+// MaxSources is the number of files a single query can read, the number of databases SQLite can attach.
+const MaxSources = 10
+
+// Limits of a query, the defaults are used when Options leaves them unset.
+const (
+	DefaultMaxRows = 1000
+	MaxRows        = 10000
+	DefaultTimeout = 10 * time.Second
+	MaxTimeout     = 60 * time.Second
+)
+
+// ctxCheckInterval is the number of records read between checks of whether a load was abandoned.
+const ctxCheckInterval = 4096
+
+// dataTable is the name of the table a file is loaded into within its database.
+const dataTable = "data"
+
+// builds holds the databases being built in CacheDir by their path, so a file requested twice at once is only loaded
+// once while files that are not the same are loaded concurrently, see load.
+var builds = struct {
+	sync.Mutex
+	running map[string]chan struct{}
+}{running: make(map[string]chan struct{})}
+
+//Source
+/*
+A CSV file read as the table `Table`.
+`Version` identifies the content of the file, e.g. its SHA-256, the loaded table is cached under it when it is set.
+*/
+type Source struct {
+	Table     string
+	Path      string
+	Version   string
+	Delimiter rune
+}
+
+//Options
+/*
+The limits of a query: at most `MaxRows` rows are returned and the query is interrupted after `Timeout`,
+which includes the time taken to load its files.
+*/
+type Options struct {
+	MaxRows int
+	Timeout time.Duration
+}
+
+// Column is a column of a loaded table along with the type inferred for it, INTEGER, REAL or TEXT.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Table is a table a query could read from.
+type Table struct {
+	Name    string   `json:"name"`
+	Columns []Column `json:"columns"`
+}
+
+//Result
+/*
+The rows returned by a query, each holding a value for every column that is a string, an int64, a float64 or nil.
+Truncated is set when the query returned more rows than the limit, Tables lists the tables the query could read.
+*/
+type Result struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+	Tables    []*Table        `json:"tables"`
+}
+
+//TableName
+/*
+Returns the name of the table a file is read as: its name without the extension,
+with every character that is not a letter, digit or underscore replaced by an underscore, e.g. 'prices 2024.csv' is 'prices_2024'.
+*/
+func TableName(fileName string) string {
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+//Query
+/*
+Runs the read-only `query` over the files of `sources` within the limits of `opts`.
+
+Each file is loaded into a table of its own SQLite database, with the type of every column inferred from its values,
+unless a database holding the same version of the file is already cached, see load.
+The query runs on a fresh in-memory connection that only has the databases of the sources attached, read-only,
+each exposed as a view named after its table. The connection can attach no other database and cannot write,
+and the query must be a single SELECT statement, so the database of the application is out of its reach.
+A `StatementError` is returned when the query is rejected, fails or does not finish in time.
+*/
+func Query(ctx context.Context, sources []Source, query string, opts Options) (*Result, error) {
+	query, err := checkStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	maxRows := opts.MaxRows
+	if maxRows == 0 {
+		maxRows = DefaultMaxRows
+	}
+	if maxRows < 0 || maxRows > MaxRows {
+		return nil, &StatementError{Message: fmt.Sprintf("max_rows must be between 1 and %d", MaxRows)}
+	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	if timeout < 0 || timeout > MaxTimeout {
+		return nil, &StatementError{Message: fmt.Sprintf("the timeout must be at most %s", MaxTimeout)}
+	}
+	if len(sources) == 0 || len(sources) > MaxSources {
+		return nil, &StatementError{Message: fmt.Sprintf("between 1 and %d files must be queried", MaxSources)}
+	}
+	tables := make(map[string]bool, len(sources))
+	for _, src := range sources {
+		if tables[strings.ToLower(src.Table)] {
+			return nil, &StatementError{Message: fmt.Sprintf("more than one file is read as the table '%s'", src.Table)}
+		}
+		tables[strings.ToLower(src.Table)] = true
+	}
+
+	// loading the files counts against the time the query is given
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	paths := make([]string, len(sources))
+	for i, src := range sources {
+		path, cleanup, err := load(ctx, src)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, queryError(ctx, err, timeout)
+			}
+			return nil, fmt.Errorf("unable to load '%s': %w", src.Table, err)
+		}
+		defer cleanup()
+		paths[i] = path
+	}
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &Result{Rows: [][]interface{}{}}
+	for i, src := range sources {
+		schema := fmt.Sprintf("f%d", i)
+		uri := (&url.URL{Scheme: "file", Path: paths[i], RawQuery: "mode=ro"}).String()
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("ATTACH DATABASE ? AS %s", schema), uri); err != nil {
+			return nil, err
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TEMP VIEW %s AS SELECT * FROM %s.%s",
+			quoteIdentifier(src.Table), schema, dataTable)); err != nil {
+			return nil, &StatementError{Message: fmt.Sprintf("unable to create the table '%s': %v", src.Table, err)}
+		}
+		table, err := tableInfo(ctx, conn, schema, src.Table)
+		if err != nil {
+			return nil, err
+		}
+		result.Tables = append(result.Tables, table)
+	}
+	// lock the connection down before running the query
+	if _, err := sqlite.Limit(conn, sqlite3.SQLITE_LIMIT_ATTACHED, len(sources)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, queryError(ctx, err, timeout)
+	}
+	defer rows.Close()
+	if result.Columns, err = rows.Columns(); err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(result.Columns))
+	pointers := make([]interface{}, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(result.Rows) == maxRows {
+			result.Truncated = true
+			break
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, queryError(ctx, err, timeout)
+		}
+		row := make([]interface{}, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			row[i] = v
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err, timeout)
+	}
+	return result, nil
+}
+
+//queryError
+/*
+Wraps an error raised while running a query in a `StatementError`, reporting when the query ran out of time.
+*/
+func queryError(ctx context.Context, err error, timeout time.Duration) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &StatementError{Message: fmt.Sprintf("the query did not finish within %s", timeout)}
+	}
+	return &StatementError{Message: err.Error()}
+}
+
+func tableInfo(ctx context.Context, conn *sql.Conn, schema, name string) (*Table, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT name, type FROM pragma_table_info(?, ?)", dataTable, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	table := &Table{Name: name}
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type); err != nil {
+			return nil, err
+		}
+		table.Columns = append(table.Columns, c)
+	}
+	return table, rows.Err()
+}
+
+//load
+/*
+Returns the path of a SQLite database holding the file of `src` in its table <dataTable>,
+along with a function to call once the database is no longer used. Loading stops once `ctx` is done.
+
+Databases are cached in CacheDir by the version of the file and its delimiter, so a file is only loaded again once its
+content changes, and only the <maxCachedFiles> most recently used are kept. A file requested while it is being loaded
+waits for that load rather than loading it again, and loads it itself if that load fails.
+Files without a version are loaded into a temporary database that is removed by the cleanup function.
+*/
+func load(ctx context.Context, src Source) (string, func(), error) {
+	if err := os.MkdirAll(CacheDir, os.ModePerm); err != nil {
+		return "", nil, err
+	}
+	if src.Version == "" {
+		tmp, err := buildTemp(ctx, src)
+		if err != nil {
+			return "", nil, err
+		}
+		return tmp, func() { os.Remove(tmp) }, nil
+	}
+
+	path := filepath.Join(CacheDir, fmt.Sprintf("%s-%x.db", filepath.Base(src.Version), src.Delimiter))
+	for {
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err == nil {
+			return path, func() {}, nil
+		}
+		builds.Lock()
+		done, running := builds.running[path]
+		if !running {
+			done = make(chan struct{})
+			builds.running[path] = done
+		}
+		builds.Unlock()
+		if running {
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+		}
+
+		err := func() error {
+			defer func() {
+				builds.Lock()
+				delete(builds.running, path)
+				builds.Unlock()
+				close(done)
+			}()
+			tmp, err := buildTemp(ctx, src)
+			if err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+			return nil
+		}()
+		if err != nil {
+			return "", nil, err
+		}
+		prune()
+		return path, func() {}, nil
+	}
+}
+
+// buildTemp loads the file of `src` into a new temporary database in CacheDir and returns its path.
+func buildTemp(ctx context.Context, src Source) (string, error) {
+	tmp, err := os.CreateTemp(CacheDir, "*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	if err := build(ctx, src, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+//prune
+/*
+Removes the least recently used databases from CacheDir until at most <maxCachedFiles> are left,
+a database still attached by a running query stays readable until it is detached.
+*/
+func prune() {
+	paths, err := filepath.Glob(filepath.Join(CacheDir, "*.db"))
+	if err != nil || len(paths) <= maxCachedFiles {
+		return
+	}
+	used := make(map[string]time.Time, len(paths))
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil {
+			used[p] = info.ModTime()
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return used[paths[i]].After(used[paths[j]]) })
+	for _, p := range paths[maxCachedFiles:] {
+		os.Remove(p)
+	}
+}
+
+//columnType
+/*
+Tracks which SQLite types every value of a column fits, empty fields fit every type and columns without values are TEXT.
+*/
+type columnType struct {
+	values        int64
+	integer, real bool
+}
+
+func (t *columnType) add(field string) {
+	field = strings.TrimSpace(field)
+	if field == "" {
+		return
+	}
+	t.values++
+	// only numbers written as they would be stored are numeric, so codes with leading zeros stay text
+	if t.integer {
+		v, err := strconv.ParseInt(field, 10, 64)
+		t.integer = err == nil && strconv.FormatInt(v, 10) == field
+	}
+	if t.real {
+		_, err := strconv.ParseFloat(field, 64)
+		t.real = err == nil && !strings.ContainsAny(field, "xXnN_") && !(len(field) > 1 && field[0] == '0' && field[1] != '.')
+	}
+}
+
+func (t *columnType) name() string {
+	switch {
+	case t.values == 0:
+	case t.integer:
+		return "INTEGER"
+	case t.real:
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+//value
+/*
+Converts a field to a value of the type `typ`, empty fields are NULL.
+*/
+func value(typ string, field string) interface{} {
+	trimmed := strings.TrimSpace(field)
+	if trimmed == "" {
+		return nil
+	}
+	switch typ {
+	case "INTEGER":
+		v, _ := strconv.ParseInt(trimmed, 10, 64)
+		return v
+	case "REAL":
+		v, _ := strconv.ParseFloat(trimmed, 64)
+		return v
+	}
+	return field
+}
+
+//columnNames
+/*
+Returns the names of the columns of a table loaded from a file with `header`,
+empty names are replaced by 'column_<n>' and repeated names, compared case-insensitively as SQLite does, get a suffix.
+*/
+func columnNames(header []string) []string {
+	names := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, h := range header {
+		name := strings.TrimSpace(h)
+		if name == "" {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		unique := name
+		for n := 2; seen[strings.ToLower(unique)]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return names
+}
+
+//build
+/*
+Loads the CSV file of `src` into the table <dataTable> of a new SQLite database at `path`,
+reading the file once to infer the type of each column and once more to insert its rows.
+Fields beyond the header are dropped and missing fields are NULL. The load is abandoned once `ctx` is done.
+*/
+func build(ctx context.Context, src Source, path string) error {
+	types, header, err := inferTypes(ctx, src)
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF"); err != nil {
+		return err
+	}
+	names := columnNames(header)
+	definitions := make([]string, len(names))
+	placeholders := make([]string, len(names))
+	for i, name := range names {
+		definitions[i] = quoteIdentifier(name) + " " + types[i].name()
+		placeholders[i] = "?"
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", dataTable, strings.Join(definitions, ", "))); err != nil {
+		return err
+	}
+
+	file, err := os.Open(src.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := csvutil.NewReader(file, src.Delimiter)
+	if _, err := reader.Read(); err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)", dataTable, strings.Join(placeholders, ", ")))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	args := make([]interface{}, len(names))
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		for i := range args {
+			args[i] = nil
+			if i < len(record) {
+				args[i] = value(types[i].name(), record[i])
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//inferTypes
+/*
+Reads the CSV file of `src` and returns the types every value of each column fits along with its header,
+unless `ctx` is done before the whole file is read.
+*/
+func inferTypes(ctx context.Context, src Source) ([]*columnType, []string, error) {
+	file, err := os.Open(src.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	reader := csvutil.NewReader(file, src.Delimiter)
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errors.New("file is empty")
+		}
+		return nil, nil, err
+	}
+	header = append([]string(nil), header...)
+	types := make([]*columnType, len(header))
+	for i := range types {
+		types[i] = &columnType{integer: true, real: true}
+	}
+	for n := 1; ; n++ {
+		if n%ctxCheckInterval == 0 && ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, nil, err
+		}
+		for i, t := range types {
+			if i < len(record) {
+				t.add(record[i])
+			}
+		}
+	}
+	return types, header, nil
+}
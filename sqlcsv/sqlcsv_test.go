@@ -0,0 +1,119 @@
+package sqlcsv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeCSV writes a CSV file of `rows` records to a temporary directory and points CacheDir at a temporary directory.
+func writeCSV(t *testing.T, rows int) string {
+	t.Helper()
+	CacheDir = t.TempDir()
+	var b strings.Builder
+	b.WriteString("id,payer,price\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&b, "%d,payer%d,%d.5\n", i, i%3, i)
+	}
+	path := filepath.Join(t.TempDir(), "prices.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func count(t *testing.T, src Source) int64 {
+	t.Helper()
+	result, err := Query(context.Background(), []Source{src}, "SELECT count(*) FROM prices", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Rows[0][0].(int64)
+}
+
+func TestQuerySandbox(t *testing.T) {
+	path := writeCSV(t, 10)
+	src := Source{Table: "prices", Path: path, Version: "v1"}
+	outside := filepath.Join(t.TempDir(), "outside.db")
+	for _, query := range []string{
+		"SELECT load_extension('/tmp/evil.so')",
+		"ATTACH DATABASE '" + outside + "' AS outside",
+		"SELECT 1; ATTACH DATABASE '" + outside + "' AS outside",
+		"WITH x AS (SELECT 1) INSERT INTO prices SELECT 1, 'a', 1 FROM x",
+		"WITH x AS (SELECT 1) DELETE FROM f0.data",
+		"WITH x AS (SELECT 1) UPDATE f0.data SET price = 0",
+		"WITH x AS (SELECT 1) INSERT INTO temp.data SELECT * FROM x",
+		"SELECT readfile('/etc/passwd')",
+		"SELECT writefile('" + outside + "', 'x')",
+		"DROP VIEW prices",
+		"PRAGMA query_only = OFF",
+	} {
+		_, err := Query(context.Background(), []Source{src}, query, Options{})
+		var statementError *StatementError
+		if !errors.As(err, &statementError) {
+			t.Errorf("%q: got %v, want a StatementError", query, err)
+		}
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Errorf("a query created %s", outside)
+	}
+	if n := count(t, src); n != 10 {
+		t.Errorf("the table holds %d rows after the rejected queries, want 10", n)
+	}
+}
+
+func TestQueryConcurrentLoads(t *testing.T) {
+	path := writeCSV(t, 20000)
+	src := Source{Table: "prices", Path: path, Version: "v1"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := Query(context.Background(), []Source{src}, "SELECT sum(price) FROM prices", Options{})
+			if err == nil && result.Rows[0][0] != 200000000.0 {
+				err = fmt.Errorf("sum is %v", result.Rows[0][0])
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	databases, _ := filepath.Glob(filepath.Join(CacheDir, "*"))
+	if len(databases) != 1 {
+		t.Errorf("cache holds %v, want a single database", databases)
+	}
+}
+
+func TestQueryTimeoutIncludesLoad(t *testing.T) {
+	path := writeCSV(t, 30000)
+	src := Source{Table: "prices", Path: path, Version: "v1"}
+	start := time.Now()
+	_, err := Query(context.Background(), []Source{src}, "SELECT 1", Options{Timeout: time.Millisecond})
+	var statementError *StatementError
+	if !errors.As(err, &statementError) || !strings.Contains(err.Error(), "did not finish") {
+		t.Fatalf("got %v, want the query to run out of time", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the load was abandoned after %s", elapsed)
+	}
+	if leftover, _ := filepath.Glob(filepath.Join(CacheDir, "*")); len(leftover) != 0 {
+		t.Errorf("an abandoned load left %v", leftover)
+	}
+	// the load is not held up by the abandoned one
+	if n := count(t, src); n != 30000 {
+		t.Errorf("the table holds %d rows, want 30000", n)
+	}
+}
@@ -0,0 +1,109 @@
+package sqlcsv
+
+import (
+	"strings"
+)
+
+// readOnlyKeywords are the keywords a query may start with.
+var readOnlyKeywords = []string{"SELECT", "WITH", "VALUES"}
+
+//StatementError
+/*
+Returned when a query is not a single read-only statement, or fails to run in the sandbox.
+*/
+type StatementError struct {
+	Message string
+}
+
+func (e *StatementError) Error() string {
+	return e.Message
+}
+
+//checkStatement
+/*
+Checks that `query` is a single statement starting with one of readOnlyKeywords and returns it without a trailing semicolon.
+String literals, quoted identifiers and comments are skipped so a semicolon inside them does not end the statement.
+Statements that still try to write are rejected by the sandbox itself, see Query.
+*/
+func checkStatement(query string) (string, error) {
+	end := -1
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case end != -1 && !isSpace(c) && !strings.HasPrefix(query[i:], "--") && !strings.HasPrefix(query[i:], "/*"):
+			return "", &StatementError{Message: "only a single statement can be run"}
+		case c == '\'' || c == '"' || c == '`':
+			j := strings.IndexByte(query[i+1:], c)
+			if j == -1 {
+				return "", &StatementError{Message: "unterminated quote"}
+			}
+			i += j + 1
+		case c == '[':
+			j := strings.IndexByte(query[i+1:], ']')
+			if j == -1 {
+				return "", &StatementError{Message: "unterminated quote"}
+			}
+			i += j + 1
+		case strings.HasPrefix(query[i:], "--"):
+			j := strings.IndexByte(query[i:], '\n')
+			if j == -1 {
+				j = len(query) - i
+			}
+			i += j
+		case strings.HasPrefix(query[i:], "/*"):
+			j := strings.Index(query[i+2:], "*/")
+			if j == -1 {
+				j = len(query) - i - 2
+			}
+			i += j + 3
+		case c == ';':
+			if end == -1 {
+				end = i
+			}
+		}
+	}
+	if end != -1 {
+		query = query[:end]
+	}
+	keyword := strings.ToUpper(firstWord(query))
+	for _, k := range readOnlyKeywords {
+		if keyword == k {
+			return query, nil
+		}
+	}
+	return "", &StatementError{Message: "only SELECT statements can be run"}
+}
+
+//firstWord
+/*
+Returns the first word of `query` after any leading whitespace, comments and parentheses.
+*/
+func firstWord(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(query, "--"):
+			if j := strings.IndexByte(query, '\n'); j != -1 {
+				query = query[j:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(query, "/*"):
+			if j := strings.Index(query, "*/"); j != -1 {
+				query = query[j+2:]
+				continue
+			}
+			return ""
+		}
+		end := strings.IndexFunc(query, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+		})
+		if end == -1 {
+			return query
+		}
+		return query[:end]
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}